@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+)
+
+const (
+	maxBodyBytes = 1024 * 1024
+	// replayedHeader marks a response given again to a retried load.
+	replayedHeader = "Idempotent-Replayed"
+)
+
+// Query answers the remaining limits and the ledger of customers.
+type Query interface {
+	handler.RemainingQuery
+	handler.LedgerQuery
+}
+
+// API answers load requests synchronously over HTTP.
+type API struct {
+	handle handler.HandlerTransaction
+	query  Query
+	mux    *http.ServeMux
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func New(handle handler.HandlerTransaction, query Query) *API {
+	a := &API{
+		handle: handle,
+		query:  query,
+		mux:    http.NewServeMux(),
+	}
+	a.mux.HandleFunc("/loads", a.evaluate(handle.Process))
+	a.mux.HandleFunc("/loads/check", a.evaluate(handle.Check))
+	a.mux.HandleFunc("/remaining", a.remaining)
+	a.mux.HandleFunc("/balance", a.balance)
+	a.mux.HandleFunc("/statement", a.statement)
+	return a
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// evaluate answers the transaction in the body with the result of process.
+// Accepted and rejected loads are answered with 200, as are retries given
+// their original response, malformed loads with 400, conflicting IDs with 409
+// and failures to evaluate the load with 500.
+func (a *API) evaluate(process func(fund []byte) handler.Result) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		fund, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeResult(w, process(fund))
+	}
+}
+
+// remaining answers what the customer_id in the query can still load in the
+// day, week, month and year of time, an RFC 3339 timestamp defaulting to
+// now. The month and year are only answered when their limits are set.
+func (a *API) remaining(w http.ResponseWriter, r *http.Request) {
+	customerID, ok := customerQuery(w, r)
+	if !ok {
+		return
+	}
+	at := time.Now()
+	if raw := r.URL.Query().Get("time"); raw != "" {
+		var ok bool
+		if at, ok = parseTime(w, "time", raw); !ok {
+			return
+		}
+	}
+	remaining, err := a.query.Remaining(customerID, at)
+	writeQuery(w, remaining, err)
+}
+
+// balance answers the funds the customer_id in the query holds.
+func (a *API) balance(w http.ResponseWriter, r *http.Request) {
+	customerID, ok := customerQuery(w, r)
+	if !ok {
+		return
+	}
+	balance, err := a.query.Balance(customerID)
+	writeQuery(w, balance, err)
+}
+
+// statement answers the ledger entries of the customer_id in the query
+// between from and to, RFC 3339 timestamps that leave that end open when
+// missing.
+func (a *API) statement(w http.ResponseWriter, r *http.Request) {
+	customerID, ok := customerQuery(w, r)
+	if !ok {
+		return
+	}
+	var from, to time.Time
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		if from, ok = parseTime(w, "from", raw); !ok {
+			return
+		}
+	}
+	if raw := r.URL.Query().Get("to"); raw != "" {
+		if to, ok = parseTime(w, "to", raw); !ok {
+			return
+		}
+	}
+	statement, err := a.query.Statement(customerID, from, to)
+	writeQuery(w, statement, err)
+}
+
+// customerQuery returns the customer_id of a GET request, answering the
+// request itself when it has none or uses another method.
+func customerQuery(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return "", false
+	}
+	customerID := r.URL.Query().Get("customer_id")
+	if customerID == "" {
+		writeError(w, http.StatusBadRequest, "customer_id is required")
+		return "", false
+	}
+	return customerID, true
+}
+
+func parseTime(w http.ResponseWriter, name, raw string) (time.Time, bool) {
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// writeQuery answers the result of a query, or 501 when the ledger it needs
+// is not enabled and 500 for any other error.
+func writeQuery(w http.ResponseWriter, result interface{}, err error) {
+	switch {
+	case err == domain.ErrLedgerDisabled:
+		writeError(w, http.StatusNotImplemented, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func writeResult(w http.ResponseWriter, result handler.Result) {
+	status := http.StatusOK
+	switch result.Reason {
+	case domain.ReasonMalformedInput:
+		status = http.StatusBadRequest
+	case domain.ReasonDuplicateID, domain.ReasonIDConflict:
+		status = http.StatusConflict
+	default:
+		if result.Err != nil {
+			status = http.StatusInternalServerError
+		}
+	}
+	body := result.Event
+	if body == nil {
+		// The error event of the handler is already JSON.
+		body = result.Err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if result.Replayed {
+		w.Header().Set(replayedHeader, "true")
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	body, _ := json.Marshal(errorResponse{Error: message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
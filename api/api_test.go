@@ -0,0 +1,292 @@
+package api_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/api"
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoads(t *testing.T) {
+	fund := `{"id":"1","customer_id":"2","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`
+	testCases := []struct {
+		name           string
+		result         handler.Result
+		statusExpected int
+		bodyExpected   string
+		replayed       bool
+	}{
+		{
+			name:           "accepted",
+			result:         handler.Result{Event: []byte(`{"id":"1","customer_id":"2","accepted":true}`)},
+			statusExpected: http.StatusOK,
+			bodyExpected:   `{"id":"1","customer_id":"2","accepted":true}`,
+		},
+		{
+			name:           "rejected",
+			result:         handler.Result{Event: []byte(`{"id":"1","customer_id":"2","accepted":false}`), Reason: domain.ReasonDailyCountExceeded},
+			statusExpected: http.StatusOK,
+			bodyExpected:   `{"id":"1","customer_id":"2","accepted":false}`,
+		},
+		{
+			name:           "malformed",
+			result:         handler.Result{Event: []byte(`{"id":"1","customer_id":"2","accepted":false}`), Reason: domain.ReasonMalformedInput},
+			statusExpected: http.StatusBadRequest,
+			bodyExpected:   `{"id":"1","customer_id":"2","accepted":false}`,
+		},
+		{
+			name:           "malformed with error",
+			result:         handler.Result{Event: []byte(`{"id":"1","customer_id":"2","accepted":false}`), Err: []byte(`{"stage":"decode","kind":"malformed"}`), Reason: domain.ReasonMalformedInput},
+			statusExpected: http.StatusBadRequest,
+			bodyExpected:   `{"id":"1","customer_id":"2","accepted":false}`,
+		},
+		{
+			name:           "malformed without id",
+			result:         handler.Result{Err: []byte(`{"stage":"decode","kind":"malformed"}`), Reason: domain.ReasonMalformedInput},
+			statusExpected: http.StatusBadRequest,
+			bodyExpected:   `{"stage":"decode","kind":"malformed"}`,
+		},
+		{
+			name:           "duplicate",
+			result:         handler.Result{Event: []byte(`{"id":"1","customer_id":"2","accepted":false}`), Reason: domain.ReasonDuplicateID},
+			statusExpected: http.StatusConflict,
+			bodyExpected:   `{"id":"1","customer_id":"2","accepted":false}`,
+		},
+		{
+			name:           "conflict",
+			result:         handler.Result{Event: []byte(`{"id":"1","customer_id":"2","accepted":false}`), Reason: domain.ReasonIDConflict},
+			statusExpected: http.StatusConflict,
+			bodyExpected:   `{"id":"1","customer_id":"2","accepted":false}`,
+		},
+		{
+			name:           "replayed",
+			result:         handler.Result{Event: []byte(`{"id":"1","customer_id":"2","accepted":true}`), Replayed: true},
+			statusExpected: http.StatusOK,
+			bodyExpected:   `{"id":"1","customer_id":"2","accepted":true}`,
+			replayed:       true,
+		},
+		{
+			name:           "storage error",
+			result:         handler.Result{Err: []byte(`{"stage":"evaluate","kind":"storage"}`)},
+			statusExpected: http.StatusInternalServerError,
+			bodyExpected:   `{"stage":"evaluate","kind":"storage"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handle := &handler.HandlerMock{}
+			handle.On("Process", []byte(fund)).Return(tc.result).Once()
+			request := httptest.NewRequest(http.MethodPost, "/loads", strings.NewReader(fund))
+			recorder := httptest.NewRecorder()
+			api.New(handle, handle).ServeHTTP(recorder, request)
+			assert.Equal(t, tc.statusExpected, recorder.Code)
+			assert.Equal(t, tc.bodyExpected, recorder.Body.String())
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.replayed, recorder.Header().Get("Idempotent-Replayed") == "true")
+			handle.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoadsCheckShouldNotProcess(t *testing.T) {
+	fund := `{"id":"1","customer_id":"2","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`
+	event := `{"id":"1","customer_id":"2","accepted":false,"reason":"DAILY_COUNT_EXCEEDED"}`
+	handle := &handler.HandlerMock{}
+	handle.On("Check", []byte(fund)).Return(handler.Result{Event: []byte(event), Reason: domain.ReasonDailyCountExceeded}).Once()
+	request := httptest.NewRequest(http.MethodPost, "/loads/check", strings.NewReader(fund))
+	recorder := httptest.NewRecorder()
+	api.New(handle, handle).ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, event, recorder.Body.String())
+	handle.AssertExpectations(t)
+	handle.AssertNotCalled(t, "Process")
+}
+
+func TestLoadsShouldOnlyAcceptPost(t *testing.T) {
+	handle := &handler.HandlerMock{}
+	request := httptest.NewRequest(http.MethodGet, "/loads", nil)
+	recorder := httptest.NewRecorder()
+	api.New(handle, handle).ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, http.MethodPost, recorder.Header().Get("Allow"))
+	handle.AssertNotCalled(t, "Process")
+}
+
+func TestLoadsShouldRejectLargeBody(t *testing.T) {
+	handle := &handler.HandlerMock{}
+	request := httptest.NewRequest(http.MethodPost, "/loads", strings.NewReader(strings.Repeat("a", 2*1024*1024)))
+	recorder := httptest.NewRecorder()
+	api.New(handle, handle).ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	handle.AssertNotCalled(t, "Process")
+}
+
+func TestRemaining(t *testing.T) {
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	remaining := domain.RemainingLimits{
+		CustomerID:   "2",
+		Time:         at,
+		DailyAmount:  domain.NewMoney(100),
+		DailyCount:   2,
+		WeeklyAmount: domain.NewMoney(200),
+	}
+	testCases := []struct {
+		name           string
+		target         string
+		remaining      domain.RemainingLimits
+		err            error
+		called         bool
+		statusExpected int
+		bodyExpected   string
+	}{
+		{
+			name:           "remaining",
+			target:         "/remaining?customer_id=2&time=2000-01-03T10:00:00Z",
+			remaining:      remaining,
+			called:         true,
+			statusExpected: http.StatusOK,
+			bodyExpected:   `{"customer_id":"2","time":"2000-01-03T10:00:00Z","daily_amount":"$1.00","daily_count":2,"weekly_amount":"$2.00"}`,
+		},
+		{
+			name:           "without customer",
+			target:         "/remaining?time=2000-01-03T10:00:00Z",
+			statusExpected: http.StatusBadRequest,
+			bodyExpected:   `{"error":"customer_id is required"}`,
+		},
+		{
+			name:           "invalid time",
+			target:         "/remaining?customer_id=2&time=2000-01-03",
+			statusExpected: http.StatusBadRequest,
+			bodyExpected:   `{"error":"time must be an RFC 3339 timestamp"}`,
+		},
+		{
+			name:           "storage error",
+			target:         "/remaining?customer_id=2&time=2000-01-03T10:00:00Z",
+			err:            errors.New("some error"),
+			called:         true,
+			statusExpected: http.StatusInternalServerError,
+			bodyExpected:   `{"error":"some error"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handle := &handler.HandlerMock{}
+			if tc.called {
+				handle.On("Remaining", "2", at).Return(tc.remaining, tc.err).Once()
+			}
+			request := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			recorder := httptest.NewRecorder()
+			api.New(handle, handle).ServeHTTP(recorder, request)
+			assert.Equal(t, tc.statusExpected, recorder.Code)
+			assert.Equal(t, tc.bodyExpected, recorder.Body.String())
+			handle.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBalance(t *testing.T) {
+	testCases := []struct {
+		name           string
+		target         string
+		err            error
+		called         bool
+		statusExpected int
+		bodyExpected   string
+	}{
+		{
+			name:           "balance",
+			target:         "/balance?customer_id=2",
+			called:         true,
+			statusExpected: http.StatusOK,
+			bodyExpected:   `{"customer_id":"2","balance":"$1.00"}`,
+		},
+		{
+			name:           "without customer",
+			target:         "/balance",
+			statusExpected: http.StatusBadRequest,
+			bodyExpected:   `{"error":"customer_id is required"}`,
+		},
+		{
+			name:           "without ledger",
+			target:         "/balance?customer_id=2",
+			err:            domain.ErrLedgerDisabled,
+			called:         true,
+			statusExpected: http.StatusNotImplemented,
+			bodyExpected:   `{"error":"ledger is not enabled"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handle := &handler.HandlerMock{}
+			if tc.called {
+				handle.On("Balance", "2").Return(domain.Balance{CustomerID: "2", Balance: domain.NewMoney(100)}, tc.err).Once()
+			}
+			request := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			recorder := httptest.NewRecorder()
+			api.New(handle, handle).ServeHTTP(recorder, request)
+			assert.Equal(t, tc.statusExpected, recorder.Code)
+			assert.Equal(t, tc.bodyExpected, recorder.Body.String())
+			handle.AssertExpectations(t)
+		})
+	}
+}
+
+func TestStatement(t *testing.T) {
+	from := time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name           string
+		target         string
+		from           time.Time
+		called         bool
+		statusExpected int
+		bodyExpected   string
+	}{
+		{
+			name:           "whole ledger",
+			target:         "/statement?customer_id=2",
+			called:         true,
+			statusExpected: http.StatusOK,
+			bodyExpected:   `{"customer_id":"2","opening_balance":"$0.00","lines":[],"closing_balance":"$0.00"}`,
+		},
+		{
+			name:           "from time",
+			target:         "/statement?customer_id=2&from=2000-01-03T00:00:00Z",
+			from:           from,
+			called:         true,
+			statusExpected: http.StatusOK,
+			bodyExpected:   `{"customer_id":"2","opening_balance":"$0.00","lines":[],"closing_balance":"$0.00"}`,
+		},
+		{
+			name:           "invalid to",
+			target:         "/statement?customer_id=2&to=2000-01-03",
+			statusExpected: http.StatusBadRequest,
+			bodyExpected:   `{"error":"to must be an RFC 3339 timestamp"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handle := &handler.HandlerMock{}
+			if tc.called {
+				statement := domain.Statement{CustomerID: "2", OpeningBalance: domain.NewMoney(0), Lines: []domain.StatementLine{}, ClosingBalance: domain.NewMoney(0)}
+				handle.On("Statement", "2", tc.from, time.Time{}).Return(statement, nil).Once()
+			}
+			request := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			recorder := httptest.NewRecorder()
+			api.New(handle, handle).ServeHTTP(recorder, request)
+			assert.Equal(t, tc.statusExpected, recorder.Code)
+			assert.Equal(t, tc.bodyExpected, recorder.Body.String())
+			handle.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/config"
+	"github.com/danielfmelo/load-funds-handler/deadletter"
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+)
+
+const deadLetterCommand = "deadletter"
+
+const deadLetterUsage = `usage: %[1]s deadletter list -dir DIR [filters]
+       %[1]s deadletter retry -dir DIR [filters] [-config FILE] [-data-dir DIR] [-format FORMAT]
+`
+
+// deadLetters adds the funds the handler could not process to the queue.
+type deadLetters struct {
+	handler.HandlerTransaction
+	queue *deadletter.Queue
+}
+
+func (d deadLetters) Process(fund []byte) handler.Result {
+	result := d.HandlerTransaction.Process(fund)
+	if entry, ok := deadletter.FromResult(fund, result, time.Now()); ok {
+		if _, err := d.queue.Add(entry); err != nil {
+			log.Printf("error to dead-letter fund: %s", err)
+		}
+	}
+	return result
+}
+
+type deadLetterOptions struct {
+	dir        string
+	output     string
+	configFile string
+	dataDir    string
+	format     string
+	id         uint64
+	stage      string
+	kind       string
+	reason     string
+	since      string
+	until      string
+}
+
+// runDeadLetter runs the deadletter subcommand with the arguments after it.
+func runDeadLetter(args []string) int {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "retry") {
+		fmt.Fprintf(os.Stderr, deadLetterUsage, os.Args[0])
+		return exitUsage
+	}
+	action := args[0]
+	var opts deadLetterOptions
+	flags := flag.NewFlagSet(deadLetterCommand+" "+action, flag.ExitOnError)
+	flags.StringVar(&opts.dir, "dir", "", "directory of the dead-letter queue")
+	flags.StringVar(&opts.output, "output", stdStream, "file the entries or results are written to, or - for stdout")
+	flags.Uint64Var(&opts.id, "id", 0, "only the entry with this ID")
+	flags.StringVar(&opts.stage, "stage", "", "only entries that failed at this stage")
+	flags.StringVar(&opts.kind, "kind", "", "only entries with this kind of error")
+	flags.StringVar(&opts.reason, "reason", "", "only entries with this reason")
+	flags.StringVar(&opts.since, "since", "", "only entries dead-lettered at or after this RFC 3339 time")
+	flags.StringVar(&opts.until, "until", "", "only entries dead-lettered before this RFC 3339 time")
+	if action == "retry" {
+		flags.StringVar(&opts.configFile, "config", os.Getenv(config.EnvConfigFile), "JSON configuration file")
+		flags.StringVar(&opts.dataDir, "data-dir", "", "directory the state is kept in across runs")
+		flags.StringVar(&opts.format, "format", "", "response format, detailed or legacy; overrides the configuration")
+	}
+	flags.Parse(args[1:])
+	if opts.dir == "" {
+		log.Println("-dir is required")
+		return exitUsage
+	}
+	filter, err := deadLetterFilter(opts)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	queue, err := deadletter.Open(opts.dir)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer queue.Close()
+	output, err := openOutput(opts.output, os.Stdout)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer output.Close()
+
+	if action == "list" {
+		return listDeadLetters(output, queue, filter)
+	}
+	cfg, code := loadConfig(opts.configFile, opts.format, opts.dataDir)
+	if code != exitOK {
+		return code
+	}
+	if cfg.Storage.Type == config.StorageMemory {
+		// The entries would be evaluated against an empty state.
+		log.Println("retry requires the state of the original run: set -data-dir or a file or sql storage")
+		return exitUsage
+	}
+	handle, closeHandler, err := newHandler(cfg)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer func() {
+		if err := closeHandler(); err != nil {
+			log.Println(err)
+		}
+	}()
+	return retryDeadLetters(output, queue, filter, handle)
+}
+
+func deadLetterFilter(opts deadLetterOptions) (deadletter.Filter, error) {
+	filter := deadletter.Filter{
+		ID:     opts.id,
+		Stage:  domain.ErrorStage(opts.stage),
+		Kind:   domain.ErrorKind(opts.kind),
+		Reason: domain.Reason(opts.reason),
+	}
+	var err error
+	if opts.since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, opts.since); err != nil {
+			return filter, fmt.Errorf("error to parse -since: %w", err)
+		}
+	}
+	if opts.until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, opts.until); err != nil {
+			return filter, fmt.Errorf("error to parse -until: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// listedEntry shows the input of the entry as text instead of base64.
+type listedEntry struct {
+	deadletter.Entry
+	Input string `json:"input"`
+}
+
+func listDeadLetters(output io.Writer, queue *deadletter.Queue, filter deadletter.Filter) int {
+	out := bufio.NewWriter(output)
+	for _, entry := range queue.Entries(filter) {
+		if err := writeJSONLine(out, listedEntry{Entry: entry, Input: string(entry.Input)}); err != nil {
+			log.Printf("error to write output: %s", err)
+			return exitFailure
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("error to write output: %s", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// retryReport is the result of submitting an entry again. Resolved entries
+// are removed from the queue.
+type retryReport struct {
+	ID       uint64          `json:"id"`
+	Resolved bool            `json:"resolved"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    json.RawMessage `json:"error,omitempty"`
+}
+
+// retryDeadLetters submits the entries matching the filter to the handler,
+// oldest first, and writes a report for each one. It exits with
+// exitMalformed when some entries still fail.
+func retryDeadLetters(output io.Writer, queue *deadletter.Queue, filter deadletter.Filter, handle handler.HandlerTransaction) int {
+	out := bufio.NewWriter(output)
+	var unresolved int
+	for _, entry := range queue.Entries(filter) {
+		result := handle.Process(entry.Input)
+		resolved := deadletter.Resolved(result)
+		report := retryReport{ID: entry.ID, Resolved: resolved, Response: result.Event, Error: result.Err}
+		if !resolved {
+			unresolved++
+		} else if err := queue.Remove(entry.ID); err != nil {
+			log.Printf("error to remove dead-letter entry %d: %s", entry.ID, err)
+			return exitFailure
+		}
+		if err := writeJSONLine(out, report); err != nil {
+			log.Printf("error to write output: %s", err)
+			return exitFailure
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Printf("error to write output: %s", err)
+		return exitFailure
+	}
+	if unresolved > 0 {
+		log.Printf("%d dead-letter entries still fail", unresolved)
+		return exitMalformed
+	}
+	return exitOK
+}
+
+func writeJSONLine(w io.Writer, value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", line)
+	return err
+}
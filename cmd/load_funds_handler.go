@@ -2,71 +2,368 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/danielfmelo/load-funds-handler/config"
+	"github.com/danielfmelo/load-funds-handler/deadletter"
+	"github.com/danielfmelo/load-funds-handler/domain"
 	"github.com/danielfmelo/load-funds-handler/handler"
 	"github.com/danielfmelo/load-funds-handler/listener"
-	"github.com/danielfmelo/load-funds-handler/storage/memory"
 )
 
+const (
+	exitOK = 0
+	// exitFailure means the configuration, the input or the outputs could
+	// not be used.
+	exitFailure = 1
+	// exitUsage is also what the flag package exits with on invalid flags.
+	exitUsage = 2
+	// exitMalformed means every line was processed but some were malformed,
+	// or that some dead-letter entries still fail when retried.
+	exitMalformed   = 3
+	exitInterrupted = 130
+
+	stdStream    = "-"
+	maxLineBytes = 1024 * 1024
+)
+
+type options struct {
+	input      string
+	output     string
+	errors     string
+	configFile string
+	format     string
+	http       string
+	dryRun     bool
+	remaining  string
+	statement  string
+	at         string
+	dataDir    string
+	deadLetter string
+}
+
+func parseFlags() options {
+	var opts options
+	flag.StringVar(&opts.input, "input", "input.txt", "file with one load per line, or - for stdin")
+	flag.StringVar(&opts.output, "output", stdStream, "file the responses are written to, or - for stdout")
+	flag.StringVar(&opts.errors, "errors", "", "file the JSON error events are written to, or - for stderr; discarded when empty")
+	flag.StringVar(&opts.configFile, "config", os.Getenv(config.EnvConfigFile), "JSON configuration file")
+	flag.StringVar(&opts.dataDir, "data-dir", "", "directory the state is kept in across runs; kept in memory when empty unless configured")
+	flag.StringVar(&opts.format, "format", "", "response format, detailed or legacy; overrides the configuration")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "check every load against the current limits without recording it")
+	flag.StringVar(&opts.remaining, "remaining", "", "customer whose remaining limits are written to the output after the input")
+	flag.StringVar(&opts.statement, "statement", "", "customer whose ledger statement is written to the output after the input")
+	flag.StringVar(&opts.at, "at", "", "RFC 3339 time the remaining limits are computed at; now when empty")
+	flag.StringVar(&opts.deadLetter, "dead-letter", "", "directory the loads that could not be processed are kept in; not kept when empty")
+	flag.StringVar(&opts.http, "http", "", "address to serve the HTTP API on, e.g. :8080; the input is not read when set")
+	flag.Parse()
+	return opts
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == deadLetterCommand {
+		os.Exit(runDeadLetter(os.Args[2:]))
+	}
+	os.Exit(run(parseFlags()))
+}
+
+func run(opts options) int {
+	cfg, code := loadConfig(opts.configFile, opts.format, opts.dataDir)
+	if code != exitOK {
+		return code
+	}
+	handle, closeHandler, err := newHandler(cfg)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer func() {
+		if err := closeHandler(); err != nil {
+			log.Println(err)
+		}
+	}()
+	if opts.http != "" {
+		return serve(opts.http, handle)
+	}
+
+	at := time.Now()
+	if opts.at != "" {
+		if at, err = time.Parse(time.RFC3339, opts.at); err != nil {
+			log.Printf("error to parse -at: %s", err)
+			return exitUsage
+		}
+	}
+
+	input, err := openInput(opts.input)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer input.Close()
+	output, err := openOutput(opts.output, os.Stdout)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer output.Close()
+	errOutput, err := openOutput(opts.errors, os.Stderr)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer errOutput.Close()
+
+	var process handler.HandlerTransaction = handle
+	if opts.dryRun {
+		process = dryRun{handle}
+	}
+	if opts.deadLetter != "" {
+		queue, err := deadletter.Open(opts.deadLetter)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		defer queue.Close()
+		process = deadLetters{HandlerTransaction: process, queue: queue}
+	}
+	pool := listener.NewPool(process, cfg.Workers, cfg.OrderedOutput)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(cancel)
 
-	outputCh := make(chan []byte)
-	errCh := make(chan []byte)
 	inputCh := make(chan []byte)
-	database := memory.New()
-	handle := handler.New(database, outputCh, errCh)
-	listening := listener.New(handle)
+	results := pool.Receiver(ctx, inputCh)
+	summaryCh := writeOutput(results, output, errOutput)
+	readErr := readInput(ctx, input, inputCh)
+	summary := <-summaryCh
+
+	if opts.remaining != "" && summary.writeErr == nil {
+		if err := writeRemaining(output, handle, opts.remaining, at); err != nil {
+			log.Printf("error to write remaining limits: %s", err)
+			return exitFailure
+		}
+	}
+	if opts.statement != "" && summary.writeErr == nil {
+		if err := writeStatement(output, handle, opts.statement); err != nil {
+			log.Printf("error to write statement: %s", err)
+			return exitFailure
+		}
+	}
 
-	listening.Receiver(inputCh)
-	var wgOrderControl sync.WaitGroup
-	var wgReadAllControl sync.WaitGroup
-	readOutput(outputCh, errCh, &wgOrderControl, &wgReadAllControl)
-	readFile(inputCh, &wgOrderControl, &wgReadAllControl)
+	switch {
+	case readErr != nil:
+		log.Printf("error to read input %s: %s", opts.input, readErr)
+		return exitFailure
+	case summary.writeErr != nil:
+		log.Printf("error to write output: %s", summary.writeErr)
+		return exitFailure
+	case ctx.Err() != nil:
+		log.Println("interrupted before the whole input was read")
+		return exitInterrupted
+	case summary.malformed > 0:
+		log.Printf("%d malformed lines in input %s", summary.malformed, opts.input)
+		return exitMalformed
+	}
+	return exitOK
+}
 
-	wgOrderControl.Wait()
+// loadConfig loads the configuration and applies the flags overriding it.
+func loadConfig(configFile, format, dataDir string) (config.Config, int) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Println(err)
+		return cfg, exitFailure
+	}
+	if format != "" {
+		cfg.ResponseFormat = handler.ResponseFormat(format)
+		if err := cfg.ResponseFormat.Validate(); err != nil {
+			log.Println(err)
+			return cfg, exitUsage
+		}
+	}
+	if dataDir != "" {
+		cfg.Storage.Type = config.StorageFile
+		cfg.Storage.Dir = dataDir
+	}
+	return cfg, exitOK
 }
 
-func readFile(inputCh chan []byte, wgOrderControl *sync.WaitGroup, wgReadAllControl *sync.WaitGroup) {
-	file, err := os.Open("input.txt")
+// newHandler opens the storage of the configuration and returns a handler
+// on it, along with the function that closes the storage.
+func newHandler(cfg config.Config) (*handler.HandlerTransactionService, func() error, error) {
+	location, err := cfg.Location()
+	if err != nil {
+		return nil, nil, err
+	}
+	profiles, err := configProfiles(cfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
+	database, closeDatabase, err := openDatabase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Both the pool and the HTTP API read the results straight from the
+	// handler, so the handler does not need publishing channels.
+	handle := handler.New(
+		database,
+		cfg.Limits,
+		nil,
+		nil,
+		handler.WithCustomerLimits(profiles, cfg.Tiers),
+		handler.WithResponseFormat(cfg.ResponseFormat),
+		handler.WithLocation(location),
+		handler.WithLedger(database),
+	)
+	return handle, closeDatabase, nil
+}
+
+// dryRun makes the pool check the loads instead of processing them.
+type dryRun struct {
+	handler.HandlerTransaction
+}
+
+func (d dryRun) Process(fund []byte) handler.Result {
+	return d.Check(fund)
+}
+
+// handleSignals cancels on the first SIGINT or SIGTERM, letting the events
+// already read finish and the output be flushed. A second signal terminates
+// the process right away.
+func handleSignals(cancel context.CancelFunc) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		signal.Stop(sigCh)
+		cancel()
+	}()
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == stdStream {
+		return os.Stdin, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error to open input: %w", err)
+	}
+	return file, nil
+}
+
+// openOutput opens path for writing, returning std for "-" and a writer that
+// discards everything for an empty path.
+func openOutput(path string, std *os.File) (io.WriteCloser, error) {
+	switch path {
+	case "":
+		return nopCloser{ioutil.Discard}, nil
+	case stdStream:
+		return nopCloser{std}, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error to open output: %w", err)
+	}
+	return file, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// readInput sends every line of input until ctx is cancelled, and closes
+// inputCh at the end.
+func readInput(ctx context.Context, input io.Reader, inputCh chan []byte) error {
+	defer close(inputCh)
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineBytes)
 	for scanner.Scan() {
-		event := scanner.Text()
-		wgOrderControl.Add(1)
-		wgReadAllControl.Add(1)
-		inputCh <- []byte(event)
-		wgReadAllControl.Wait()
+		line := make([]byte, len(scanner.Bytes()))
+		copy(line, scanner.Bytes())
+		select {
+		case <-ctx.Done():
+			return nil
+		case inputCh <- line:
+		}
 	}
+	return scanner.Err()
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+func writeRemaining(output io.Writer, query handler.RemainingQuery, customerID string, at time.Time) error {
+	remaining, err := query.Remaining(customerID, at)
+	if err != nil {
+		return err
+	}
+	return writeJSONLine(output, remaining)
+}
+
+func writeStatement(output io.Writer, query handler.LedgerQuery, customerID string) error {
+	statement, err := query.Statement(customerID, time.Time{}, time.Time{})
+	if err != nil {
+		return err
 	}
+	return writeJSONLine(output, statement)
 }
 
-func readOutput(
-	outputCh chan []byte,
-	errCh chan []byte,
-	wgOrderControl *sync.WaitGroup,
-	wgReadAllControl *sync.WaitGroup,
-) {
+type summary struct {
+	malformed int
+	writeErr  error
+}
+
+// writeOutput writes the events of the results to output and the errors to
+// errOutput until the results channel is closed, flushes both and then sends
+// the summary.
+func writeOutput(results <-chan handler.Result, output, errOutput io.Writer) chan summary {
+	summaryCh := make(chan summary, 1)
 	go func() {
-		for {
-			select {
-			case record := <-outputCh:
-				fmt.Println(string(record))
-				wgOrderControl.Done()
-				wgReadAllControl.Done()
-			case _ = <-errCh:
-				wgOrderControl.Done()
-				wgReadAllControl.Done()
+		var s summary
+		out := bufio.NewWriter(output)
+		errOut := bufio.NewWriter(errOutput)
+		for result := range results {
+			if result.Reason == domain.ReasonMalformedInput {
+				s.malformed++
+			}
+			if result.Err != nil {
+				writeLine(errOut, result.Err, &s)
 			}
+			if result.Event != nil {
+				writeLine(out, result.Event, &s)
+			}
+		}
+		if err := out.Flush(); err != nil && s.writeErr == nil {
+			s.writeErr = err
+		}
+		if err := errOut.Flush(); err != nil && s.writeErr == nil {
+			s.writeErr = err
 		}
+		summaryCh <- s
 	}()
+	return summaryCh
+}
+
+func writeLine(w *bufio.Writer, line []byte, s *summary) {
+	if s.writeErr != nil {
+		return
+	}
+	if _, err := w.Write(line); err != nil {
+		s.writeErr = err
+		return
+	}
+	if err := w.WriteByte('\n'); err != nil {
+		s.writeErr = err
+	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/danielfmelo/load-funds-handler/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteOutputShouldReportMalformedRecordWithID(t *testing.T) {
+	h := handler.New(memory.New(), handler.DefaultLimits(), nil, nil)
+	fund := `{"id":"1","customer_id":"2","load_amount":"$abc","time":"2000-01-01T00:00:00Z"}`
+	results := make(chan handler.Result, 1)
+	var output, errOutput bytes.Buffer
+	summaryCh := writeOutput(results, &output, &errOutput)
+	results <- h.Process([]byte(fund))
+	close(results)
+	s := <-summaryCh
+	assert.Nil(t, s.writeErr)
+	assert.Equal(t, 1, s.malformed)
+
+	var response domain.TransactionResponse
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &response))
+	assert.Equal(t, domain.ReasonMalformedInput, response.Reason)
+	lines := strings.Split(strings.TrimSuffix(errOutput.String(), "\n"), "\n")
+	assert.Len(t, lines, 1)
+	var event domain.ErrorEvent
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &event))
+	assert.Equal(t, "1", event.TransactionID)
+	assert.Equal(t, domain.StageDecode, event.Stage)
+	assert.Equal(t, domain.ErrorKindMalformed, event.Kind)
+	assert.Equal(t, "error to unmarshal fund", event.Message)
+	assert.Equal(t, fund, event.Input)
+}
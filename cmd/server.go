@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/api"
+	"github.com/danielfmelo/load-funds-handler/handler"
+)
+
+const (
+	readTimeout     = 10 * time.Second
+	writeTimeout    = 10 * time.Second
+	shutdownTimeout = 10 * time.Second
+)
+
+// serve answers loads over HTTP on addr until SIGINT or SIGTERM, then waits
+// for the requests in flight before returning.
+func serve(addr string, handle *handler.HandlerTransactionService) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(cancel)
+
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      api.New(handle, handle),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	log.Printf("serving the HTTP API on %s", addr)
+
+	select {
+	case err := <-errCh:
+		log.Printf("error to serve the HTTP API: %s", err)
+		return exitFailure
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error to shut down the HTTP API: %s", err)
+		return exitFailure
+	}
+	return exitOK
+}
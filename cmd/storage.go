@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/danielfmelo/load-funds-handler/config"
+	"github.com/danielfmelo/load-funds-handler/storage"
+	"github.com/danielfmelo/load-funds-handler/storage/file"
+	"github.com/danielfmelo/load-funds-handler/storage/memory"
+	"github.com/danielfmelo/load-funds-handler/storage/sql"
+
+	// The SQLite driver, so the sql storage works without a database server.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type database interface {
+	storage.Database
+	storage.Ledger
+}
+
+// openDatabase returns the storage selected in cfg and the function that
+// closes it.
+func openDatabase(cfg config.Config) (database, func() error, error) {
+	switch cfg.Storage.Type {
+	case config.StorageFile:
+		d, err := file.Open(
+			cfg.Storage.Dir,
+			file.WithRetention(cfg.RetentionDays),
+			file.WithSyncPolicy(cfg.Storage.Sync),
+			file.WithSnapshotEvery(cfg.Storage.SnapshotEvery),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, d.Close, nil
+	case config.StorageSQL:
+		d, err := sql.Open(cfg.Storage.Driver, cfg.Storage.DSN, sql.WithRetention(cfg.RetentionDays))
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, d.Close, nil
+	}
+	d := memory.New(memory.WithRetention(cfg.RetentionDays))
+	return d, func() error { return nil }, nil
+}
+
+// configProfiles keeps the customer profiles of cfg in memory rather than in
+// the storage, so the configuration stays their only source: a tier or
+// override removed from it is gone on the next run.
+func configProfiles(cfg config.Config) (storage.CustomerProfiles, error) {
+	profiles := memory.New()
+	for _, profile := range cfg.Customers {
+		if err := profiles.SetCustomerProfile(profile); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
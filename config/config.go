@@ -0,0 +1,220 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/danielfmelo/load-funds-handler/storage/file"
+	"github.com/danielfmelo/load-funds-handler/storage/memory"
+	"github.com/danielfmelo/load-funds-handler/storage/sql"
+)
+
+const (
+	EnvConfigFile     = "LOAD_FUNDS_CONFIG"
+	EnvResponseFormat = "LOAD_FUNDS_RESPONSE_FORMAT"
+	EnvDailyAmount    = "LOAD_FUNDS_DAILY_AMOUNT"
+	EnvDailyCount     = "LOAD_FUNDS_DAILY_COUNT"
+	EnvWeeklyAmount   = "LOAD_FUNDS_WEEKLY_AMOUNT"
+	EnvMonthlyAmount  = "LOAD_FUNDS_MONTHLY_AMOUNT"
+	EnvYearlyAmount   = "LOAD_FUNDS_YEARLY_AMOUNT"
+	EnvWorkers        = "LOAD_FUNDS_WORKERS"
+)
+
+const (
+	StorageMemory = "memory"
+	StorageFile   = "file"
+	StorageSQL    = "sql"
+)
+
+// Storage selects where the state is kept. The file storage keeps it in Dir
+// and the sql storage in the database of Driver at DSN; both survive
+// restarts.
+type Storage struct {
+	Type          string          `json:"type"`
+	Dir           string          `json:"dir"`
+	Sync          file.SyncPolicy `json:"sync"`
+	SnapshotEvery int             `json:"snapshot_every"`
+	Driver        string          `json:"driver"`
+	DSN           string          `json:"dsn"`
+}
+
+func (s Storage) Validate() error {
+	switch s.Type {
+	case StorageMemory:
+		return nil
+	case StorageFile:
+		if s.Dir == "" {
+			return fmt.Errorf("file storage requires a dir")
+		}
+		if s.SnapshotEvery <= 0 {
+			return fmt.Errorf("snapshot every %d must be positive", s.SnapshotEvery)
+		}
+		return s.Sync.Validate()
+	case StorageSQL:
+		if s.DSN == "" {
+			return fmt.Errorf("sql storage requires a dsn")
+		}
+		return sql.CheckDriver(s.Driver)
+	}
+	return fmt.Errorf("unknown storage type %q", s.Type)
+}
+
+type Config struct {
+	ResponseFormat handler.ResponseFormat               `json:"response_format"`
+	Limits         handler.Limits                       `json:"limits"`
+	Tiers          map[domain.Tier]domain.LimitOverride `json:"tiers"`
+	Customers      []domain.CustomerProfile             `json:"customers"`
+	RetentionDays  int                                  `json:"retention_days"`
+	Workers        int                                  `json:"workers"`
+	OrderedOutput  bool                                 `json:"ordered_output"`
+	Storage        Storage                              `json:"storage"`
+	Timezone       string                               `json:"timezone"`
+}
+
+func Default() Config {
+	return Config{
+		ResponseFormat: handler.ResponseDetailed,
+		Limits:         handler.DefaultLimits(),
+		RetentionDays:  memory.DefaultRetentionDays,
+		Workers:        runtime.NumCPU(),
+		OrderedOutput:  true,
+		Timezone:       "UTC",
+		Storage: Storage{
+			Type:          StorageMemory,
+			Sync:          file.SyncAlways,
+			SnapshotEvery: file.DefaultSnapshotEvery,
+		},
+	}
+}
+
+// Load starts from the defaults, applies the JSON file at path (when path is
+// not empty) and then the environment variables, and validates the result.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("error to read config file %s: %w", path, err)
+		}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return cfg, fmt.Errorf("error to parse config file %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the response format, the retention, the workers, the
+// storage, the timezones, the base limits and the limits resolved for every
+// tier and customer profile.
+func (c Config) Validate() error {
+	if err := c.ResponseFormat.Validate(); err != nil {
+		return err
+	}
+	if err := c.Limits.Validate(); err != nil {
+		return err
+	}
+	if c.RetentionDays <= 0 {
+		return fmt.Errorf("retention days %d must be positive", c.RetentionDays)
+	}
+	if c.Workers <= 0 {
+		return fmt.Errorf("workers %d must be positive", c.Workers)
+	}
+	if err := c.Storage.Validate(); err != nil {
+		return err
+	}
+	if _, err := c.Location(); err != nil {
+		return err
+	}
+	for tier := range c.Tiers {
+		profile := domain.CustomerProfile{Tier: tier}
+		if err := c.Limits.ForProfile(c.Tiers, profile).Validate(); err != nil {
+			return fmt.Errorf("tier %s: %w", tier, err)
+		}
+	}
+	for _, profile := range c.Customers {
+		if profile.CustomerID == "" {
+			return domain.ErrCustomerEmptyID
+		}
+		if err := c.Limits.ForProfile(c.Tiers, profile).Validate(); err != nil {
+			return fmt.Errorf("customer %s: %w", profile.CustomerID, err)
+		}
+		if profile.Timezone == "" {
+			continue
+		}
+		if _, err := time.LoadLocation(profile.Timezone); err != nil {
+			return fmt.Errorf("customer %s: error to load timezone: %w", profile.CustomerID, err)
+		}
+	}
+	return nil
+}
+
+// Location loads the business timezone.
+func (c Config) Location() (*time.Location, error) {
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("error to load timezone: %w", err)
+	}
+	return location, nil
+}
+
+func (c *Config) applyEnv() error {
+	if raw, ok := os.LookupEnv(EnvWorkers); ok {
+		workers, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("error to parse %s: %w", EnvWorkers, err)
+		}
+		c.Workers = workers
+	}
+	if raw, ok := os.LookupEnv(EnvResponseFormat); ok {
+		c.ResponseFormat = handler.ResponseFormat(raw)
+	}
+	if raw, ok := os.LookupEnv(EnvDailyAmount); ok {
+		amount, err := domain.ParseMoney(raw)
+		if err != nil {
+			return fmt.Errorf("error to parse %s: %w", EnvDailyAmount, err)
+		}
+		c.Limits.DailyAmount = amount
+	}
+	if raw, ok := os.LookupEnv(EnvDailyCount); ok {
+		count, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("error to parse %s: %w", EnvDailyCount, err)
+		}
+		c.Limits.DailyCount = count
+	}
+	if raw, ok := os.LookupEnv(EnvWeeklyAmount); ok {
+		amount, err := domain.ParseMoney(raw)
+		if err != nil {
+			return fmt.Errorf("error to parse %s: %w", EnvWeeklyAmount, err)
+		}
+		c.Limits.WeeklyAmount = amount
+	}
+	if raw, ok := os.LookupEnv(EnvMonthlyAmount); ok {
+		amount, err := domain.ParseMoney(raw)
+		if err != nil {
+			return fmt.Errorf("error to parse %s: %w", EnvMonthlyAmount, err)
+		}
+		c.Limits.MonthlyAmount = &amount
+	}
+	if raw, ok := os.LookupEnv(EnvYearlyAmount); ok {
+		amount, err := domain.ParseMoney(raw)
+		if err != nil {
+			return fmt.Errorf("error to parse %s: %w", EnvYearlyAmount, err)
+		}
+		c.Limits.YearlyAmount = &amount
+	}
+	return nil
+}
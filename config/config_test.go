@@ -0,0 +1,180 @@
+package config_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielfmelo/load-funds-handler/config"
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/danielfmelo/load-funds-handler/storage/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "config.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func setEnv(t *testing.T, key, value string) func() {
+	assert.Nil(t, os.Setenv(key, value))
+	return func() { os.Unsetenv(key) }
+}
+
+func TestLoadShouldReturnDefaults(t *testing.T) {
+	cfg, err := config.Load("")
+	assert.Nil(t, err)
+	assert.Equal(t, handler.DefaultLimits(), cfg.Limits)
+	assert.Equal(t, handler.ResponseDetailed, cfg.ResponseFormat)
+}
+
+func TestLoadShouldReadFile(t *testing.T) {
+	path, cleanup := writeConfig(t, `{"limits":{"daily_amount":"$1000.00","weekly_amount":"$4000"}}`)
+	defer cleanup()
+	cfg, err := config.Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(100000), cfg.Limits.DailyAmount)
+	assert.Equal(t, 3, cfg.Limits.DailyCount)
+	assert.Equal(t, domain.NewMoney(400000), cfg.Limits.WeeklyAmount)
+}
+
+func TestLoadShouldReadStorage(t *testing.T) {
+	path, cleanup := writeConfig(t, `{"storage":{"type":"file","dir":"data","sync":"interval"}}`)
+	defer cleanup()
+	cfg, err := config.Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, config.Storage{
+		Type:          config.StorageFile,
+		Dir:           "data",
+		Sync:          file.SyncInterval,
+		SnapshotEvery: file.DefaultSnapshotEvery,
+	}, cfg.Storage)
+}
+
+func TestLoadShouldReadTimezone(t *testing.T) {
+	path, cleanup := writeConfig(t, `{"timezone":"America/Toronto"}`)
+	defer cleanup()
+	cfg, err := config.Load(path)
+	assert.Nil(t, err)
+	location, err := cfg.Location()
+	assert.Nil(t, err)
+	assert.Equal(t, "America/Toronto", location.String())
+}
+
+func TestLoadShouldApplyEnvOverFile(t *testing.T) {
+	path, cleanup := writeConfig(t, `{"limits":{"daily_count":5}}`)
+	defer cleanup()
+	defer setEnv(t, config.EnvDailyCount, "7")()
+	defer setEnv(t, config.EnvWeeklyAmount, "$30000.00")()
+	defer setEnv(t, config.EnvMonthlyAmount, "$40000.00")()
+	defer setEnv(t, config.EnvResponseFormat, "legacy")()
+	defer setEnv(t, config.EnvWorkers, "2")()
+	cfg, err := config.Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, handler.ResponseLegacy, cfg.ResponseFormat)
+	assert.Equal(t, 2, cfg.Workers)
+	assert.Equal(t, 7, cfg.Limits.DailyCount)
+	assert.Equal(t, domain.NewMoney(3000000), cfg.Limits.WeeklyAmount)
+	monthly := domain.NewMoney(4000000)
+	assert.Equal(t, &monthly, cfg.Limits.MonthlyAmount)
+	assert.Nil(t, cfg.Limits.YearlyAmount)
+}
+
+func TestLoadShouldReturnError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		env     map[string]string
+	}{
+		{name: "invalid json", content: `{"limits":`},
+		{name: "invalid amount", content: `{"limits":{"daily_amount":"$1.001"}}`},
+		{name: "daily greater than weekly", content: `{"limits":{"daily_amount":"$30000"}}`},
+		{name: "negative count", content: `{"limits":{"daily_count":-1}}`},
+		{name: "negative monthly amount", content: `{"limits":{"monthly_amount":"-$1"}}`},
+		{name: "monthly greater than yearly", content: `{"limits":{"monthly_amount":"$50000","yearly_amount":"$40000"}}`},
+		{name: "invalid workers", content: `{"workers":0}`},
+		{name: "invalid retention", content: `{"retention_days":0}`},
+		{name: "unknown response format", content: `{"response_format":"xml"}`},
+		{name: "invalid tier", content: `{"tiers":{"premium":{"daily_amount":"$50000"}}}`},
+		{name: "customer without id", content: `{"customers":[{"tier":"premium"}]}`},
+		{name: "invalid customer override", content: `{"customers":[{"customer_id":"1","override":{"daily_count":-2}}]}`},
+		{name: "unknown storage type", content: `{"storage":{"type":"tape"}}`},
+		{name: "file storage without dir", content: `{"storage":{"type":"file"}}`},
+		{name: "unknown sync policy", content: `{"storage":{"type":"file","dir":"data","sync":"sometimes"}}`},
+		{name: "sql storage without dsn", content: `{"storage":{"type":"sql","driver":"sqlite3"}}`},
+		{name: "unknown sql driver", content: `{"storage":{"type":"sql","driver":"oracle","dsn":"loads"}}`},
+		{name: "unlinked sql driver", content: `{"storage":{"type":"sql","driver":"postgres","dsn":"loads"}}`},
+		{name: "unknown window mode", content: `{"limits":{"modes":{"daily_amount":"sliding"}}}`},
+		{name: "invalid withdrawal limits", content: `{"limits":{"withdrawal":{"daily_amount":"$100","daily_count":1,"weekly_amount":"$50"}}}`},
+		{name: "nested spend limits", content: `{"limits":{"spend":{"weekly_amount":"$100","withdrawal":{}}}}`},
+		{name: "invalid tier withdrawal override", content: `{"tiers":{"premium":{"withdrawal":{"daily_count":-1}}}}`},
+		{name: "unknown timezone", content: `{"timezone":"Mars/Olympus_Mons"}`},
+		{name: "unknown customer timezone", content: `{"customers":[{"customer_id":"1","timezone":"Mars/Olympus_Mons"}]}`},
+		{name: "invalid env count", content: `{}`, env: map[string]string{config.EnvDailyCount: "three"}},
+		{name: "invalid env yearly amount", content: `{}`, env: map[string]string{config.EnvYearlyAmount: "lots"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				defer setEnv(t, key, value)()
+			}
+			path, cleanup := writeConfig(t, tc.content)
+			defer cleanup()
+			_, err := config.Load(path)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestLoadShouldReturnInvalidLimits(t *testing.T) {
+	path, cleanup := writeConfig(t, `{"limits":{"weekly_amount":"$100"}}`)
+	defer cleanup()
+	_, err := config.Load(path)
+	assert.True(t, errors.Is(err, domain.ErrInvalidLimits))
+}
+
+func TestLoadShouldReadTiersAndCustomers(t *testing.T) {
+	cfg, err := config.Load("../config.example.json")
+	assert.Nil(t, err)
+	assert.Len(t, cfg.Tiers, 2)
+	assert.Len(t, cfg.Customers, 1)
+	limits := cfg.Limits.ForProfile(cfg.Tiers, cfg.Customers[0])
+	assert.Equal(t, domain.NewMoney(1000000), limits.DailyAmount)
+	assert.Equal(t, 3, limits.DailyCount)
+	assert.Equal(t, domain.NewMoney(6000000), limits.WeeklyAmount)
+	withdrawal := limits.For(domain.TransactionWithdrawal)
+	assert.Equal(t, domain.NewMoney(200000), withdrawal.DailyAmount)
+	assert.Equal(t, domain.NewMoney(500000), withdrawal.WeeklyAmount)
+	assert.Equal(t, domain.NewMoney(250000), limits.For(domain.TransactionSpend).DailyAmount)
+}
+
+func TestLoadShouldInheritLoadLimitsInNestedLimits(t *testing.T) {
+	path, cleanup := writeConfig(t, `{
+		"limits":{"withdrawal":{"daily_amount":"$100.00"},"spend":{"daily_count":10}},
+		"tiers":{"premium":{"weekly_amount":"$50000.00","withdrawal":{"daily_count":1}}}
+	}`)
+	defer cleanup()
+	cfg, err := config.Load(path)
+	assert.Nil(t, err)
+	withdrawal := cfg.Limits.For(domain.TransactionWithdrawal)
+	assert.Equal(t, domain.NewMoney(10000), withdrawal.DailyAmount)
+	assert.Equal(t, 3, withdrawal.DailyCount)
+	assert.Equal(t, domain.NewMoney(2000000), withdrawal.WeeklyAmount)
+	spend := cfg.Limits.For(domain.TransactionSpend)
+	assert.Equal(t, domain.NewMoney(500000), spend.DailyAmount)
+	assert.Equal(t, 10, spend.DailyCount)
+
+	premium := cfg.Limits.ForProfile(cfg.Tiers, domain.CustomerProfile{Tier: domain.TierPremium})
+	withdrawal = premium.For(domain.TransactionWithdrawal)
+	assert.Equal(t, domain.NewMoney(10000), withdrawal.DailyAmount)
+	assert.Equal(t, 1, withdrawal.DailyCount)
+	assert.Equal(t, domain.NewMoney(5000000), withdrawal.WeeklyAmount)
+	assert.Equal(t, domain.NewMoney(5000000), premium.For(domain.TransactionSpend).WeeklyAmount)
+}
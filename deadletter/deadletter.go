@@ -0,0 +1,232 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+)
+
+const fileName = "deadletter.jsonl"
+
+// ErrCorruptQueue means a line before the end of the queue cannot be read.
+var ErrCorruptQueue = errors.New("dead-letter queue is corrupt")
+
+// Entry is a fund that could not be processed. Input keeps the exact bytes
+// received and Error is the error reported for the fund. Reason is set for
+// malformed funds, which are also answered with a rejection unless the
+// response format is legacy or the fund has no ID.
+type Entry struct {
+	ID     uint64             `json:"id"`
+	Time   time.Time          `json:"time"`
+	Input  []byte             `json:"input"`
+	Reason domain.Reason      `json:"reason,omitempty"`
+	Error  *domain.ErrorEvent `json:"error,omitempty"`
+}
+
+// FromResult returns the entry of a fund the handler could not process: one
+// that got no response, or was rejected as malformed. Duplicates are not
+// dead-lettered, as submitting them again cannot succeed.
+func FromResult(fund []byte, result handler.Result, at time.Time) (Entry, bool) {
+	switch {
+	case result.Reason == domain.ReasonDuplicateID || result.Reason == domain.ReasonIDConflict:
+		return Entry{}, false
+	case result.Err == nil && result.Reason != domain.ReasonMalformedInput:
+		return Entry{}, false
+	}
+	entry := Entry{Time: at, Input: fund, Reason: result.Reason}
+	if result.Err != nil {
+		var event domain.ErrorEvent
+		if err := json.Unmarshal(result.Err, &event); err != nil {
+			event = domain.ErrorEvent{Kind: domain.ErrorKindInternal, Error: string(result.Err)}
+		}
+		// The fund is already kept in Input.
+		event.Input = ""
+		entry.Error = &event
+	}
+	return entry, true
+}
+
+// Resolved tells whether the result of submitting a dead-lettered fund again
+// answers it. A duplicate is not resolved: the fund was processed before,
+// but the response it got is not known.
+func Resolved(result handler.Result) bool {
+	switch {
+	case result.Err != nil:
+		return false
+	case result.Reason == domain.ReasonDuplicateID || result.Reason == domain.ReasonMalformedInput:
+		return false
+	}
+	return true
+}
+
+// record is a line of the queue file. Removing an entry appends a record
+// with its ID and Removed set, so IDs are never reused.
+type record struct {
+	Entry
+	Removed bool `json:"removed,omitempty"`
+}
+
+// Filter selects entries. Zero fields match every entry.
+type Filter struct {
+	ID     uint64
+	Stage  domain.ErrorStage
+	Kind   domain.ErrorKind
+	Reason domain.Reason
+	Since  time.Time
+	Until  time.Time
+}
+
+// Match tells whether the entry passes every field set in the filter.
+func (f Filter) Match(entry Entry) bool {
+	var event domain.ErrorEvent
+	if entry.Error != nil {
+		event = *entry.Error
+	}
+	switch {
+	case f.ID != 0 && entry.ID != f.ID:
+		return false
+	case f.Stage != "" && event.Stage != f.Stage:
+		return false
+	case f.Kind != "" && event.Kind != f.Kind:
+		return false
+	case f.Reason != "" && entry.Reason != f.Reason:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Queue is a dead-letter queue kept in a single append-only file of a
+// directory. It is safe for concurrent use.
+type Queue struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[uint64]Entry
+	next    uint64
+}
+
+// Open reads the queue kept in dir, creating it when missing. A last line
+// torn by a crash is dropped.
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error to create dead-letter dir: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, fileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error to open dead-letter queue: %w", err)
+	}
+	q := &Queue{file: file, entries: make(map[uint64]Entry), next: 1}
+	if err := q.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Queue) load() error {
+	reader := bufio.NewReader(q.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline was never completely written.
+			if err := q.file.Truncate(offset); err != nil {
+				return fmt.Errorf("error to truncate dead-letter queue: %w", err)
+			}
+			_, err = q.file.Seek(offset, io.SeekStart)
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("error to read dead-letter queue: %w", err)
+		}
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("%w: line at offset %d: %s", ErrCorruptQueue, offset, err)
+		}
+		q.apply(r)
+		offset += int64(len(line))
+	}
+}
+
+func (q *Queue) apply(r record) {
+	if r.ID >= q.next {
+		q.next = r.ID + 1
+	}
+	if r.Removed {
+		delete(q.entries, r.ID)
+		return
+	}
+	q.entries[r.ID] = r.Entry
+}
+
+func (q *Queue) append(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error to write dead-letter queue: %w", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("error to sync dead-letter queue: %w", err)
+	}
+	q.apply(r)
+	return nil
+}
+
+// Add stores the entry under a new ID and returns it.
+func (q *Queue) Add(entry Entry) (Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry.ID = q.next
+	if err := q.append(record{Entry: entry}); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// Remove drops the entry with the ID. Removing a missing entry returns
+// domain.ErrNotFound.
+func (q *Queue) Remove(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[id]; !ok {
+		return domain.ErrNotFound
+	}
+	return q.append(record{Entry: Entry{ID: id}, Removed: true})
+}
+
+// Entries returns the entries matching the filter, oldest first.
+func (q *Queue) Entries(filter Filter) []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var entries []Entry
+	for _, entry := range q.entries {
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.file.Close()
+}
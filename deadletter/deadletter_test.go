@@ -0,0 +1,261 @@
+package deadletter_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/deadletter"
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/danielfmelo/load-funds-handler/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+var fakeTime = time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "load-funds-deadletter")
+	assert.Nil(t, err)
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func open(t *testing.T, dir string) *deadletter.Queue {
+	q, err := deadletter.Open(dir)
+	assert.Nil(t, err)
+	return q
+}
+
+func TestFromResult(t *testing.T) {
+	fund := []byte(`{"id":"1"`)
+	testCases := []struct {
+		name          string
+		result        handler.Result
+		okExpected    bool
+		entryExpected deadletter.Entry
+	}{
+		{
+			name:   "accepted",
+			result: handler.Result{Event: []byte(`{"accepted":true}`)},
+		},
+		{
+			name:   "rejected by a limit",
+			result: handler.Result{Event: []byte(`{"accepted":false}`), Reason: domain.ReasonDailyCountExceeded},
+		},
+		{
+			name:   "duplicate",
+			result: handler.Result{Err: []byte(`{"stage":"record","kind":"duplicate"}`), Reason: domain.ReasonDuplicateID},
+		},
+		{
+			name:          "malformed rejection",
+			result:        handler.Result{Event: []byte(`{"accepted":false}`), Reason: domain.ReasonMalformedInput},
+			okExpected:    true,
+			entryExpected: deadletter.Entry{Time: fakeTime, Input: fund, Reason: domain.ReasonMalformedInput},
+		},
+		{
+			name:       "storage error",
+			result:     handler.Result{Err: []byte(`{"stage":"evaluate","kind":"storage","error":"some error","input":"{\"id\":\"1\""}`)},
+			okExpected: true,
+			entryExpected: deadletter.Entry{
+				Time:  fakeTime,
+				Input: fund,
+				Error: &domain.ErrorEvent{Stage: domain.StageEvaluate, Kind: domain.ErrorKindStorage, Error: "some error"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, ok := deadletter.FromResult(fund, tc.result, fakeTime)
+			assert.Equal(t, tc.okExpected, ok)
+			assert.Equal(t, tc.entryExpected, entry)
+		})
+	}
+}
+
+func TestResolved(t *testing.T) {
+	testCases := []struct {
+		name             string
+		result           handler.Result
+		resolvedExpected bool
+	}{
+		{
+			name:             "accepted",
+			result:           handler.Result{Event: []byte(`{"accepted":true}`)},
+			resolvedExpected: true,
+		},
+		{
+			name:             "rejected by a limit",
+			result:           handler.Result{Event: []byte(`{"accepted":false}`), Reason: domain.ReasonDailyCountExceeded},
+			resolvedExpected: true,
+		},
+		{
+			name:   "duplicate",
+			result: handler.Result{Err: []byte(`{"stage":"record","kind":"duplicate"}`), Reason: domain.ReasonDuplicateID},
+		},
+		{
+			name:   "duplicate rejection",
+			result: handler.Result{Event: []byte(`{"accepted":false}`), Reason: domain.ReasonDuplicateID},
+		},
+		{
+			name:   "malformed rejection",
+			result: handler.Result{Event: []byte(`{"accepted":false}`), Reason: domain.ReasonMalformedInput},
+		},
+		{
+			name:   "storage error",
+			result: handler.Result{Err: []byte(`{"stage":"evaluate","kind":"storage"}`)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.resolvedExpected, deadletter.Resolved(tc.result))
+		})
+	}
+}
+
+// failingDecisions fails to save the first decision.
+type failingDecisions struct {
+	*memory.Database
+	failed bool
+}
+
+func (d *failingDecisions) SetDecision(customerID, id string, decision domain.TransactionDecision) error {
+	if !d.failed {
+		d.failed = true
+		return errors.New("some error")
+	}
+	return d.Database.SetDecision(customerID, id, decision)
+}
+
+func TestRetryShouldResolveStorageFailure(t *testing.T) {
+	for _, format := range []handler.ResponseFormat{handler.ResponseDetailed, handler.ResponseLegacy} {
+		t.Run(string(format), func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			q := open(t, dir)
+			defer q.Close()
+			database := &failingDecisions{Database: memory.New()}
+			h := handler.New(database, handler.DefaultLimits(), nil, nil, handler.WithResponseFormat(format), handler.WithLedger(database))
+			fund := []byte(`{"id":"1","customer_id":"1","load_amount":"$100.00","time":"2000-01-03T10:00:00Z"}`)
+
+			result := h.Process(fund)
+			entry, ok := deadletter.FromResult(fund, result, fakeTime)
+			assert.True(t, ok)
+			assert.Equal(t, domain.StageDecision, entry.Error.Stage)
+			_, err := q.Add(entry)
+			assert.Nil(t, err)
+
+			entries := q.Entries(deadletter.Filter{Kind: domain.ErrorKindStorage})
+			assert.Len(t, entries, 1)
+			result = h.Process(entries[0].Input)
+			assert.True(t, deadletter.Resolved(result))
+			assert.Equal(t, `{"id":"1","customer_id":"1","accepted":true}`, string(result.Event))
+			daily, err := database.GetDailyTransaction("1", "2000-01-03")
+			assert.Nil(t, err)
+			assert.Equal(t, 1, daily.TransactionCount)
+			posted, err := database.ListEntries("1")
+			assert.Nil(t, err)
+			assert.Len(t, posted, 2)
+
+			result = h.Process(fund)
+			assert.Equal(t, format == handler.ResponseDetailed, deadletter.Resolved(result))
+		})
+	}
+}
+
+func TestQueueShouldKeepEntriesAcrossRuns(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	q := open(t, dir)
+	first, err := q.Add(deadletter.Entry{Time: fakeTime, Input: []byte("first"), Reason: domain.ReasonMalformedInput})
+	assert.Nil(t, err)
+	second, err := q.Add(deadletter.Entry{Time: fakeTime, Input: []byte("second")})
+	assert.Nil(t, err)
+	assert.Nil(t, q.Remove(first.ID))
+	assert.Equal(t, domain.ErrNotFound, q.Remove(first.ID))
+	assert.Nil(t, q.Close())
+
+	q = open(t, dir)
+	defer q.Close()
+	entries := q.Entries(deadletter.Filter{})
+	assert.Len(t, entries, 1)
+	assert.Equal(t, second.ID, entries[0].ID)
+	assert.Equal(t, []byte("second"), entries[0].Input)
+	third, err := q.Add(deadletter.Entry{Time: fakeTime, Input: []byte("third")})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), third.ID)
+}
+
+func TestQueueShouldDropTornEntry(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	q := open(t, dir)
+	_, err := q.Add(deadletter.Entry{Time: fakeTime, Input: []byte("first")})
+	assert.Nil(t, err)
+	assert.Nil(t, q.Close())
+	file, err := os.OpenFile(filepath.Join(dir, "deadletter.jsonl"), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.Nil(t, err)
+	_, err = file.WriteString(`{"id":2,"ti`)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	q = open(t, dir)
+	_, err = q.Add(deadletter.Entry{Time: fakeTime, Input: []byte("second")})
+	assert.Nil(t, err)
+	assert.Nil(t, q.Close())
+	q = open(t, dir)
+	defer q.Close()
+	assert.Len(t, q.Entries(deadletter.Filter{}), 2)
+}
+
+func TestOpenShouldFailOnCorruptQueue(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "deadletter.jsonl"), []byte("nope\n{\"id\":1}\n"), 0600))
+	_, err := deadletter.Open(dir)
+	assert.True(t, errors.Is(err, deadletter.ErrCorruptQueue))
+}
+
+func TestQueueEntriesShouldFilter(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	q := open(t, dir)
+	defer q.Close()
+	entries := []deadletter.Entry{
+		{Time: fakeTime, Reason: domain.ReasonMalformedInput},
+		{Time: fakeTime.Add(time.Hour), Error: &domain.ErrorEvent{Stage: domain.StageEvaluate, Kind: domain.ErrorKindStorage}},
+		{Time: fakeTime.Add(2 * time.Hour), Error: &domain.ErrorEvent{Stage: domain.StageDecode, Kind: domain.ErrorKindMalformed}, Reason: domain.ReasonMalformedInput},
+	}
+	for _, entry := range entries {
+		_, err := q.Add(entry)
+		assert.Nil(t, err)
+	}
+
+	testCases := []struct {
+		name        string
+		filter      deadletter.Filter
+		idsExpected []uint64
+	}{
+		{name: "all", filter: deadletter.Filter{}, idsExpected: []uint64{1, 2, 3}},
+		{name: "id", filter: deadletter.Filter{ID: 2}, idsExpected: []uint64{2}},
+		{name: "stage", filter: deadletter.Filter{Stage: domain.StageDecode}, idsExpected: []uint64{3}},
+		{name: "kind", filter: deadletter.Filter{Kind: domain.ErrorKindStorage}, idsExpected: []uint64{2}},
+		{name: "reason", filter: deadletter.Filter{Reason: domain.ReasonMalformedInput}, idsExpected: []uint64{1, 3}},
+		{name: "since", filter: deadletter.Filter{Since: fakeTime.Add(time.Hour)}, idsExpected: []uint64{2, 3}},
+		{name: "until", filter: deadletter.Filter{Until: fakeTime.Add(time.Hour)}, idsExpected: []uint64{1}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ids []uint64
+			for _, entry := range q.Entries(tc.filter) {
+				ids = append(ids, entry.ID)
+			}
+			assert.Equal(t, tc.idsExpected, ids)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+type Tier string
+
+const (
+	TierBasic    Tier = "basic"
+	TierVerified Tier = "verified"
+	TierPremium  Tier = "premium"
+)
+
+// LimitOverride replaces individual limits. Nil fields keep the value they
+// are applied on top of. Withdrawal and Spend override the limits of those
+// transactions the same way.
+type LimitOverride struct {
+	DailyAmount   *Money         `json:"daily_amount,omitempty"`
+	DailyCount    *int           `json:"daily_count,omitempty"`
+	WeeklyAmount  *Money         `json:"weekly_amount,omitempty"`
+	MonthlyAmount *Money         `json:"monthly_amount,omitempty"`
+	YearlyAmount  *Money         `json:"yearly_amount,omitempty"`
+	Withdrawal    *LimitOverride `json:"withdrawal,omitempty"`
+	Spend         *LimitOverride `json:"spend,omitempty"`
+}
+
+// CustomerProfile places a customer in a tier and optionally overrides the
+// tier limits for that customer only. Timezone is the IANA name of the
+// timezone the windows of the customer are computed in, the business
+// timezone when empty.
+type CustomerProfile struct {
+	CustomerID string        `json:"customer_id"`
+	Tier       Tier          `json:"tier"`
+	Override   LimitOverride `json:"override"`
+	Timezone   string        `json:"timezone,omitempty"`
+}
+
+// RemainingLimits is what a customer can still load in the day, week, month
+// and year of Time. The monthly and yearly amounts are only set when the
+// customer has such a limit.
+type RemainingLimits struct {
+	CustomerID    string    `json:"customer_id"`
+	Time          time.Time `json:"time"`
+	DailyAmount   Money     `json:"daily_amount"`
+	DailyCount    int       `json:"daily_count"`
+	WeeklyAmount  Money     `json:"weekly_amount"`
+	MonthlyAmount *Money    `json:"monthly_amount,omitempty"`
+	YearlyAmount  *Money    `json:"yearly_amount,omitempty"`
+}
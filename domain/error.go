@@ -6,4 +6,13 @@ var (
 	ErrTransactionAlreadyExist = errors.New("transaction ID already exist")
 	ErrTransactionEmptyID      = errors.New("transaction must have ID")
 	ErrNotFound                = errors.New("resource not found")
+	ErrInvalidAmount           = errors.New("invalid money amount")
+	ErrInvalidLimits           = errors.New("invalid limits")
+	ErrCustomerEmptyID         = errors.New("customer must have ID")
+	ErrAlreadyReversed         = errors.New("transaction already reversed")
+	ErrInvalidReversal         = errors.New("reversal must reference another transaction")
+	ErrUnknownTransactionType  = errors.New("unknown transaction type")
+	ErrUnbalancedEntries       = errors.New("ledger entries do not balance")
+	ErrLedgerDisabled          = errors.New("ledger is not enabled")
+	ErrInsufficientFunds       = errors.New("insufficient funds")
 )
@@ -0,0 +1,40 @@
+package domain
+
+// ErrorStage is the step of processing a fund that failed.
+type ErrorStage string
+
+const (
+	StageDecode   ErrorStage = "decode"
+	StageValidate ErrorStage = "validate"
+	StageRecord   ErrorStage = "record"
+	StageLimits   ErrorStage = "limits"
+	StageEvaluate ErrorStage = "evaluate"
+	StageReverse  ErrorStage = "reverse"
+	StageLedger   ErrorStage = "ledger"
+	StageDecision ErrorStage = "decision"
+	StageEncode   ErrorStage = "encode"
+)
+
+// ErrorKind tells errors caused by the fund itself apart from failures of
+// the service.
+type ErrorKind string
+
+const (
+	ErrorKindMalformed ErrorKind = "malformed"
+	ErrorKindDuplicate ErrorKind = "duplicate"
+	ErrorKindStorage   ErrorKind = "storage"
+	ErrorKindInternal  ErrorKind = "internal"
+)
+
+// ErrorEvent is the JSON published for a fund that could not be answered.
+// The transaction and customer IDs are set when the fund got far enough to
+// read them, and Input keeps the raw fund for inspection or resubmission.
+type ErrorEvent struct {
+	TransactionID string     `json:"transaction_id,omitempty"`
+	CustomerID    string     `json:"customer_id,omitempty"`
+	Stage         ErrorStage `json:"stage"`
+	Kind          ErrorKind  `json:"kind"`
+	Message       string     `json:"message"`
+	Error         string     `json:"error"`
+	Input         string     `json:"input,omitempty"`
+}
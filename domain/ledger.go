@@ -0,0 +1,142 @@
+package domain
+
+import "time"
+
+// Account is an account of the ledger.
+type Account string
+
+// SettlementAccount holds the funds received for the loads of every customer
+// and paid out for their withdrawals and spends, the counterpart of the
+// customer accounts.
+const SettlementAccount Account = "settlement"
+
+// CustomerAccount is the account holding the funds loaded by the customer.
+func CustomerAccount(customerID string) Account {
+	return Account("customer:" + customerID)
+}
+
+// EntrySide is whether an entry debits or credits its account.
+type EntrySide string
+
+const (
+	Debit  EntrySide = "debit"
+	Credit EntrySide = "credit"
+)
+
+// LedgerEntry is one side of what a transaction posted to the ledger. The
+// entries of a transaction always balance: they debit as much as they credit.
+type LedgerEntry struct {
+	TransactionID string    `json:"transaction_id"`
+	CustomerID    string    `json:"customer_id"`
+	Account       Account   `json:"account"`
+	Side          EntrySide `json:"side"`
+	Amount        Money     `json:"amount"`
+	Time          time.Time `json:"time"`
+}
+
+// Signed returns the amount debits positive and credits negative, so the
+// signed amounts of the whole ledger add up to zero.
+func (e LedgerEntry) Signed() Money {
+	if e.Side == Credit {
+		return Money{Amount: -e.Amount.Amount, Currency: e.Amount.Currency}
+	}
+	return e.Amount
+}
+
+// LoadEntries are the entries of an accepted load: the funds received are
+// debited to the settlement account and credited to the customer.
+func LoadEntries(load Transaction) []LedgerEntry {
+	return transfer(load.ID, load.CustomerID, load.Time, load.LoadAmount, SettlementAccount, CustomerAccount(load.CustomerID))
+}
+
+// DebitEntries are the entries of an accepted withdrawal or spend: the funds
+// are debited to the customer and credited to the settlement account.
+func DebitEntries(debit Transaction) []LedgerEntry {
+	return transfer(debit.ID, debit.CustomerID, debit.Time, debit.LoadAmount, CustomerAccount(debit.CustomerID), SettlementAccount)
+}
+
+// Entries are the entries of an accepted load, withdrawal or spend.
+func Entries(transaction Transaction) []LedgerEntry {
+	if transaction.IsDebit() {
+		return DebitEntries(transaction)
+	}
+	return LoadEntries(transaction)
+}
+
+// ReversalEntries are the entries of an accepted reversal, which move the
+// funds of the original transaction back where they came from.
+func ReversalEntries(reversal, original Transaction) []LedgerEntry {
+	debit, credit := CustomerAccount(reversal.CustomerID), SettlementAccount
+	if original.IsDebit() {
+		debit, credit = credit, debit
+	}
+	return transfer(reversal.ID, reversal.CustomerID, reversal.Time, original.LoadAmount, debit, credit)
+}
+
+// Balanced reports whether the entries debit as much as they credit.
+func Balanced(entries []LedgerEntry) bool {
+	var sum Money
+	for _, entry := range entries {
+		sum = sum.Add(entry.Signed())
+	}
+	return sum.IsZero()
+}
+
+// CustomerBalance returns what the entries credit to the account of the
+// customer minus what they debit from it.
+func CustomerBalance(entries []LedgerEntry, customerID string) Money {
+	account := CustomerAccount(customerID)
+	var balance Money
+	for _, entry := range entries {
+		if entry.Account == account {
+			balance = balance.Sub(entry.Signed())
+		}
+	}
+	return balance
+}
+
+func transfer(id, customerID string, at time.Time, amount Money, debit, credit Account) []LedgerEntry {
+	return []LedgerEntry{
+		{TransactionID: id, CustomerID: customerID, Account: debit, Side: Debit, Amount: amount, Time: at},
+		{TransactionID: id, CustomerID: customerID, Account: credit, Side: Credit, Amount: amount, Time: at},
+	}
+}
+
+// AccountBalance is the total debited and credited to an account.
+type AccountBalance struct {
+	Account Account `json:"account"`
+	Debit   Money   `json:"debit"`
+	Credit  Money   `json:"credit"`
+}
+
+// Net is the debits minus the credits of the account.
+func (b AccountBalance) Net() Money {
+	return b.Debit.Sub(b.Credit)
+}
+
+// Balance is the funds a customer holds: what was credited to the customer
+// account minus what was debited from it.
+type Balance struct {
+	CustomerID string `json:"customer_id"`
+	Balance    Money  `json:"balance"`
+}
+
+// Statement lists the entries of a customer account between From and To,
+// inclusive, with the balance after each of them. A missing From or To leaves
+// that end open.
+type Statement struct {
+	CustomerID     string          `json:"customer_id"`
+	From           *time.Time      `json:"from,omitempty"`
+	To             *time.Time      `json:"to,omitempty"`
+	OpeningBalance Money           `json:"opening_balance"`
+	Lines          []StatementLine `json:"lines"`
+	ClosingBalance Money           `json:"closing_balance"`
+}
+
+type StatementLine struct {
+	TransactionID string    `json:"transaction_id"`
+	Time          time.Time `json:"time"`
+	Side          EntrySide `json:"side"`
+	Amount        Money     `json:"amount"`
+	Balance       Money     `json:"balance"`
+}
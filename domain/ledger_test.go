@@ -0,0 +1,73 @@
+package domain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalanced(t *testing.T) {
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	load := domain.Transaction{ID: "1", CustomerID: "2", LoadAmount: domain.NewMoney(100), Time: at}
+	reversal := domain.Transaction{ID: "3", CustomerID: "2", Time: at, Type: domain.TransactionReversal, Reverses: "1"}
+	spend := domain.Transaction{ID: "4", CustomerID: "2", LoadAmount: domain.NewMoney(40), Time: at, Type: domain.TransactionSpend}
+	testCases := []struct {
+		name             string
+		entries          []domain.LedgerEntry
+		balancedExpected bool
+	}{
+		{
+			name:             "load",
+			entries:          domain.LoadEntries(load),
+			balancedExpected: true,
+		},
+		{
+			name:             "reversal",
+			entries:          domain.ReversalEntries(reversal, load),
+			balancedExpected: true,
+		},
+		{
+			name:             "spend",
+			entries:          domain.Entries(spend),
+			balancedExpected: true,
+		},
+		{
+			name:             "reversal of spend",
+			entries:          domain.ReversalEntries(reversal, spend),
+			balancedExpected: true,
+		},
+		{
+			name:             "no entries",
+			balancedExpected: true,
+		},
+		{
+			name:             "single side",
+			entries:          domain.LoadEntries(load)[1:],
+			balancedExpected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.balancedExpected, domain.Balanced(tc.entries))
+		})
+	}
+}
+
+func TestCustomerBalance(t *testing.T) {
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	load := domain.Transaction{ID: "1", CustomerID: "2", LoadAmount: domain.NewMoney(100), Time: at}
+	withdrawal := domain.Transaction{ID: "2", CustomerID: "2", LoadAmount: domain.NewMoney(30), Time: at, Type: domain.TransactionWithdrawal}
+	reversal := domain.Transaction{ID: "3", CustomerID: "2", Time: at, Type: domain.TransactionReversal, Reverses: "2"}
+	other := domain.Transaction{ID: "1", CustomerID: "5", LoadAmount: domain.NewMoney(500), Time: at}
+	var entries []domain.LedgerEntry
+	entries = append(entries, domain.Entries(load)...)
+	entries = append(entries, domain.Entries(other)...)
+	entries = append(entries, domain.Entries(withdrawal)...)
+	assert.Equal(t, domain.NewMoney(70), domain.CustomerBalance(entries, "2"))
+	entries = append(entries, domain.ReversalEntries(reversal, withdrawal)...)
+	assert.Equal(t, domain.NewMoney(100), domain.CustomerBalance(entries, "2"))
+	assert.Equal(t, domain.NewMoney(500), domain.CustomerBalance(entries, "5"))
+}
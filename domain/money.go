@@ -0,0 +1,213 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultCurrency = "USD"
+	minorUnits      = 100
+	fractionDigits  = 2
+)
+
+// MaxAmount is the largest amount ParseMoney accepts, in minor units: one
+// trillion. It leaves room for sums of many amounts well before int64 would
+// overflow.
+const MaxAmount = 1000000000000 * minorUnits
+
+var currencySymbols = map[string]string{
+	"$": DefaultCurrency,
+}
+
+// Money is an exact monetary amount kept as an integer number of minor
+// units (cents) of its currency, so limit checks never suffer from binary
+// floating point rounding.
+//
+// The zero value is a valid zero amount that takes the currency of whatever
+// it is combined with. Combining two non-zero currencies that differ panics,
+// since the parser only ever produces DefaultCurrency.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney returns an amount of minor units in DefaultCurrency.
+func NewMoney(minor int64) Money {
+	return Money{Amount: minor, Currency: DefaultCurrency}
+}
+
+// ParseMoney parses amounts such as "$3318.47", "3318.47", "$10" or "-$1.50".
+// At most two fraction digits are accepted, and amounts beyond MaxAmount
+// either way are rejected.
+func ParseMoney(raw string) (Money, error) {
+	value := strings.TrimSpace(raw)
+	negative := false
+	if strings.HasPrefix(value, "-") {
+		negative = true
+		value = value[1:]
+	}
+	currency := DefaultCurrency
+	for symbol, code := range currencySymbols {
+		if strings.HasPrefix(value, symbol) {
+			currency = code
+			value = value[len(symbol):]
+			break
+		}
+	}
+	if !negative && strings.HasPrefix(value, "-") {
+		negative = true
+		value = value[1:]
+	}
+
+	units, cents := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		units, cents = value[:i], value[i+1:]
+	}
+	if units == "" || !isDigits(units) || !isDigits(cents) || len(cents) > fractionDigits {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	for len(cents) < fractionDigits {
+		cents += "0"
+	}
+
+	whole, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || whole > MaxAmount/minorUnits {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	fraction, _ := strconv.ParseInt(cents, 10, 64)
+	amount := whole*minorUnits + fraction
+	if amount > MaxAmount {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns m plus other. A sum beyond the range of int64 saturates at its
+// bound instead of wrapping around, so it still exceeds any limit it is
+// checked against.
+func (m Money) Add(other Money) Money {
+	return Money{Amount: addAmounts(m.Amount, other.Amount), Currency: m.currencyWith(other)}
+}
+
+// Sub returns m minus other, saturating like Add.
+func (m Money) Sub(other Money) Money {
+	negated := int64(math.MaxInt64)
+	if other.Amount != math.MinInt64 {
+		negated = -other.Amount
+	}
+	return Money{Amount: addAmounts(m.Amount, negated), Currency: m.currencyWith(other)}
+}
+
+func addAmounts(a, b int64) int64 {
+	sum := a + b
+	switch {
+	case a > 0 && b > 0 && sum < 0:
+		return math.MaxInt64
+	case a < 0 && b < 0 && sum >= 0:
+		return math.MinInt64
+	}
+	return sum
+}
+
+// Cmp returns -1, 0 or +1 depending on whether m is less than, equal to or
+// greater than other.
+func (m Money) Cmp(other Money) int {
+	m.currencyWith(other)
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+	return 0
+}
+
+// Equal reports whether m and other are the same amount of the same
+// currency, an empty currency being DefaultCurrency.
+func (m Money) Equal(other Money) bool {
+	return m.Amount == other.Amount && m.currency() == other.currency()
+}
+
+func (m Money) GreaterThan(other Money) bool {
+	return m.Cmp(other) > 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) currencyWith(other Money) string {
+	switch {
+	case m.Currency == other.Currency, other.Currency == "":
+		return m.Currency
+	case m.Currency == "":
+		return other.Currency
+	}
+	panic(fmt.Sprintf("money: currency mismatch %s and %s", m.Currency, other.Currency))
+}
+
+func (m Money) String() string {
+	amount := uint64(m.Amount)
+	sign := ""
+	if m.Amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	value := fmt.Sprintf("%d.%02d", amount/minorUnits, amount%minorUnits)
+	for symbol, code := range currencySymbols {
+		if code == m.currency() {
+			return sign + symbol + value
+		}
+	}
+	return sign + value + " " + m.currency()
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts either a string such as "$3318.47" or a bare JSON
+// number, which is read as DefaultCurrency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(data, &number); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, string(data))
+		}
+		raw = number.String()
+	}
+	parsed, err := ParseMoney(raw)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
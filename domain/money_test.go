@@ -0,0 +1,100 @@
+package domain_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		moneyExpected domain.Money
+		errExpected   bool
+	}{
+		{name: "dollar with cents", raw: "$3318.47", moneyExpected: domain.NewMoney(331847)},
+		{name: "without symbol", raw: "3318.47", moneyExpected: domain.NewMoney(331847)},
+		{name: "without cents", raw: "$10", moneyExpected: domain.NewMoney(1000)},
+		{name: "single fraction digit", raw: "$0.1", moneyExpected: domain.NewMoney(10)},
+		{name: "negative", raw: "-$1.50", moneyExpected: domain.NewMoney(-150)},
+		{name: "too many fraction digits", raw: "$1.001", errExpected: true},
+		{name: "exponent", raw: "1e3", errExpected: true},
+		{name: "empty", raw: "$", errExpected: true},
+		{name: "letters", raw: "$abc", errExpected: true},
+		{name: "overflow", raw: "$99999999999999999999", errExpected: true},
+		{name: "maximum", raw: "$1000000000000.00", moneyExpected: domain.NewMoney(domain.MaxAmount)},
+		{name: "above maximum", raw: "$1000000000000.01", errExpected: true},
+		{name: "wraps when added", raw: "$92233720368547757.99", errExpected: true},
+		{name: "below negative maximum", raw: "-$1000000000000.01", errExpected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			money, err := domain.ParseMoney(tc.raw)
+			if tc.errExpected {
+				assert.True(t, errors.Is(err, domain.ErrInvalidAmount))
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.moneyExpected, money)
+		})
+	}
+}
+
+func TestMoneyArithmeticShouldBeExact(t *testing.T) {
+	total := domain.Money{}
+	for i := 0; i < 10; i++ {
+		total = total.Add(domain.NewMoney(10))
+	}
+	assert.Equal(t, domain.NewMoney(100), total)
+	assert.Equal(t, 0, total.Cmp(domain.NewMoney(100)))
+	assert.True(t, domain.NewMoney(500001).GreaterThan(domain.NewMoney(500000)))
+	assert.True(t, domain.NewMoney(100).Sub(domain.NewMoney(101)).IsNegative())
+}
+
+func TestMoneyArithmeticShouldSaturate(t *testing.T) {
+	max := domain.NewMoney(math.MaxInt64)
+	min := domain.NewMoney(math.MinInt64)
+	assert.Equal(t, max, max.Add(domain.NewMoney(1)))
+	assert.Equal(t, min, min.Add(domain.NewMoney(-1)))
+	assert.Equal(t, min, min.Sub(domain.NewMoney(1)))
+	assert.Equal(t, max, domain.NewMoney(0).Sub(min))
+	assert.True(t, domain.NewMoney(1000).Add(max).GreaterThan(domain.NewMoney(500000)))
+}
+
+func TestMoneyShouldPanicOnCurrencyMismatch(t *testing.T) {
+	euro := domain.Money{Amount: 100, Currency: "EUR"}
+	assert.Panics(t, func() { domain.NewMoney(100).Add(euro) })
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "$3318.47", domain.NewMoney(331847).String())
+	assert.Equal(t, "-$0.05", domain.NewMoney(-5).String())
+	assert.Equal(t, "$0.00", domain.Money{}.String())
+	assert.Equal(t, "-$92233720368547758.08", domain.NewMoney(math.MinInt64).String())
+	assert.Equal(t, "1.00 EUR", domain.Money{Amount: 100, Currency: "EUR"}.String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var transaction domain.Transaction
+	err := json.Unmarshal([]byte(`{"id":"1","customer_id":"2","load_amount":"$3318.47","time":"2000-01-01T00:00:00Z"}`), &transaction)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(331847), transaction.LoadAmount)
+
+	var fromNumber domain.Money
+	err = json.Unmarshal([]byte(`5000`), &fromNumber)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(500000), fromNumber)
+
+	raw, err := json.Marshal(domain.NewMoney(331847))
+	assert.Nil(t, err)
+	assert.Equal(t, `"$3318.47"`, string(raw))
+
+	err = json.Unmarshal([]byte(`"$3318.471"`), &fromNumber)
+	assert.True(t, errors.Is(err, domain.ErrInvalidAmount))
+}
@@ -0,0 +1,40 @@
+package domain
+
+// Reason is the machine-readable code explaining why a transaction was
+// rejected.
+type Reason string
+
+const (
+	ReasonDailyAmountExceeded   Reason = "DAILY_AMOUNT_EXCEEDED"
+	ReasonDailyCountExceeded    Reason = "DAILY_COUNT_EXCEEDED"
+	ReasonWeeklyAmountExceeded  Reason = "WEEKLY_AMOUNT_EXCEEDED"
+	ReasonMonthlyAmountExceeded Reason = "MONTHLY_AMOUNT_EXCEEDED"
+	ReasonYearlyAmountExceeded  Reason = "YEARLY_AMOUNT_EXCEEDED"
+	ReasonDuplicateID           Reason = "DUPLICATE_ID"
+	ReasonIDConflict            Reason = "ID_CONFLICT"
+	ReasonMalformedInput        Reason = "MALFORMED_INPUT"
+	ReasonOriginalNotFound      Reason = "ORIGINAL_NOT_FOUND"
+	ReasonOriginalNotAccepted   Reason = "ORIGINAL_NOT_ACCEPTED"
+	ReasonAlreadyReversed       Reason = "ALREADY_REVERSED"
+	ReasonInsufficientFunds     Reason = "INSUFFICIENT_FUNDS"
+)
+
+var reasonMessages = map[Reason]string{
+	ReasonDailyAmountExceeded:   "maximum amount loaded per day exceeded",
+	ReasonDailyCountExceeded:    "maximum number of loads per day exceeded",
+	ReasonWeeklyAmountExceeded:  "maximum amount loaded per week exceeded",
+	ReasonMonthlyAmountExceeded: "maximum amount loaded per month exceeded",
+	ReasonYearlyAmountExceeded:  "maximum amount loaded per year exceeded",
+	ReasonDuplicateID:           "transaction ID already processed for this customer",
+	ReasonIDConflict:            "transaction ID already used with a different payload",
+	ReasonMalformedInput:        "transaction is malformed",
+	ReasonOriginalNotFound:      "reversed transaction not found for this customer",
+	ReasonOriginalNotAccepted:   "reversed transaction is not an accepted load, withdrawal or spend",
+	ReasonAlreadyReversed:       "transaction already reversed",
+	ReasonInsufficientFunds:     "balance too low for the withdrawal, spend or load reversal",
+}
+
+// Message returns the human-readable description of the reason.
+func (r Reason) Message() string {
+	return reasonMessages[r]
+}
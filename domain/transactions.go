@@ -1,20 +1,104 @@
 package domain
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
 
-const DateLayout = "2006-02-01"
+const DateLayout = "2006-01-02"
 
+// TransactionType tells what a transaction does. An empty type is a load.
+type TransactionType string
+
+const (
+	TransactionLoad TransactionType = "load"
+	// TransactionWithdrawal and TransactionSpend take funds from the balance
+	// of the customer.
+	TransactionWithdrawal TransactionType = "withdrawal"
+	TransactionSpend      TransactionType = "spend"
+	// TransactionReversal undoes the accepted transaction whose ID is in
+	// Reverses, giving back the limits it consumed.
+	TransactionReversal TransactionType = "reversal"
+)
+
+// Transaction is a load, withdrawal or spend, or a reversal of one of them.
+// LoadAmount is the amount of every type but reversals, and is also read from
+// amount. ReversedBy is the ID of the reversal that undid the transaction; it
+// is only set by the storage.
 type Transaction struct {
-	ID         string    `json:"id"`
-	CustomerID string    `json:"customer_id"`
-	LoadAmount string    `json:"load_amount"`
-	Time       time.Time `json:"time"`
+	ID         string          `json:"id"`
+	CustomerID string          `json:"customer_id"`
+	LoadAmount Money           `json:"load_amount"`
+	Time       time.Time       `json:"time"`
+	Type       TransactionType `json:"type,omitempty"`
+	Reverses   string          `json:"reverses,omitempty"`
+	ReversedBy string          `json:"reversed_by,omitempty"`
+}
+
+// UnmarshalJSON reads the amount from either load_amount or amount, but not
+// both.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	type plain Transaction
+	var amounts struct {
+		LoadAmount *json.RawMessage `json:"load_amount"`
+		Amount     *Money           `json:"amount"`
+	}
+	if err := json.Unmarshal(data, &amounts); err != nil {
+		return err
+	}
+	if amounts.LoadAmount != nil && amounts.Amount != nil {
+		return fmt.Errorf("%w: both load_amount and amount are set", ErrInvalidAmount)
+	}
+	if err := json.Unmarshal(data, (*plain)(t)); err != nil {
+		return err
+	}
+	if amounts.Amount != nil {
+		t.LoadAmount = *amounts.Amount
+	}
+	return nil
+}
+
+// Validate checks the fields required by the type of the transaction.
+func (t Transaction) Validate() error {
+	switch t.Type {
+	case "", TransactionLoad, TransactionWithdrawal, TransactionSpend:
+		if !t.LoadAmount.GreaterThan(Money{}) {
+			return ErrInvalidAmount
+		}
+	case TransactionReversal:
+		if t.Reverses == "" || t.Reverses == t.ID {
+			return ErrInvalidReversal
+		}
+	default:
+		return ErrUnknownTransactionType
+	}
+	return nil
+}
+
+// Kind returns the type of the transaction, TransactionLoad when empty.
+func (t Transaction) Kind() TransactionType {
+	if t.Type == "" {
+		return TransactionLoad
+	}
+	return t.Type
+}
+
+// IsReversal reports whether the transaction reverses another one.
+func (t Transaction) IsReversal() bool {
+	return t.Type == TransactionReversal
+}
+
+// IsDebit reports whether the transaction takes funds from the customer.
+func (t Transaction) IsDebit() bool {
+	return t.Type == TransactionWithdrawal || t.Type == TransactionSpend
 }
 
 type DailyTransaction struct {
 	Transaction      Transaction
 	TransactionCount int
-	DailyTotal       float64
+	DailyTotal       Money
 }
 
 type WeeklyTransaction struct {
@@ -22,12 +106,214 @@ type WeeklyTransaction struct {
 	Week int
 }
 
+// Start returns the Monday, in UTC, that begins the ISO week.
+func (w WeeklyTransaction) Start() time.Time {
+	jan4 := time.Date(w.Year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	offset := (int(jan4.Weekday()) + 6) % 7
+	return jan4.AddDate(0, 0, (w.Week-1)*7-offset)
+}
+
+// Before reports whether w is an earlier week than other.
+func (w WeeklyTransaction) Before(other WeeklyTransaction) bool {
+	if w.Year != other.Year {
+		return w.Year < other.Year
+	}
+	return w.Week < other.Week
+}
+
 type WeeklyTransactionTotal struct {
-	Value float64
+	Value Money
+}
+
+// MonthlyTransaction identifies a calendar month.
+type MonthlyTransaction struct {
+	Year  int
+	Month time.Month
+}
+
+// Start returns the first day, in UTC, of the month.
+func (m MonthlyTransaction) Start() time.Time {
+	return time.Date(m.Year, m.Month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Before reports whether m is an earlier month than other.
+func (m MonthlyTransaction) Before(other MonthlyTransaction) bool {
+	if m.Year != other.Year {
+		return m.Year < other.Year
+	}
+	return m.Month < other.Month
+}
+
+type MonthlyTransactionTotal struct {
+	Value Money
+}
+
+// YearlyTransaction identifies a calendar year.
+type YearlyTransaction struct {
+	Year int
+}
+
+type YearlyTransactionTotal struct {
+	Value Money
 }
 
 type TransactionResponse struct {
 	ID         string `json:"id"`
 	CustomerID string `json:"customer_id"`
 	Accepted   bool   `json:"accepted"`
+	Reason     Reason `json:"reason,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// TransactionDecision is the response given to a transaction, kept so it can
+// be given again when the same transaction is retried.
+type TransactionDecision struct {
+	Accepted bool   `json:"accepted"`
+	Reason   Reason `json:"reason,omitempty"`
+}
+
+// SamePayload reports whether other carries the same transaction as t.
+func (t Transaction) SamePayload(other Transaction) bool {
+	return t.ID == other.ID &&
+		t.CustomerID == other.CustomerID &&
+		t.LoadAmount.Equal(other.LoadAmount) &&
+		t.Time.Equal(other.Time) &&
+		t.Kind() == other.Kind() &&
+		t.Reverses == other.Reverses
+}
+
+// CustomerState holds the counters of the windows a transaction falls in.
+// Recent holds the accepted loads of the customer from Windows.Since on,
+// oldest first, and is empty when no history was read. Applied is whether
+// the transaction of Windows.TransactionID is already in the counters; the
+// value committed with the state is kept along with them.
+type CustomerState struct {
+	Daily   DailyTransaction
+	Weekly  WeeklyTransactionTotal
+	Monthly MonthlyTransactionTotal
+	Yearly  YearlyTransactionTotal
+	Recent  []LoadEvent
+	Applied bool
+}
+
+// LoadEvent is an accepted load in the history of a customer.
+type LoadEvent struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Amount Money     `json:"amount"`
+}
+
+// Record returns the state with the load added to Recent in time order.
+func (s CustomerState) Record(event LoadEvent) CustomerState {
+	i := sort.Search(len(s.Recent), func(i int) bool {
+		return s.Recent[i].Time.After(event.Time)
+	})
+	recent := make([]LoadEvent, 0, len(s.Recent)+1)
+	recent = append(recent, s.Recent[:i]...)
+	recent = append(recent, event)
+	s.Recent = append(recent, s.Recent[i:]...)
+	return s
+}
+
+// Revert undoes the delta of the load with the ID and drops it from Recent.
+// Counters of windows that were evicted since the load do not go below zero.
+func (s CustomerState) Revert(delta StateDelta, id string) CustomerState {
+	s.Daily.DailyTotal = subFloor(s.Daily.DailyTotal, delta.DailyAmount)
+	s.Daily.TransactionCount -= delta.DailyCount
+	if s.Daily.TransactionCount < 0 {
+		s.Daily.TransactionCount = 0
+	}
+	s.Weekly.Value = subFloor(s.Weekly.Value, delta.WeeklyAmount)
+	s.Monthly.Value = subFloor(s.Monthly.Value, delta.MonthlyAmount)
+	s.Yearly.Value = subFloor(s.Yearly.Value, delta.YearlyAmount)
+	recent := make([]LoadEvent, 0, len(s.Recent))
+	for _, event := range s.Recent {
+		if event.ID != id {
+			recent = append(recent, event)
+		}
+	}
+	if len(recent) == 0 {
+		recent = nil
+	}
+	s.Recent = recent
+	return s
+}
+
+func subFloor(m, other Money) Money {
+	result := m.Sub(other)
+	if result.IsNegative() {
+		return Money{Currency: result.Currency}
+	}
+	return result
+}
+
+// RecentLoads returns the total and number of the loads in Recent after from
+// and up to to.
+func (s CustomerState) RecentLoads(from, to time.Time) (Money, int) {
+	var total Money
+	var count int
+	for _, event := range s.Recent {
+		if event.Time.After(from) && !event.Time.After(to) {
+			total = total.Add(event.Amount)
+			count++
+		}
+	}
+	return total, count
+}
+
+// StateDelta is the change a transaction makes to a CustomerState.
+type StateDelta struct {
+	DailyAmount   Money
+	DailyCount    int
+	WeeklyAmount  Money
+	MonthlyAmount Money
+	YearlyAmount  Money
+}
+
+func (d StateDelta) Add(other StateDelta) StateDelta {
+	return StateDelta{
+		DailyAmount:   d.DailyAmount.Add(other.DailyAmount),
+		DailyCount:    d.DailyCount + other.DailyCount,
+		WeeklyAmount:  d.WeeklyAmount.Add(other.WeeklyAmount),
+		MonthlyAmount: d.MonthlyAmount.Add(other.MonthlyAmount),
+		YearlyAmount:  d.YearlyAmount.Add(other.YearlyAmount),
+	}
+}
+
+func (s CustomerState) Apply(delta StateDelta) CustomerState {
+	s.Daily.DailyTotal = s.Daily.DailyTotal.Add(delta.DailyAmount)
+	s.Daily.TransactionCount += delta.DailyCount
+	s.Weekly.Value = s.Weekly.Value.Add(delta.WeeklyAmount)
+	s.Monthly.Value = s.Monthly.Value.Add(delta.MonthlyAmount)
+	s.Yearly.Value = s.Yearly.Value.Add(delta.YearlyAmount)
+	return s
+}
+
+// Windows identifies the day, week, month and year a transaction is counted
+// in. Since is where the load history read along with them starts; none is
+// read when it is zero. TransactionID, when set, is the transaction whose
+// CustomerState.Applied is read and committed with the counters, so a
+// transaction evaluated again after a failure is not counted twice. Kind is
+// the type of transaction counted, as each type has counters of its own; the
+// counters of loads have the empty kind.
+type Windows struct {
+	Day           string
+	Week          WeeklyTransaction
+	Month         MonthlyTransaction
+	Year          YearlyTransaction
+	Since         time.Time
+	TransactionID string
+	Kind          TransactionType
+}
+
+// DailyWindow is the daily transaction of a customer on Day.
+type DailyWindow struct {
+	Day   string
+	Daily DailyTransaction
+}
+
+// WeeklyWindow is the weekly total of a customer in Week.
+type WeeklyWindow struct {
+	Week  WeeklyTransaction
+	Total WeeklyTransactionTotal
 }
@@ -0,0 +1,138 @@
+package domain_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeeklyTransactionStart(t *testing.T) {
+	testCases := []struct {
+		name          string
+		week          domain.WeeklyTransaction
+		startExpected time.Time
+	}{
+		{name: "week 1 starting in previous year", week: domain.WeeklyTransaction{Year: 2020, Week: 1}, startExpected: time.Date(2019, time.December, 30, 0, 0, 0, 0, time.UTC)},
+		{name: "week 52 of previous year", week: domain.WeeklyTransaction{Year: 1999, Week: 52}, startExpected: time.Date(1999, time.December, 27, 0, 0, 0, 0, time.UTC)},
+		{name: "week starting on january 4", week: domain.WeeklyTransaction{Year: 2010, Week: 1}, startExpected: time.Date(2010, time.January, 4, 0, 0, 0, 0, time.UTC)},
+		{name: "week 53", week: domain.WeeklyTransaction{Year: 2020, Week: 53}, startExpected: time.Date(2020, time.December, 28, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := tc.week.Start()
+			assert.Equal(t, tc.startExpected, start)
+			year, week := start.ISOWeek()
+			assert.Equal(t, tc.week, domain.WeeklyTransaction{Year: year, Week: week})
+		})
+	}
+}
+
+func TestWeeklyTransactionBefore(t *testing.T) {
+	assert.True(t, domain.WeeklyTransaction{Year: 1999, Week: 52}.Before(domain.WeeklyTransaction{Year: 2000, Week: 1}))
+	assert.True(t, domain.WeeklyTransaction{Year: 2000, Week: 1}.Before(domain.WeeklyTransaction{Year: 2000, Week: 2}))
+	assert.False(t, domain.WeeklyTransaction{Year: 2000, Week: 2}.Before(domain.WeeklyTransaction{Year: 2000, Week: 2}))
+}
+
+func TestTransactionSamePayload(t *testing.T) {
+	at := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	transaction := domain.Transaction{ID: "1", CustomerID: "2", LoadAmount: domain.NewMoney(100), Time: at}
+	other := transaction
+	other.Time = at.In(time.FixedZone("EST", -5*60*60))
+	assert.True(t, transaction.SamePayload(other))
+	other.LoadAmount = domain.NewMoney(101)
+	assert.False(t, transaction.SamePayload(other))
+	other = transaction
+	other.Time = at.Add(time.Second)
+	assert.False(t, transaction.SamePayload(other))
+	other = transaction
+	other.Type, other.Reverses = domain.TransactionReversal, "0"
+	assert.False(t, transaction.SamePayload(other))
+	other = transaction
+	other.Type = domain.TransactionLoad
+	assert.True(t, transaction.SamePayload(other))
+	other.Type = domain.TransactionWithdrawal
+	assert.False(t, transaction.SamePayload(other))
+	reversal := domain.Transaction{ID: "3", CustomerID: "2", Type: domain.TransactionReversal, Reverses: "1"}
+	other = reversal
+	other.LoadAmount = domain.Money{Currency: domain.DefaultCurrency}
+	assert.True(t, reversal.SamePayload(other))
+}
+
+func TestTransactionValidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		transaction domain.Transaction
+		errExpected error
+	}{
+		{name: "load", transaction: domain.Transaction{ID: "1", LoadAmount: domain.NewMoney(1)}},
+		{name: "typed load", transaction: domain.Transaction{ID: "1", Type: domain.TransactionLoad, LoadAmount: domain.NewMoney(1)}},
+		{name: "load without amount", transaction: domain.Transaction{ID: "1"}, errExpected: domain.ErrInvalidAmount},
+		{name: "withdrawal", transaction: domain.Transaction{ID: "1", Type: domain.TransactionWithdrawal, LoadAmount: domain.NewMoney(1)}},
+		{name: "spend without amount", transaction: domain.Transaction{ID: "1", Type: domain.TransactionSpend}, errExpected: domain.ErrInvalidAmount},
+		{name: "reversal", transaction: domain.Transaction{ID: "2", Type: domain.TransactionReversal, Reverses: "1"}},
+		{name: "reversal without original", transaction: domain.Transaction{ID: "2", Type: domain.TransactionReversal}, errExpected: domain.ErrInvalidReversal},
+		{name: "reversal of itself", transaction: domain.Transaction{ID: "2", Type: domain.TransactionReversal, Reverses: "2"}, errExpected: domain.ErrInvalidReversal},
+		{name: "unknown type", transaction: domain.Transaction{ID: "1", Type: "refund", LoadAmount: domain.NewMoney(1)}, errExpected: domain.ErrUnknownTransactionType},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.errExpected, tc.transaction.Validate())
+		})
+	}
+}
+
+func TestTransactionUnmarshalAmount(t *testing.T) {
+	testCases := []struct {
+		name           string
+		fund           string
+		amountExpected domain.Money
+		errExpected    bool
+	}{
+		{name: "load amount", fund: `{"id":"1","load_amount":"$1.00"}`, amountExpected: domain.NewMoney(100)},
+		{name: "amount", fund: `{"id":"1","type":"spend","amount":"$2.50"}`, amountExpected: domain.NewMoney(250)},
+		{name: "both amounts", fund: `{"id":"1","load_amount":"$1.00","amount":"$1.00"}`, errExpected: true},
+		{name: "invalid amount", fund: `{"id":"1","amount":"abc"}`, errExpected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var transaction domain.Transaction
+			err := json.Unmarshal([]byte(tc.fund), &transaction)
+			if tc.errExpected {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, "1", transaction.ID)
+			assert.Equal(t, tc.amountExpected, transaction.LoadAmount)
+		})
+	}
+}
+
+func TestCustomerStateRevert(t *testing.T) {
+	at := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	delta := domain.StateDelta{
+		DailyAmount:   domain.NewMoney(100),
+		DailyCount:    1,
+		WeeklyAmount:  domain.NewMoney(100),
+		MonthlyAmount: domain.NewMoney(100),
+		YearlyAmount:  domain.NewMoney(100),
+	}
+	state := domain.CustomerState{}.Apply(delta).Apply(delta)
+	state = state.Record(domain.LoadEvent{ID: "1", Time: at, Amount: domain.NewMoney(100)})
+	state = state.Record(domain.LoadEvent{ID: "2", Time: at.Add(time.Hour), Amount: domain.NewMoney(100)})
+
+	reverted := state.Revert(delta, "1")
+	assert.Equal(t, domain.CustomerState{}.Apply(delta).Record(domain.LoadEvent{ID: "2", Time: at.Add(time.Hour), Amount: domain.NewMoney(100)}), reverted)
+
+	// The windows of the load were evicted.
+	reverted = domain.CustomerState{}.Revert(delta, "1")
+	assert.Equal(t, 0, reverted.Daily.TransactionCount)
+	assert.False(t, reverted.Weekly.Value.IsNegative())
+	assert.False(t, reverted.Yearly.Value.IsNegative())
+}
@@ -2,4 +2,7 @@ module github.com/danielfmelo/load-funds-handler
 
 go 1.13
 
-require github.com/stretchr/testify v1.6.1
+require (
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/stretchr/testify v1.6.1
+)
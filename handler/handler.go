@@ -3,214 +3,502 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/danielfmelo/load-funds-handler/domain"
 	"github.com/danielfmelo/load-funds-handler/storage"
 )
 
+type HandlerTransaction interface {
+	Transaction(fund []byte)
+	Process(fund []byte) Result
+	Check(fund []byte) Result
+}
+
+// Result is the outcome of processing a fund. Event is the JSON response to
+// the transaction and Err the JSON domain.ErrorEvent; a malformed fund
+// answered with a rejection has both, any other fund one of them.
+// Reason is set for rejections, and also for errors caused by a duplicated or
+// malformed fund, so callers can tell them apart from storage failures.
+// Replayed is set when Event is the response already given to the same
+// transaction.
+type Result struct {
+	Event    []byte
+	Err      []byte
+	Reason   domain.Reason
+	Replayed bool
+}
+
+// ResponseFormat selects the JSON published for each transaction.
+type ResponseFormat string
+
 const (
-	maximumValuePerDay        float64 = 5000
-	maximumTransactionsPerDay int     = 3
-	maximumValuePerWeek       float64 = 20000
+	// ResponseDetailed adds the reason code and message to rejections, and
+	// also answers duplicate and malformed transactions with a rejection.
+	ResponseDetailed ResponseFormat = "detailed"
+	// ResponseLegacy keeps the original id, customer_id and accepted fields,
+	// reporting duplicate and malformed transactions as errors only.
+	ResponseLegacy ResponseFormat = "legacy"
 )
 
-type HandlerTransaction interface {
-	Transaction(fund []byte)
+func (f ResponseFormat) Validate() error {
+	if f != ResponseDetailed && f != ResponseLegacy {
+		return fmt.Errorf("unknown response format %q", f)
+	}
+	return nil
 }
 
 type HandlerTransactionService struct {
 	storage        storage.Database
+	limits         Limits
+	profiles       storage.CustomerProfiles
+	ledger         storage.Ledger
+	tiers          map[domain.Tier]domain.LimitOverride
+	format         ResponseFormat
+	location       *time.Location
+	locations      sync.Map
+	rules          []Rule
 	chPublisher    chan []byte
 	chErrPublisher chan []byte
 }
 
+type Option func(hs *HandlerTransactionService)
+
+// WithRules replaces the default rule chain. Rules are evaluated in the
+// given order and the first denial rejects the transaction.
+func WithRules(rules ...Rule) Option {
+	return func(hs *HandlerTransactionService) {
+		hs.rules = rules
+	}
+}
+
+// WithLocation sets the business timezone the daily and weekly windows are
+// computed in, for customers without a timezone of their own. It is UTC by
+// default.
+func WithLocation(location *time.Location) Option {
+	return func(hs *HandlerTransactionService) {
+		hs.location = location
+	}
+}
+
+func WithResponseFormat(format ResponseFormat) Option {
+	return func(hs *HandlerTransactionService) {
+		hs.format = format
+	}
+}
+
+// WithCustomerLimits looks up each customer profile in profiles and checks
+// the transaction against the limits of its tier and its own override.
+func WithCustomerLimits(profiles storage.CustomerProfiles, tiers map[domain.Tier]domain.LimitOverride) Option {
+	return func(hs *HandlerTransactionService) {
+		hs.profiles = profiles
+		hs.tiers = tiers
+	}
+}
+
+// WithLedger posts every accepted transaction to ledger, whose balances and
+// statements the handler can then answer. Withdrawals and spends require it.
+func WithLedger(ledger storage.Ledger) Option {
+	return func(hs *HandlerTransactionService) {
+		hs.ledger = ledger
+	}
+}
+
 func New(
 	storage storage.Database,
+	limits Limits,
 	chPublish chan []byte,
 	chErrPublish chan []byte,
+	opts ...Option,
 ) *HandlerTransactionService {
-	return &HandlerTransactionService{
+	hs := &HandlerTransactionService{
 		storage:        storage,
+		limits:         limits,
+		format:         ResponseDetailed,
+		location:       time.UTC,
+		rules:          DefaultRules(),
 		chPublisher:    chPublish,
 		chErrPublisher: chErrPublish,
 	}
+	for _, opt := range opts {
+		opt(hs)
+	}
+	return hs
 }
 
+// Transaction processes the fund and publishes the result to the output or
+// the error channel.
 func (hs *HandlerTransactionService) Transaction(fund []byte) {
+	result := hs.Process(fund)
+	if result.Err != nil {
+		hs.chErrPublisher <- result.Err
+	}
+	if result.Event != nil {
+		hs.chPublisher <- result.Event
+	}
+}
+
+// Process evaluates the fund, records it when accepted and returns the result
+// instead of publishing it.
+func (hs *HandlerTransactionService) Process(fund []byte) Result {
+	return hs.process(fund, false)
+}
+
+// Check evaluates the fund against the current state of the customer and
+// returns the same result Process would, but records nothing: neither the
+// transaction ID nor the limits it would consume.
+func (hs *HandlerTransactionService) Check(fund []byte) Result {
+	return hs.process(fund, true)
+}
+
+func (hs *HandlerTransactionService) process(fund []byte, dryRun bool) Result {
 	var transaction domain.Transaction
 	if err := json.Unmarshal(fund, &transaction); err != nil {
-		msg := fmt.Sprintf("error to unmarshal fund %s", string(fund))
-		hs.publishError(msg, err)
-		return
+		header, ok := unmarshalHeader(fund)
+		event := errorEvent(fund, header, domain.StageDecode)
+		if !ok {
+			return errorResult(event, "error to unmarshal fund", err)
+		}
+		return hs.reject(header, event, "error to unmarshal fund", err)
 	}
-	if err := hs.storage.AddTransaction(transaction); err != nil {
-		msg := fmt.Sprintf("error to add transaction with id: %s", transaction.ID)
-		hs.publishError(msg, err)
-		return
+	// Only the storage marks transactions reversed.
+	transaction.ReversedBy = ""
+	if err := transaction.Validate(); err != nil {
+		event := errorEvent(fund, transaction, domain.StageValidate)
+		return hs.reject(transaction, event, "error to validate transaction", err)
 	}
-
-	valid, daily, err := hs.isLoadPerDayValid(transaction)
-	if err != nil {
-		hs.publishError("error to validate transaction per day", err)
-		return
+	if transaction.IsDebit() && hs.ledger == nil {
+		// Without a ledger there is no balance to debit.
+		return errorResult(errorEvent(fund, transaction, domain.StageLedger), "error to check balance", domain.ErrLedgerDisabled)
 	}
-	if !valid {
-		if err := hs.publishInvalidTransaction(transaction); err != nil {
-			hs.publishError("error to publish invalid transaction", err)
+	addTransaction := hs.storage.AddTransaction
+	if dryRun {
+		addTransaction = hs.checkTransaction
+	}
+	if err := addTransaction(transaction); err != nil {
+		event := errorEvent(fund, transaction, domain.StageRecord)
+		if err == domain.ErrTransactionAlreadyExist {
+			return hs.duplicate(fund, transaction, event, err, dryRun)
 		}
-		return
+		return errorResult(event, "error to add transaction", err)
 	}
+	return hs.evaluate(fund, transaction, dryRun)
+}
 
-	valid, weekly, weeklyTotal, err := hs.isLoadPerWeekValid(transaction)
+// evaluate decides a transaction already added, posts it when accepted and
+// saves the decision. The counters keep whether they hold the transaction,
+// so one evaluated again after failing part way is only counted once.
+func (hs *HandlerTransactionService) evaluate(fund []byte, transaction domain.Transaction, dryRun bool) Result {
+	if transaction.IsReversal() {
+		return hs.reverse(fund, transaction, dryRun)
+	}
+
+	limits, location, err := hs.customerSettings(transaction.CustomerID)
 	if err != nil {
-		hs.publishError("error to validate transaction per week", err)
-		return
+		return errorResult(errorEvent(fund, transaction, domain.StageLimits), "error to get customer limits", err)
 	}
-	if !valid {
-		if err := hs.publishInvalidTransaction(transaction); err != nil {
-			hs.publishError("error to publish invalid transaction", err)
+	limits = limits.For(transaction.Kind())
+
+	if transaction.IsDebit() {
+		funded, err := hs.funded(transaction, transaction.LoadAmount)
+		if err != nil {
+			return errorResult(errorEvent(fund, transaction, domain.StageLedger), "error to get balance", err)
+		}
+		if !funded {
+			return hs.decide(fund, transaction, Deny(domain.ReasonInsufficientFunds), dryRun)
 		}
-		return
 	}
-	day := convertTimeToDay(transaction.Time)
-	if err = hs.storage.AddDailyTransaction(transaction.CustomerID, day, daily); err != nil {
-		hs.publishError("error to add daily transaction", err)
-		return
+
+	var decision Decision
+	var windows domain.Windows
+	evaluate := func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		if state.Applied {
+			// An earlier attempt counted the transaction and failed after.
+			decision = Allow(counterDelta(transaction))
+			return state, false, nil
+		}
+		decision = EvaluateRules(hs.rules, transaction, state, limits)
+		if !decision.Allowed {
+			return state, false, nil
+		}
+		state = state.Apply(decision.Delta)
+		state.Applied = true
+		if !windows.Since.IsZero() {
+			state = state.Record(domain.LoadEvent{
+				ID:     transaction.ID,
+				Time:   transaction.Time,
+				Amount: transaction.LoadAmount,
+			})
+		}
+		return state, !dryRun, nil
 	}
-	if err = hs.storage.AddWeeklyTransaction(transaction.CustomerID, weekly, weeklyTotal); err != nil {
-		hs.publishError("error to add weekly transaction", err)
-		return
+	windows = windowsAt(transaction.Time, location)
+	windows.Since = limits.Modes.historySince(transaction.Time)
+	windows.TransactionID = transaction.ID
+	windows.Kind = counterKind(transaction)
+	if err := hs.storage.UpdateCustomerState(transaction.CustomerID, windows, evaluate); err != nil {
+		return errorResult(errorEvent(fund, transaction, domain.StageEvaluate), "error to update customer state", err)
 	}
-	if err = hs.publishValidTransaction(transaction); err != nil {
-		hs.publishError("error to publish valid transaction", err)
+	if decision.Allowed && !dryRun {
+		err := hs.post(transaction, domain.Entries(transaction))
+		if err == domain.ErrInsufficientFunds {
+			// Another debit spent the funds since they were checked, so
+			// the limits this one consumed are given back.
+			revert := func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+				if !state.Applied {
+					return state, false, nil
+				}
+				state = state.Revert(decision.Delta, transaction.ID)
+				state.Applied = false
+				return state, true, nil
+			}
+			if err := hs.storage.UpdateCustomerState(transaction.CustomerID, windows, revert); err != nil {
+				return errorResult(errorEvent(fund, transaction, domain.StageEvaluate), "error to update customer state", err)
+			}
+			decision = Deny(domain.ReasonInsufficientFunds)
+		} else if err != nil {
+			return errorResult(errorEvent(fund, transaction, domain.StageLedger), "error to post ledger entries", err)
+		}
 	}
+	return hs.decide(fund, transaction, decision, dryRun)
 }
 
-func (hs *HandlerTransactionService) isLoadPerDayValid(transaction domain.Transaction) (bool, domain.DailyTransaction, error) {
-	day := convertTimeToDay(transaction.Time)
-	daily, err := hs.storage.GetDailyTransaction(transaction.CustomerID, day)
-	if err != nil {
-		if err != domain.ErrNotFound {
-			return false, daily, err
+// decide saves the decision, unless dryRun is set, and answers it.
+func (hs *HandlerTransactionService) decide(fund []byte, transaction domain.Transaction, decision Decision, dryRun bool) Result {
+	if !dryRun {
+		saved := domain.TransactionDecision{Accepted: decision.Allowed, Reason: decision.Reason}
+		if err := hs.storage.SetDecision(transaction.CustomerID, transaction.ID, saved); err != nil {
+			return errorResult(errorEvent(fund, transaction, domain.StageDecision), "error to save decision", err)
 		}
 	}
+	if !decision.Allowed {
+		return hs.invalidResult(transaction, decision.Reason)
+	}
+	return hs.validResult(transaction)
+}
 
-	isMaximum, daily, err := isMaximumValueLoadPerDay(daily, transaction.LoadAmount)
-	if err != nil {
-		return false, daily, err
+// funded reports whether the customer holds the amount the transaction
+// takes, or the transaction was already posted by an earlier attempt. The
+// ledger checks it again when the transaction is posted, as other debits may
+// spend the funds in between.
+func (hs *HandlerTransactionService) funded(transaction domain.Transaction, amount domain.Money) (bool, error) {
+	posted, err := hs.ledger.Posted(transaction.CustomerID, transaction.ID)
+	if err != nil || posted {
+		return posted, err
 	}
-	if isMaximum {
-		return false, daily, nil
+	balance, err := hs.Balance(transaction.CustomerID)
+	if err != nil {
+		return false, err
 	}
-	isMaximum, daily = isMaximumLoadPerDay(daily)
-	return !isMaximum, daily, nil
+	return !balance.Balance.Sub(amount).IsNegative(), nil
+}
 
+// counterKind is the Windows.Kind the velocity counters of the transaction
+// are kept under: the empty kind for loads, and the type for withdrawals and
+// spends, so each type has counters of its own.
+func counterKind(transaction domain.Transaction) domain.TransactionType {
+	if transaction.Kind() == domain.TransactionLoad {
+		return ""
+	}
+	return transaction.Kind()
 }
 
-func handleWeekNotFoundError(transaction domain.Transaction) (domain.WeeklyTransactionTotal, error) {
-	transactionAmount, err := convertRawValue(transaction.LoadAmount)
-	if err != nil {
-		return domain.WeeklyTransactionTotal{}, err
+// duplicate answers a transaction whose ID the customer already used. The
+// same transaction gets the response it got the first time, or is evaluated
+// again when the first attempt failed before it was decided, while a
+// different one with the same ID is rejected as a conflict. The legacy
+// format keeps reporting every decided duplicate as an error.
+func (hs *HandlerTransactionService) duplicate(fund []byte, transaction domain.Transaction, event domain.ErrorEvent, err error, dryRun bool) Result {
+	stored, getErr := hs.storage.GetTransaction(transaction.CustomerID, transaction.ID)
+	if getErr != nil {
+		return errorResult(event, "error to get transaction", getErr)
 	}
-	week := domain.WeeklyTransactionTotal{Value: transactionAmount}
-	return week, nil
+	if !stored.SamePayload(transaction) {
+		if hs.format == ResponseLegacy {
+			return hs.reject(transaction, event, "error to add transaction", err)
+		}
+		return hs.invalidResult(transaction, domain.ReasonIDConflict)
+	}
+	decision, getErr := hs.storage.GetDecision(transaction.CustomerID, transaction.ID)
+	if getErr == domain.ErrNotFound {
+		return hs.evaluate(fund, transaction, dryRun)
+	}
+	if getErr != nil {
+		return errorResult(event, "error to get decision", getErr)
+	}
+	if hs.format == ResponseLegacy {
+		return hs.reject(transaction, event, "error to add transaction", err)
+	}
+	result := hs.responseResult(transaction, decision.Accepted, decision.Reason)
+	result.Replayed = result.Err == nil
+	return result
 }
 
-func isMaximumValueLoadPerDay(daily domain.DailyTransaction, loadAmount string) (bool, domain.DailyTransaction, error) {
-	transactionAmount, err := convertRawValue(loadAmount)
-	if err != nil {
-		return false, daily, err
+// post records the entries of an accepted transaction before its decision is
+// saved, so every transaction answered as accepted is in the ledger. Entries
+// that take funds from the customer, those of withdrawals, spends and load
+// reversals, are only posted when funded.
+func (hs *HandlerTransactionService) post(transaction domain.Transaction, entries []domain.LedgerEntry) error {
+	if hs.ledger == nil {
+		return nil
 	}
-	tt := daily.DailyTotal + transactionAmount
-	if tt > maximumValuePerDay {
-		return true, daily, nil
+	if domain.CustomerBalance(entries, transaction.CustomerID).IsNegative() {
+		return hs.ledger.PostFundedEntries(transaction.CustomerID, transaction.ID, entries)
 	}
-	daily.DailyTotal = daily.DailyTotal + transactionAmount
-	return false, daily, err
+	return hs.ledger.PostEntries(transaction.CustomerID, transaction.ID, entries)
 }
 
-func isMaximumLoadPerDay(daily domain.DailyTransaction) (bool, domain.DailyTransaction) {
-	if daily.TransactionCount+1 > maximumTransactionsPerDay {
-		return true, daily
+// checkTransaction fails like AddTransaction would, without adding the
+// transaction.
+func (hs *HandlerTransactionService) checkTransaction(transaction domain.Transaction) error {
+	if transaction.ID == "" {
+		return domain.ErrTransactionEmptyID
 	}
-	daily.TransactionCount++
-	return false, daily
+	_, err := hs.storage.GetTransaction(transaction.CustomerID, transaction.ID)
+	switch err {
+	case nil:
+		return domain.ErrTransactionAlreadyExist
+	case domain.ErrNotFound:
+		return nil
+	}
+	return err
 }
 
-func (hs *HandlerTransactionService) isLoadPerWeekValid(
-	transaction domain.Transaction,
-) (
-	bool,
-	domain.WeeklyTransaction,
-	domain.WeeklyTransactionTotal,
-	error,
-) {
-	year, week := transaction.Time.ISOWeek()
-	weekly := domain.WeeklyTransaction{Year: year, Week: week}
-	weeklyTotal, err := hs.storage.GetWeeklyTransaction(transaction.CustomerID, weekly)
+// customerSettings returns the limits of the customer and the timezone its
+// windows are computed in.
+func (hs *HandlerTransactionService) customerSettings(customerID string) (Limits, *time.Location, error) {
+	if hs.profiles == nil {
+		return hs.limits, hs.location, nil
+	}
+	profile, err := hs.profiles.GetCustomerProfile(customerID)
 	if err != nil {
-		if err == domain.ErrNotFound {
-			total, err := handleWeekNotFoundError(transaction)
-			return true, weekly, total, err
+		if err != domain.ErrNotFound {
+			return hs.limits, hs.location, err
 		}
-		return false, weekly, weeklyTotal, err
+		profile = domain.CustomerProfile{CustomerID: customerID}
 	}
-	transactionAmount, err := convertRawValue(transaction.LoadAmount)
+	location, err := hs.customerLocation(profile)
 	if err != nil {
-		return false, weekly, weeklyTotal, err
+		return hs.limits, hs.location, err
+	}
+	return hs.limits.ForProfile(hs.tiers, profile), location, nil
+}
+
+// customerLocation loads the timezone of the profile once and keeps it for
+// the next transactions.
+func (hs *HandlerTransactionService) customerLocation(profile domain.CustomerProfile) (*time.Location, error) {
+	if profile.Timezone == "" {
+		return hs.location, nil
 	}
-	if (weeklyTotal.Value + transactionAmount) > maximumValuePerWeek {
-		return false, weekly, weeklyTotal, nil
+	if location, ok := hs.locations.Load(profile.Timezone); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(profile.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("error to load timezone of customer %s: %w", profile.CustomerID, err)
+	}
+	hs.locations.Store(profile.Timezone, location)
+	return location, nil
+}
+
+// unmarshalHeader reads only the ID and customer ID of a fund that failed to
+// unmarshal, so that it can still be answered.
+func unmarshalHeader(fund []byte) (domain.Transaction, bool) {
+	var header struct {
+		ID         string `json:"id"`
+		CustomerID string `json:"customer_id"`
+	}
+	if err := json.Unmarshal(fund, &header); err != nil || header.ID == "" {
+		return domain.Transaction{}, false
+	}
+	return domain.Transaction{ID: header.ID, CustomerID: header.CustomerID}, true
+}
+
+// windowsAt returns the day and ISO week of dateTime in location, so the same
+// instant falls in the same windows whatever offset it was sent with.
+func windowsAt(dateTime time.Time, location *time.Location) domain.Windows {
+	dateTime = dateTime.In(location)
+	year, week := dateTime.ISOWeek()
+	return domain.Windows{
+		Day:   convertTimeToDay(dateTime),
+		Week:  domain.WeeklyTransaction{Year: year, Week: week},
+		Month: domain.MonthlyTransaction{Year: dateTime.Year(), Month: dateTime.Month()},
+		Year:  domain.YearlyTransaction{Year: dateTime.Year()},
 	}
-	weeklyTotal.Value = weeklyTotal.Value + transactionAmount
-	return true, weekly, weeklyTotal, nil
 }
 
 func convertTimeToDay(dateTime time.Time) string {
 	return dateTime.Format(domain.DateLayout)
 }
 
-func convertRawValue(raw string) (float64, error) {
-	value := strings.Replace(raw, "$", "", 1)
-	valueFloat, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		return float64(0.0), err
-	}
-	return valueFloat, nil
+func (hs *HandlerTransactionService) validResult(transaction domain.Transaction) Result {
+	return hs.responseResult(transaction, true, "")
 }
 
-func (hs *HandlerTransactionService) publishValidTransaction(transaction domain.Transaction) error {
-	valid := domain.TransactionResponse{
+func (hs *HandlerTransactionService) invalidResult(transaction domain.Transaction, reason domain.Reason) Result {
+	return hs.responseResult(transaction, false, reason)
+}
+
+func (hs *HandlerTransactionService) responseResult(transaction domain.Transaction, accepted bool, reason domain.Reason) Result {
+	response := domain.TransactionResponse{
 		ID:         transaction.ID,
 		CustomerID: transaction.CustomerID,
-		Accepted:   true,
+		Accepted:   accepted,
+	}
+	if hs.format != ResponseLegacy {
+		response.Reason = reason
+		response.Message = reason.Message()
 	}
-	event, err := json.Marshal(valid)
+	event, err := json.Marshal(response)
 	if err != nil {
-		return err
+		return errorResult(errorEvent(nil, transaction, domain.StageEncode), "error to marshal transaction response", err)
 	}
-	hs.chPublisher <- event
-	return nil
+	return Result{Event: event, Reason: reason}
 }
 
-func (hs *HandlerTransactionService) publishInvalidTransaction(transaction domain.Transaction) error {
-	invalidTransaction := domain.TransactionResponse{
-		ID:         transaction.ID,
-		CustomerID: transaction.CustomerID,
-		Accepted:   false,
+// reject reports a transaction that cannot be evaluated as an error and,
+// unless the format is legacy, also answers it with a rejection.
+func (hs *HandlerTransactionService) reject(transaction domain.Transaction, event domain.ErrorEvent, message string, err error) Result {
+	result := errorResult(event, message, err)
+	if hs.format == ResponseLegacy {
+		return result
 	}
-	event, err := json.Marshal(invalidTransaction)
-	if err != nil {
-		return err
+	rejection := hs.invalidResult(transaction, result.Reason)
+	if rejection.Err == nil {
+		rejection.Err = result.Err
 	}
-	hs.chPublisher <- event
-	return nil
+	return rejection
 }
 
-func (hs *HandlerTransactionService) publishError(message string, err error) {
-	msg := fmt.Sprintf("msg: %s error: %s", message, err)
-	hs.chErrPublisher <- []byte(msg)
+func errorEvent(fund []byte, transaction domain.Transaction, stage domain.ErrorStage) domain.ErrorEvent {
+	return domain.ErrorEvent{
+		TransactionID: transaction.ID,
+		CustomerID:    transaction.CustomerID,
+		Stage:         stage,
+		Input:         string(fund),
+	}
+}
+
+// errorResult completes the event with the error and its kind. Errors caused
+// by a malformed or duplicated fund also carry the matching reason.
+func errorResult(event domain.ErrorEvent, message string, err error) Result {
+	event.Message = message
+	event.Error = err.Error()
+	var reason domain.Reason
+	switch {
+	case event.Stage == domain.StageDecode || event.Stage == domain.StageValidate:
+		event.Kind = domain.ErrorKindMalformed
+		reason = domain.ReasonMalformedInput
+	case err == domain.ErrTransactionAlreadyExist:
+		event.Kind = domain.ErrorKindDuplicate
+		reason = domain.ReasonDuplicateID
+	case event.Stage == domain.StageEncode:
+		event.Kind = domain.ErrorKindInternal
+	default:
+		event.Kind = domain.ErrorKindStorage
+	}
+	// An event holds only strings, so marshaling it cannot fail.
+	raw, _ := json.Marshal(event)
+	return Result{Err: raw, Reason: reason}
 }
@@ -1,6 +1,11 @@
 package handler
 
-import "github.com/stretchr/testify/mock"
+import (
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/stretchr/testify/mock"
+)
 
 type HandlerMock struct {
 	mock.Mock
@@ -9,3 +14,28 @@ type HandlerMock struct {
 func (h *HandlerMock) Transaction(fund []byte) {
 	h.Called(fund)
 }
+
+func (h *HandlerMock) Process(fund []byte) Result {
+	args := h.Called(fund)
+	return args.Get(0).(Result)
+}
+
+func (h *HandlerMock) Check(fund []byte) Result {
+	args := h.Called(fund)
+	return args.Get(0).(Result)
+}
+
+func (h *HandlerMock) Remaining(customerID string, at time.Time) (domain.RemainingLimits, error) {
+	args := h.Called(customerID, at)
+	return args.Get(0).(domain.RemainingLimits), args.Error(1)
+}
+
+func (h *HandlerMock) Balance(customerID string) (domain.Balance, error) {
+	args := h.Called(customerID)
+	return args.Get(0).(domain.Balance), args.Error(1)
+}
+
+func (h *HandlerMock) Statement(customerID string, from, to time.Time) (domain.Statement, error) {
+	args := h.Called(customerID, from, to)
+	return args.Get(0).(domain.Statement), args.Error(1)
+}
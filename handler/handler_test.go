@@ -9,6 +9,7 @@ import (
 	"github.com/danielfmelo/load-funds-handler/domain"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	"github.com/danielfmelo/load-funds-handler/handler"
 
@@ -16,169 +17,704 @@ import (
 )
 
 type handlerTest struct {
-	repo *storage.StorageMock
+	repo     *storage.StorageMock
+	profiles *storage.CustomerProfilesMock
 }
 
 func newSuite() *handlerTest {
-	return &handlerTest{repo: &storage.StorageMock{}}
+	repo := &storage.StorageMock{}
+	repo.On("SetDecision", mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	return &handlerTest{repo: repo, profiles: &storage.CustomerProfilesMock{}}
 }
 
 func fakeTransaction(t *testing.T, amount string) (domain.Transaction, []byte) {
+	loadAmount, err := domain.ParseMoney("$" + amount)
+	assert.Nil(t, err)
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "321",
-		LoadAmount: "$" + amount,
+		LoadAmount: loadAmount,
 		Time:       time.Now(),
 	}
 	transaction, err := json.Marshal(fund)
 	assert.Nil(t, err)
-	fund.Time.Format(domain.DateLayout)
 	return fund, transaction
 }
 
+// fakeWindows returns the windows of the transaction in UTC, the zone of
+// customers without one configured.
+func fakeWindows(transaction domain.Transaction) domain.Windows {
+	at := transaction.Time.In(time.UTC)
+	year, week := at.ISOWeek()
+	return domain.Windows{
+		Day:           at.Format(domain.DateLayout),
+		Week:          domain.WeeklyTransaction{Year: year, Week: week},
+		Month:         domain.MonthlyTransaction{Year: at.Year(), Month: at.Month()},
+		Year:          domain.YearlyTransaction{Year: at.Year()},
+		TransactionID: transaction.ID,
+	}
+}
+
+// applied returns the state as committed with the transaction counted.
+func applied(state domain.CustomerState) domain.CustomerState {
+	state.Applied = true
+	return state
+}
+
+func decodeErrorEvent(t *testing.T, raw []byte) domain.ErrorEvent {
+	var event domain.ErrorEvent
+	assert.Nil(t, json.Unmarshal(raw, &event))
+	return event
+}
+
 func TestTransactionShouldReceiveUnmarshalError(t *testing.T) {
 	suite := newSuite()
 	chOut := make(chan []byte, 1)
 	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	fund := []byte("with error")
 	h.Transaction(fund)
 	record := <-chErr
-	errExpected := "msg: error to unmarshal fund with error error: invalid character 'w' looking for beginning of value"
-	assert.Equal(t, errExpected, string(record))
+	errExpected := domain.ErrorEvent{
+		Stage:   domain.StageDecode,
+		Kind:    domain.ErrorKindMalformed,
+		Message: "error to unmarshal fund",
+		Error:   "invalid character 'w' looking for beginning of value",
+		Input:   "with error",
+	}
+	assert.Equal(t, errExpected, decodeErrorEvent(t, record))
 }
 
-func TestTransactionShouldReceiveStorageGetDailyTransactionError(t *testing.T) {
+func TestTransactionShouldReceiveStorageUpdateCustomerStateError(t *testing.T) {
 	suite := newSuite()
 	chOut := make(chan []byte, 1)
 	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	transaction, fund := fakeTransaction(t, "100")
 	fakeErr := errors.New("some error")
-	fakeDaily := domain.DailyTransaction{}
 	suite.repo.On("AddTransaction").Return(nil).Once()
-	suite.repo.On("GetDailyTransaction", transaction.CustomerID, transaction.Time.Format(domain.DateLayout)).Return(fakeDaily, fakeErr).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(domain.CustomerState{}, fakeErr).Once()
 	h.Transaction(fund)
 	record := <-chErr
-	errExpected := "msg: error to validate transaction per day error: some error"
-	assert.Equal(t, string(record), errExpected)
+	errExpected := domain.ErrorEvent{
+		TransactionID: "123",
+		CustomerID:    "321",
+		Stage:         domain.StageEvaluate,
+		Kind:          domain.ErrorKindStorage,
+		Message:       "error to update customer state",
+		Error:         "some error",
+		Input:         string(fund),
+	}
+	assert.Equal(t, errExpected, decodeErrorEvent(t, record))
 }
 
 func TestTransactionShouldReceiveInvalidDailyAmount(t *testing.T) {
 	suite := newSuite()
 	chOut := make(chan []byte, 1)
 	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	transaction, fund := fakeTransaction(t, "2500.01")
-	fakeDaily := domain.DailyTransaction{DailyTotal: 2500.00}
+	fakeState := domain.CustomerState{Daily: domain.DailyTransaction{DailyTotal: domain.NewMoney(250000)}}
 	suite.repo.On("AddTransaction").Return(nil).Once()
-	suite.repo.On("GetDailyTransaction", transaction.CustomerID, transaction.Time.Format(domain.DateLayout)).Return(fakeDaily, nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(fakeState, nil).Once()
 	h.Transaction(fund)
 	record := <-chOut
-	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false}"
+	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"DAILY_AMOUNT_EXCEEDED\",\"message\":\"maximum amount loaded per day exceeded\"}"
 	assert.Equal(t, string(record), msgExpected)
+	suite.repo.AssertExpectations(t)
 }
 
 func TestTransactionShouldReceiveValidDailyAmount(t *testing.T) {
 	suite := newSuite()
 	chOut := make(chan []byte, 1)
 	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	transaction, fund := fakeTransaction(t, "2500.00")
-	fakeDaily := domain.DailyTransaction{DailyTotal: 2500.00}
-	year, week := transaction.Time.ISOWeek()
-	fakeWeeklyTransaction := domain.WeeklyTransaction{Year: year, Week: week}
-	fakeWeeklyTotal := domain.WeeklyTransactionTotal{Value: fakeDaily.DailyTotal}
-	day := transaction.Time.Format(domain.DateLayout)
-	suite.repo.On("GetDailyTransaction", transaction.CustomerID, day).Return(fakeDaily, nil).Once()
-	suite.repo.On("GetWeeklyTransaction", transaction.CustomerID, fakeWeeklyTransaction).Return(fakeWeeklyTotal, nil).Once()
-	suite.repo.On("AddTransaction").Return(nil).Once()
-	suite.repo.On("AddDailyTransaction", transaction.CustomerID, day).Return(nil).Once()
-	weeklyTotalExpected := domain.WeeklyTransactionTotal{Value: 5000}
-	suite.repo.On("AddWeeklyTransaction", transaction.CustomerID, fakeWeeklyTransaction, weeklyTotalExpected).Return(nil).Once()
+	fakeState := domain.CustomerState{
+		Daily:  domain.DailyTransaction{DailyTotal: domain.NewMoney(250000), TransactionCount: 1},
+		Weekly: domain.WeeklyTransactionTotal{Value: domain.NewMoney(250000)},
+	}
+	stateExpected := domain.CustomerState{
+		Daily:   domain.DailyTransaction{DailyTotal: domain.NewMoney(500000), TransactionCount: 2},
+		Weekly:  domain.WeeklyTransactionTotal{Value: domain.NewMoney(500000)},
+		Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(250000)},
+		Yearly:  domain.YearlyTransactionTotal{Value: domain.NewMoney(250000)},
+	}
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(fakeState, nil).Once()
+	suite.repo.On("CommitCustomerState", transaction.CustomerID, applied(stateExpected)).Once()
 	h.Transaction(fund)
 	record := <-chOut
 	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}"
 	assert.Equal(t, string(record), msgExpected)
+	suite.repo.AssertExpectations(t)
 }
 
 func TestTransactionShouldReceiveValidDailyAmountTwice(t *testing.T) {
 	suite := newSuite()
-	chOut := make(chan []byte, 1)
-	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	chOut := make(chan []byte, 2)
+	chErr := make(chan []byte, 2)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	transaction, fund := fakeTransaction(t, "2500.00")
-	fakeDaily := domain.DailyTransaction{DailyTotal: 2500.00}
-	year, week := transaction.Time.ISOWeek()
-	fakeWeeklyTransaction := domain.WeeklyTransaction{Year: year, Week: week}
-	fakeWeeklyTotal := domain.WeeklyTransactionTotal{Value: fakeDaily.DailyTotal}
-	day := transaction.Time.Format(domain.DateLayout)
-	suite.repo.On("GetDailyTransaction", transaction.CustomerID, day).Return(fakeDaily, nil).Once()
-	suite.repo.On("GetWeeklyTransaction", transaction.CustomerID, fakeWeeklyTransaction).Return(fakeWeeklyTotal, nil).Once()
-	suite.repo.On("AddTransaction").Return(nil).Once()
-	suite.repo.On("AddDailyTransaction", transaction.CustomerID, day).Return(nil).Once()
-	weeklyTotalExpected := domain.WeeklyTransactionTotal{Value: 5000}
-	suite.repo.On("AddWeeklyTransaction", transaction.CustomerID, fakeWeeklyTransaction, weeklyTotalExpected).Return(nil).Once()
+	firstState := domain.CustomerState{
+		Daily:   domain.DailyTransaction{DailyTotal: domain.NewMoney(250000), TransactionCount: 1},
+		Weekly:  domain.WeeklyTransactionTotal{Value: domain.NewMoney(250000)},
+		Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(250000)},
+		Yearly:  domain.YearlyTransactionTotal{Value: domain.NewMoney(250000)},
+	}
+	suite.repo.On("AddTransaction").Return(nil).Twice()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(domain.CustomerState{}, nil).Once()
+	suite.repo.On("CommitCustomerState", transaction.CustomerID, applied(firstState)).Once()
+	h.Transaction(fund)
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(firstState, nil).Once()
+	stateExpected := domain.CustomerState{
+		Daily:   domain.DailyTransaction{DailyTotal: domain.NewMoney(500000), TransactionCount: 2},
+		Weekly:  domain.WeeklyTransactionTotal{Value: domain.NewMoney(500000)},
+		Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(500000)},
+		Yearly:  domain.YearlyTransactionTotal{Value: domain.NewMoney(500000)},
+	}
+	suite.repo.On("CommitCustomerState", transaction.CustomerID, applied(stateExpected)).Once()
 	h.Transaction(fund)
-	record := <-chOut
 	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}"
-	assert.Equal(t, string(record), msgExpected)
+	assert.Equal(t, msgExpected, string(<-chOut))
+	assert.Equal(t, msgExpected, string(<-chOut))
+	suite.repo.AssertExpectations(t)
 }
 
 func TestTransactionShouldReceiveInvalidDailyTransactionCount(t *testing.T) {
 	suite := newSuite()
 	chOut := make(chan []byte, 1)
 	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	transaction, fund := fakeTransaction(t, "2")
-	fakeDaily := domain.DailyTransaction{TransactionCount: 3}
+	fakeState := domain.CustomerState{Daily: domain.DailyTransaction{TransactionCount: 3}}
 	suite.repo.On("AddTransaction").Return(nil).Once()
-	suite.repo.On("GetDailyTransaction", transaction.CustomerID, transaction.Time.Format(domain.DateLayout)).Return(fakeDaily, nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(fakeState, nil).Once()
 	h.Transaction(fund)
 	record := <-chOut
-	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false}"
+	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"DAILY_COUNT_EXCEEDED\",\"message\":\"maximum number of loads per day exceeded\"}"
 	assert.Equal(t, string(record), msgExpected)
+	suite.repo.AssertExpectations(t)
 }
 
 func TestTransactionShouldReceiveValidDailyTransactionCount(t *testing.T) {
 	suite := newSuite()
 	chOut := make(chan []byte, 1)
 	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	transaction, fund := fakeTransaction(t, "2500.00")
-	fakeDaily := domain.DailyTransaction{TransactionCount: 2}
-	year, week := transaction.Time.ISOWeek()
-	fakeWeeklyTransaction := domain.WeeklyTransaction{Year: year, Week: week}
-	fakeWeeklyTotal := domain.WeeklyTransactionTotal{Value: 0}
-	day := transaction.Time.Format(domain.DateLayout)
-	suite.repo.On("GetDailyTransaction", transaction.CustomerID, day).Return(fakeDaily, nil).Once()
-	suite.repo.On("GetWeeklyTransaction", transaction.CustomerID, fakeWeeklyTransaction).Return(fakeWeeklyTotal, nil).Once()
-	suite.repo.On("AddTransaction").Return(nil).Once()
-	suite.repo.On("AddDailyTransaction", transaction.CustomerID, day).Return(nil).Once()
-	weeklyTotalExpected := domain.WeeklyTransactionTotal{Value: 2500}
-	suite.repo.On("AddWeeklyTransaction", transaction.CustomerID, fakeWeeklyTransaction, weeklyTotalExpected).Return(nil).Once()
+	fakeState := domain.CustomerState{Daily: domain.DailyTransaction{TransactionCount: 2}}
+	stateExpected := domain.CustomerState{
+		Daily:   domain.DailyTransaction{DailyTotal: domain.NewMoney(250000), TransactionCount: 3},
+		Weekly:  domain.WeeklyTransactionTotal{Value: domain.NewMoney(250000)},
+		Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(250000)},
+		Yearly:  domain.YearlyTransactionTotal{Value: domain.NewMoney(250000)},
+	}
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(fakeState, nil).Once()
+	suite.repo.On("CommitCustomerState", transaction.CustomerID, applied(stateExpected)).Once()
 	h.Transaction(fund)
 	record := <-chOut
 	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}"
 	assert.Equal(t, string(record), msgExpected)
+	suite.repo.AssertExpectations(t)
 }
 
 func TestTransactionShouldReceiveInvalidWeeklyAmount(t *testing.T) {
 	suite := newSuite()
 	chOut := make(chan []byte, 1)
 	chErr := make(chan []byte, 1)
-	h := handler.New(suite.repo, chOut, chErr)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
 	transaction, fund := fakeTransaction(t, "2500.00")
-	fakeDaily := domain.DailyTransaction{TransactionCount: 2}
-	year, week := transaction.Time.ISOWeek()
-	fakeWeeklyTransaction := domain.WeeklyTransaction{Year: year, Week: week}
-	fakeWeeklyTotal := domain.WeeklyTransactionTotal{Value: 17501}
-	day := transaction.Time.Format(domain.DateLayout)
+	fakeState := domain.CustomerState{
+		Daily:  domain.DailyTransaction{TransactionCount: 2},
+		Weekly: domain.WeeklyTransactionTotal{Value: domain.NewMoney(1750100)},
+	}
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(fakeState, nil).Once()
+	h.Transaction(fund)
+	record := <-chOut
+	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"WEEKLY_AMOUNT_EXCEEDED\",\"message\":\"maximum amount loaded per week exceeded\"}"
+	assert.Equal(t, string(record), msgExpected)
+	suite.repo.AssertExpectations(t)
+}
+
+func TestTransactionShouldUseConfiguredLimits(t *testing.T) {
+	suite := newSuite()
+	chOut := make(chan []byte, 1)
+	chErr := make(chan []byte, 1)
+	limits := handler.DefaultLimits()
+	limits.DailyAmount = domain.NewMoney(100000)
+	h := handler.New(suite.repo, limits, chOut, chErr)
+	transaction, fund := fakeTransaction(t, "1000.01")
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(domain.CustomerState{}, nil).Once()
+	h.Transaction(fund)
+	record := <-chOut
+	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"DAILY_AMOUNT_EXCEEDED\",\"message\":\"maximum amount loaded per day exceeded\"}"
+	assert.Equal(t, string(record), msgExpected)
+}
+
+func TestTransactionShouldUseCustomerTierAndOverride(t *testing.T) {
+	suite := newSuite()
+	chOut := make(chan []byte, 1)
+	chErr := make(chan []byte, 1)
+	tierDaily := domain.NewMoney(1000000)
+	tiers := map[domain.Tier]domain.LimitOverride{domain.TierPremium: {DailyAmount: &tierDaily}}
+	customerWeekly := domain.NewMoney(5000000)
+	profile := domain.CustomerProfile{CustomerID: "321", Tier: domain.TierPremium, Override: domain.LimitOverride{WeeklyAmount: &customerWeekly}}
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr, handler.WithCustomerLimits(suite.profiles, tiers))
+	transaction, fund := fakeTransaction(t, "6000.00")
+	fakeState := domain.CustomerState{Weekly: domain.WeeklyTransactionTotal{Value: domain.NewMoney(1900000)}}
+	stateExpected := domain.CustomerState{
+		Daily:   domain.DailyTransaction{DailyTotal: domain.NewMoney(600000), TransactionCount: 1},
+		Weekly:  domain.WeeklyTransactionTotal{Value: domain.NewMoney(2500000)},
+		Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(600000)},
+		Yearly:  domain.YearlyTransactionTotal{Value: domain.NewMoney(600000)},
+	}
+	suite.profiles.On("GetCustomerProfile", transaction.CustomerID).Return(profile, nil).Once()
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(fakeState, nil).Once()
+	suite.repo.On("CommitCustomerState", transaction.CustomerID, applied(stateExpected)).Once()
+	h.Transaction(fund)
+	record := <-chOut
+	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}"
+	assert.Equal(t, string(record), msgExpected)
+	suite.repo.AssertExpectations(t)
+}
+
+func TestTransactionShouldUseBaseLimitsWithoutProfile(t *testing.T) {
+	suite := newSuite()
+	chOut := make(chan []byte, 1)
+	chErr := make(chan []byte, 1)
+	tierDaily := domain.NewMoney(1000000)
+	tiers := map[domain.Tier]domain.LimitOverride{domain.TierPremium: {DailyAmount: &tierDaily}}
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr, handler.WithCustomerLimits(suite.profiles, tiers))
+	transaction, fund := fakeTransaction(t, "6000.00")
+	suite.profiles.On("GetCustomerProfile", transaction.CustomerID).Return(domain.CustomerProfile{}, domain.ErrNotFound).Once()
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(domain.CustomerState{}, nil).Once()
+	h.Transaction(fund)
+	record := <-chOut
+	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"DAILY_AMOUNT_EXCEEDED\",\"message\":\"maximum amount loaded per day exceeded\"}"
+	assert.Equal(t, string(record), msgExpected)
+}
+
+func TestTransactionShouldReceiveCustomerProfileError(t *testing.T) {
+	suite := newSuite()
+	chOut := make(chan []byte, 1)
+	chErr := make(chan []byte, 1)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr, handler.WithCustomerLimits(suite.profiles, nil))
+	transaction, fund := fakeTransaction(t, "100")
+	suite.profiles.On("GetCustomerProfile", transaction.CustomerID).Return(domain.CustomerProfile{}, errors.New("some error")).Once()
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	h.Transaction(fund)
+	record := <-chErr
+	event := decodeErrorEvent(t, record)
+	assert.Equal(t, domain.StageLimits, event.Stage)
+	assert.Equal(t, domain.ErrorKindStorage, event.Kind)
+	assert.Equal(t, "some error", event.Error)
+}
+
+func TestTransactionShouldComputeWindowsInTimezone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.Nil(t, err)
+	testCases := []struct {
+		name            string
+		time            string
+		location        *time.Location
+		timezone        string
+		windowsExpected domain.Windows
+	}{
+		{
+			name:            "offset of the timestamp is ignored",
+			time:            "2021-01-01T02:00:00+05:00",
+			windowsExpected: domain.Windows{Day: "2020-12-31", Week: domain.WeeklyTransaction{Year: 2020, Week: 53}, Month: domain.MonthlyTransaction{Year: 2020, Month: time.December}, Year: domain.YearlyTransaction{Year: 2020}},
+		},
+		{
+			name:            "before the start of daylight saving time",
+			time:            "2021-03-14T06:30:00Z",
+			location:        newYork,
+			windowsExpected: domain.Windows{Day: "2021-03-14", Week: domain.WeeklyTransaction{Year: 2021, Week: 10}, Month: domain.MonthlyTransaction{Year: 2021, Month: time.March}, Year: domain.YearlyTransaction{Year: 2021}},
+		},
+		{
+			name:            "last hour of the day daylight saving time starts",
+			time:            "2021-03-15T03:30:00Z",
+			location:        newYork,
+			windowsExpected: domain.Windows{Day: "2021-03-14", Week: domain.WeeklyTransaction{Year: 2021, Week: 10}, Month: domain.MonthlyTransaction{Year: 2021, Month: time.March}, Year: domain.YearlyTransaction{Year: 2021}},
+		},
+		{
+			name:            "repeated hour at the end of daylight saving time",
+			time:            "2021-11-07T06:30:00Z",
+			location:        newYork,
+			windowsExpected: domain.Windows{Day: "2021-11-07", Week: domain.WeeklyTransaction{Year: 2021, Week: 44}, Month: domain.MonthlyTransaction{Year: 2021, Month: time.November}, Year: domain.YearlyTransaction{Year: 2021}},
+		},
+		{
+			name:            "last hour of the day daylight saving time ends",
+			time:            "2021-11-08T04:30:00Z",
+			location:        newYork,
+			windowsExpected: domain.Windows{Day: "2021-11-07", Week: domain.WeeklyTransaction{Year: 2021, Week: 44}, Month: domain.MonthlyTransaction{Year: 2021, Month: time.November}, Year: domain.YearlyTransaction{Year: 2021}},
+		},
+		{
+			name:            "new year in the first ISO week of the next year in UTC",
+			time:            "2024-12-30T03:00:00Z",
+			windowsExpected: domain.Windows{Day: "2024-12-30", Week: domain.WeeklyTransaction{Year: 2025, Week: 1}, Month: domain.MonthlyTransaction{Year: 2024, Month: time.December}, Year: domain.YearlyTransaction{Year: 2024}},
+		},
+		{
+			name:            "new year still in the last ISO week of the year in the business timezone",
+			time:            "2024-12-30T03:00:00Z",
+			location:        newYork,
+			windowsExpected: domain.Windows{Day: "2024-12-29", Week: domain.WeeklyTransaction{Year: 2024, Week: 52}, Month: domain.MonthlyTransaction{Year: 2024, Month: time.December}, Year: domain.YearlyTransaction{Year: 2024}},
+		},
+		{
+			name:            "customer timezone over the business timezone",
+			time:            "2020-12-31T16:00:00Z",
+			location:        newYork,
+			timezone:        "Asia/Tokyo",
+			windowsExpected: domain.Windows{Day: "2021-01-01", Week: domain.WeeklyTransaction{Year: 2020, Week: 53}, Month: domain.MonthlyTransaction{Year: 2021, Month: time.January}, Year: domain.YearlyTransaction{Year: 2021}},
+		},
+		{
+			name:            "first ISO week in the customer timezone",
+			time:            "2021-01-03T15:30:00Z",
+			timezone:        "Asia/Tokyo",
+			windowsExpected: domain.Windows{Day: "2021-01-04", Week: domain.WeeklyTransaction{Year: 2021, Week: 1}, Month: domain.MonthlyTransaction{Year: 2021, Month: time.January}, Year: domain.YearlyTransaction{Year: 2021}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			opts := []handler.Option{handler.WithCustomerLimits(suite.profiles, nil)}
+			if tc.location != nil {
+				opts = append(opts, handler.WithLocation(tc.location))
+			}
+			h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil, opts...)
+			fund := []byte(`{"id":"123","customer_id":"321","load_amount":"$100.00","time":"` + tc.time + `"}`)
+			profile := domain.CustomerProfile{CustomerID: "321", Timezone: tc.timezone}
+			suite.profiles.On("GetCustomerProfile", "321").Return(profile, nil).Once()
+			suite.repo.On("AddTransaction").Return(nil).Once()
+			tc.windowsExpected.TransactionID = "123"
+			suite.repo.On("UpdateCustomerState", "321", tc.windowsExpected).Return(domain.CustomerState{}, nil).Once()
+			suite.repo.On("CommitCustomerState", "321", mock.Anything).Once()
+			result := h.Process(fund)
+			assert.Nil(t, result.Err)
+			suite.repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestTransactionShouldRecordLoadHistoryForRollingLimits(t *testing.T) {
+	suite := newSuite()
+	limits := handler.DefaultLimits()
+	limits.Modes = handler.Modes{DailyAmount: handler.WindowRolling, WeeklyAmount: handler.WindowRolling}
+	h := handler.New(suite.repo, limits, nil, nil)
+	transaction, _ := fakeTransaction(t, "100")
+	transaction.Time = time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	fund, err := json.Marshal(transaction)
+	assert.Nil(t, err)
+	windows := fakeWindows(transaction)
+	windows.Since = transaction.Time.Add(-handler.RollingWeek)
+	earlier := domain.LoadEvent{ID: "122", Time: transaction.Time.Add(-time.Hour), Amount: domain.NewMoney(490000)}
+	state := domain.CustomerState{Recent: []domain.LoadEvent{earlier}}
+	stateExpected := domain.CustomerState{
+		Daily:   domain.DailyTransaction{TransactionCount: 1, DailyTotal: transaction.LoadAmount},
+		Weekly:  domain.WeeklyTransactionTotal{Value: transaction.LoadAmount},
+		Monthly: domain.MonthlyTransactionTotal{Value: transaction.LoadAmount},
+		Yearly:  domain.YearlyTransactionTotal{Value: transaction.LoadAmount},
+		Recent:  []domain.LoadEvent{earlier, {ID: "123", Time: transaction.Time, Amount: transaction.LoadAmount}},
+	}
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, windows).Return(state, nil).Once()
+	suite.repo.On("CommitCustomerState", transaction.CustomerID, applied(stateExpected)).Once()
+	result := h.Process(fund)
+	assert.Equal(t, "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}", string(result.Event))
+	suite.repo.AssertExpectations(t)
+
+	transaction.ID = "124"
+	transaction.LoadAmount = domain.NewMoney(10000)
+	fund, err = json.Marshal(transaction)
+	assert.Nil(t, err)
+	windows.TransactionID = transaction.ID
 	suite.repo.On("AddTransaction").Return(nil).Once()
-	suite.repo.On("GetDailyTransaction", transaction.CustomerID, day).Return(fakeDaily, nil).Once()
-	suite.repo.On("GetWeeklyTransaction", transaction.CustomerID, fakeWeeklyTransaction).Return(fakeWeeklyTotal, nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, windows).Return(stateExpected, nil).Once()
+	result = h.Process(fund)
+	assert.Equal(t, domain.ReasonDailyAmountExceeded, result.Reason)
+}
+
+func TestTransactionShouldKeepLegacyResponseFormat(t *testing.T) {
+	suite := newSuite()
+	chOut := make(chan []byte, 1)
+	chErr := make(chan []byte, 1)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr, handler.WithResponseFormat(handler.ResponseLegacy))
+	transaction, fund := fakeTransaction(t, "2")
+	fakeState := domain.CustomerState{Daily: domain.DailyTransaction{TransactionCount: 3}}
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(fakeState, nil).Once()
 	h.Transaction(fund)
 	record := <-chOut
 	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false}"
 	assert.Equal(t, string(record), msgExpected)
 }
+
+func TestTransactionShouldAnswerDuplicateID(t *testing.T) {
+	transaction, fund := fakeTransaction(t, "100")
+	other := transaction
+	other.LoadAmount = domain.NewMoney(20000)
+	testCases := []struct {
+		name             string
+		stored           domain.Transaction
+		decision         domain.TransactionDecision
+		decisionErr      error
+		msgExpected      string
+		replayedExpected bool
+	}{
+		{
+			name:             "replay accepted",
+			stored:           transaction,
+			decision:         domain.TransactionDecision{Accepted: true},
+			msgExpected:      "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}",
+			replayedExpected: true,
+		},
+		{
+			name:             "replay rejected",
+			stored:           transaction,
+			decision:         domain.TransactionDecision{Reason: domain.ReasonDailyCountExceeded},
+			msgExpected:      "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"DAILY_COUNT_EXCEEDED\",\"message\":\"maximum number of loads per day exceeded\"}",
+			replayedExpected: true,
+		},
+		{
+			name:        "conflict",
+			stored:      other,
+			msgExpected: "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"ID_CONFLICT\",\"message\":\"transaction ID already used with a different payload\"}",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+			suite.repo.On("AddTransaction").Return(domain.ErrTransactionAlreadyExist).Once()
+			suite.repo.On("GetTransaction", transaction.CustomerID, transaction.ID).Return(tc.stored, nil).Once()
+			suite.repo.On("GetDecision", transaction.CustomerID, transaction.ID).Return(tc.decision, tc.decisionErr).Maybe()
+			result := h.Process(fund)
+			assert.Equal(t, tc.msgExpected, string(result.Event))
+			assert.Equal(t, tc.replayedExpected, result.Replayed)
+			suite.repo.AssertNotCalled(t, "UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction))
+		})
+	}
+}
+
+func TestTransactionShouldEvaluateUndecidedDuplicateOnce(t *testing.T) {
+	transaction, fund := fakeTransaction(t, "100")
+	delta := domain.StateDelta{
+		DailyAmount:   transaction.LoadAmount,
+		DailyCount:    1,
+		WeeklyAmount:  transaction.LoadAmount,
+		MonthlyAmount: transaction.LoadAmount,
+		YearlyAmount:  transaction.LoadAmount,
+	}
+	testCases := []struct {
+		name   string
+		state  domain.CustomerState
+		commit bool
+	}{
+		{
+			name:   "not counted",
+			commit: true,
+		},
+		{
+			name:  "already counted",
+			state: applied(domain.CustomerState{}.Apply(delta)),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+			suite.repo.On("AddTransaction").Return(domain.ErrTransactionAlreadyExist).Once()
+			suite.repo.On("GetTransaction", transaction.CustomerID, transaction.ID).Return(transaction, nil).Once()
+			suite.repo.On("GetDecision", transaction.CustomerID, transaction.ID).Return(domain.TransactionDecision{}, domain.ErrNotFound).Once()
+			suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(tc.state, nil).Once()
+			if tc.commit {
+				suite.repo.On("CommitCustomerState", transaction.CustomerID, applied(domain.CustomerState{}.Apply(delta))).Once()
+			}
+			result := h.Process(fund)
+			assert.Equal(t, "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}", string(result.Event))
+			assert.False(t, result.Replayed)
+			suite.repo.AssertExpectations(t)
+			suite.repo.AssertCalled(t, "SetDecision", transaction.CustomerID, transaction.ID, domain.TransactionDecision{Accepted: true})
+		})
+	}
+}
+
+func TestTransactionShouldSaveDecision(t *testing.T) {
+	suite := &handlerTest{repo: &storage.StorageMock{}}
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+	transaction, fund := fakeTransaction(t, "100")
+	state := domain.CustomerState{Daily: domain.DailyTransaction{TransactionCount: 3}}
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(state, nil).Once()
+	decision := domain.TransactionDecision{Reason: domain.ReasonDailyCountExceeded}
+	suite.repo.On("SetDecision", transaction.CustomerID, transaction.ID, decision).Return(errors.New("some error")).Once()
+	result := h.Process(fund)
+	event := decodeErrorEvent(t, result.Err)
+	assert.Equal(t, domain.StageDecision, event.Stage)
+	assert.Equal(t, "error to save decision", event.Message)
+	suite.repo.AssertExpectations(t)
+}
+
+func TestTransactionShouldReportDuplicateIDAsErrorInLegacyFormat(t *testing.T) {
+	suite := newSuite()
+	chOut := make(chan []byte, 1)
+	chErr := make(chan []byte, 1)
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr, handler.WithResponseFormat(handler.ResponseLegacy))
+	transaction, fund := fakeTransaction(t, "100")
+	suite.repo.On("AddTransaction").Return(domain.ErrTransactionAlreadyExist).Once()
+	suite.repo.On("GetTransaction", transaction.CustomerID, transaction.ID).Return(transaction, nil).Once()
+	suite.repo.On("GetDecision", transaction.CustomerID, transaction.ID).Return(domain.TransactionDecision{Accepted: true}, nil).Once()
+	h.Transaction(fund)
+	record := <-chErr
+	errExpected := domain.ErrorEvent{
+		TransactionID: "123",
+		CustomerID:    "321",
+		Stage:         domain.StageRecord,
+		Kind:          domain.ErrorKindDuplicate,
+		Message:       "error to add transaction",
+		Error:         "transaction ID already exist",
+		Input:         string(fund),
+	}
+	assert.Equal(t, errExpected, decodeErrorEvent(t, record))
+}
+
+func TestTransactionShouldRejectMalformedInput(t *testing.T) {
+	testCases := []struct {
+		name string
+		fund string
+	}{
+		{name: "invalid amount", fund: `{"id":"123","customer_id":"321","load_amount":"$1.001","time":"2000-01-01T00:00:00Z"}`},
+		{name: "negative amount", fund: `{"id":"123","customer_id":"321","load_amount":"-$1.00","time":"2000-01-01T00:00:00Z"}`},
+		{name: "invalid time", fund: `{"id":"123","customer_id":"321","load_amount":"$1.00","time":"yesterday"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			chOut := make(chan []byte, 1)
+			chErr := make(chan []byte, 1)
+			h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr)
+			h.Transaction([]byte(tc.fund))
+			record := <-chOut
+			msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"MALFORMED_INPUT\",\"message\":\"transaction is malformed\"}"
+			assert.Equal(t, string(record), msgExpected)
+			event := decodeErrorEvent(t, <-chErr)
+			assert.Equal(t, "123", event.TransactionID)
+			assert.Equal(t, domain.ErrorKindMalformed, event.Kind)
+			assert.Equal(t, tc.fund, event.Input)
+			assert.NotEmpty(t, event.Error)
+		})
+	}
+}
+
+func TestTransactionShouldEvaluateCustomRules(t *testing.T) {
+	suite := newSuite()
+	chOut := make(chan []byte, 1)
+	chErr := make(chan []byte, 1)
+	minimum := domain.NewMoney(1000)
+	minimumRule := handler.RuleFunc(func(transaction domain.Transaction, state domain.CustomerState, limits handler.Limits) handler.Decision {
+		if minimum.GreaterThan(transaction.LoadAmount) {
+			return handler.Deny("MINIMUM_AMOUNT")
+		}
+		return handler.Allow(domain.StateDelta{})
+	})
+	h := handler.New(suite.repo, handler.DefaultLimits(), chOut, chErr, handler.WithRules(minimumRule, handler.DailyCountRule{}))
+	transaction, fund := fakeTransaction(t, "9.99")
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(domain.CustomerState{}, nil).Once()
+	h.Transaction(fund)
+	record := <-chOut
+	msgExpected := "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":false,\"reason\":\"MINIMUM_AMOUNT\"}"
+	assert.Equal(t, string(record), msgExpected)
+	suite.repo.AssertExpectations(t)
+}
+
+func TestProcessShouldReturnReason(t *testing.T) {
+	suite := newSuite()
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil, handler.WithResponseFormat(handler.ResponseLegacy))
+	result := h.Process([]byte("with error"))
+	assert.Nil(t, result.Event)
+	assert.Equal(t, domain.ReasonMalformedInput, result.Reason)
+
+	transaction, fund := fakeTransaction(t, "100")
+	suite.repo.On("AddTransaction").Return(domain.ErrTransactionAlreadyExist).Once()
+	suite.repo.On("GetTransaction", transaction.CustomerID, transaction.ID).Return(transaction, nil).Once()
+	suite.repo.On("GetDecision", transaction.CustomerID, transaction.ID).Return(domain.TransactionDecision{Accepted: true}, nil).Once()
+	result = h.Process(fund)
+	assert.Nil(t, result.Event)
+	assert.Equal(t, domain.ReasonDuplicateID, result.Reason)
+
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(domain.CustomerState{}, errors.New("some error")).Once()
+	result = h.Process(fund)
+	assert.Nil(t, result.Event)
+	assert.Equal(t, domain.Reason(""), result.Reason)
+	assert.Equal(t, domain.ErrorKindStorage, decodeErrorEvent(t, result.Err).Kind)
+}
+
+func TestCheckShouldNotRecordTransaction(t *testing.T) {
+	suite := newSuite()
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+	transaction, fund := fakeTransaction(t, "100")
+	suite.repo.On("GetTransaction", transaction.CustomerID, transaction.ID).Return(domain.Transaction{}, domain.ErrNotFound).Once()
+	suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(domain.CustomerState{}, nil).Once()
+	result := h.Check(fund)
+	assert.Equal(t, "{\"id\":\"123\",\"customer_id\":\"321\",\"accepted\":true}", string(result.Event))
+	suite.repo.AssertExpectations(t)
+	suite.repo.AssertNotCalled(t, "AddTransaction")
+	suite.repo.AssertNotCalled(t, "CommitCustomerState", transaction.CustomerID, domain.CustomerState{})
+	suite.repo.AssertNotCalled(t, "SetDecision", transaction.CustomerID, transaction.ID, domain.TransactionDecision{Accepted: true})
+}
+
+func TestCheckShouldReturnDecision(t *testing.T) {
+	testCases := []struct {
+		name           string
+		getErr         error
+		state          domain.CustomerState
+		reasonExpected domain.Reason
+		errExpected    domain.ErrorStage
+	}{
+		{
+			name:           "duplicate",
+			reasonExpected: domain.ReasonIDConflict,
+		},
+		{
+			name:           "limit exceeded",
+			getErr:         domain.ErrNotFound,
+			state:          domain.CustomerState{Daily: domain.DailyTransaction{TransactionCount: 3}},
+			reasonExpected: domain.ReasonDailyCountExceeded,
+		},
+		{
+			name:        "storage error",
+			getErr:      errors.New("some error"),
+			errExpected: domain.StageRecord,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+			transaction, fund := fakeTransaction(t, "100")
+			suite.repo.On("GetTransaction", transaction.CustomerID, transaction.ID).Return(domain.Transaction{}, tc.getErr)
+			suite.repo.On("UpdateCustomerState", transaction.CustomerID, fakeWindows(transaction)).Return(tc.state, nil).Maybe()
+			result := h.Check(fund)
+			assert.Equal(t, tc.reasonExpected, result.Reason)
+			if tc.errExpected != "" {
+				assert.Equal(t, tc.errExpected, decodeErrorEvent(t, result.Err).Stage)
+			} else {
+				assert.Nil(t, result.Err)
+			}
+			suite.repo.AssertNotCalled(t, "AddTransaction")
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+)
+
+// LedgerQuery answers the funds a customer holds and how they got there.
+type LedgerQuery interface {
+	Balance(customerID string) (domain.Balance, error)
+	Statement(customerID string, from, to time.Time) (domain.Statement, error)
+}
+
+// Balance returns the funds the customer holds in the ledger.
+func (hs *HandlerTransactionService) Balance(customerID string) (domain.Balance, error) {
+	statement, err := hs.Statement(customerID, time.Time{}, time.Time{})
+	if err != nil {
+		return domain.Balance{}, err
+	}
+	return domain.Balance{CustomerID: customerID, Balance: statement.ClosingBalance}, nil
+}
+
+// Statement returns the entries of the customer account posted between from
+// and to, inclusive, oldest first. A zero from or to leaves that end open.
+func (hs *HandlerTransactionService) Statement(customerID string, from, to time.Time) (domain.Statement, error) {
+	if customerID == "" {
+		return domain.Statement{}, domain.ErrCustomerEmptyID
+	}
+	if hs.ledger == nil {
+		return domain.Statement{}, domain.ErrLedgerDisabled
+	}
+	entries, err := hs.ledger.ListEntries(customerID)
+	if err != nil {
+		return domain.Statement{}, err
+	}
+	statement := domain.Statement{
+		CustomerID:     customerID,
+		OpeningBalance: domain.NewMoney(0),
+		Lines:          []domain.StatementLine{},
+	}
+	if !from.IsZero() {
+		statement.From = &from
+	}
+	if !to.IsZero() {
+		statement.To = &to
+	}
+	account := domain.CustomerAccount(customerID)
+	balance := statement.OpeningBalance
+	for _, entry := range entries {
+		if entry.Account != account {
+			continue
+		}
+		if !to.IsZero() && entry.Time.After(to) {
+			break
+		}
+		// The customer account holds what it was credited.
+		balance = balance.Sub(entry.Signed())
+		if !from.IsZero() && entry.Time.Before(from) {
+			statement.OpeningBalance = balance
+			continue
+		}
+		statement.Lines = append(statement.Lines, domain.StatementLine{
+			TransactionID: entry.TransactionID,
+			Time:          entry.Time,
+			Side:          entry.Side,
+			Amount:        entry.Amount,
+			Balance:       balance,
+		})
+	}
+	statement.ClosingBalance = balance
+	return statement, nil
+}
@@ -0,0 +1,444 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/danielfmelo/load-funds-handler/storage"
+	"github.com/danielfmelo/load-funds-handler/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTransactionShouldPostAcceptedLoad(t *testing.T) {
+	testCases := []struct {
+		name        string
+		state       domain.CustomerState
+		postErr     error
+		post        bool
+		errExpected bool
+	}{
+		{
+			name: "accepted load",
+			post: true,
+		},
+		{
+			name:  "rejected load",
+			state: domain.CustomerState{Daily: domain.DailyTransaction{TransactionCount: 3}},
+		},
+		{
+			name:        "ledger error",
+			postErr:     errors.New("some error"),
+			post:        true,
+			errExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := &handlerTest{repo: &storage.StorageMock{}}
+			ledger := &storage.LedgerMock{}
+			h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil, handler.WithLedger(ledger))
+			transaction, fund := fakeTransaction(t, "100.00")
+			suite.repo.On("AddTransaction").Return(nil).Once()
+			suite.repo.On("UpdateCustomerState", "321", fakeWindows(transaction)).Return(tc.state, nil).Once()
+			suite.repo.On("CommitCustomerState", "321", mock.Anything).Maybe()
+			suite.repo.On("SetDecision", "321", "123", mock.Anything).Return(nil).Maybe()
+			ledger.On("PostEntries", "321", "123", mock.Anything).Return(tc.postErr).Maybe()
+			result := h.Process(fund)
+			if tc.errExpected {
+				assert.Equal(t, domain.StageLedger, decodeErrorEvent(t, result.Err).Stage)
+				suite.repo.AssertNotCalled(t, "SetDecision", "321", "123", mock.Anything)
+			} else {
+				assert.Nil(t, result.Err)
+			}
+			if tc.post {
+				posted := ledger.Calls[0].Arguments.Get(2).([]domain.LedgerEntry)
+				assert.True(t, domain.Balanced(posted))
+				assert.Equal(t, domain.CustomerAccount("321"), posted[1].Account)
+				assert.Equal(t, domain.Credit, posted[1].Side)
+				assert.Equal(t, transaction.LoadAmount, posted[1].Amount)
+			} else {
+				ledger.AssertNotCalled(t, "PostEntries", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func fakeDebit(t *testing.T, kind domain.TransactionType, amount int64) (domain.Transaction, []byte) {
+	debit := domain.Transaction{
+		ID:         "124",
+		CustomerID: "321",
+		LoadAmount: domain.NewMoney(amount),
+		Time:       time.Date(2000, 1, 4, 10, 0, 0, 0, time.UTC),
+		Type:       kind,
+	}
+	fund, err := json.Marshal(debit)
+	assert.Nil(t, err)
+	return debit, fund
+}
+
+func TestTransactionShouldDebitFundedTransaction(t *testing.T) {
+	load := domain.Transaction{ID: "123", CustomerID: "321", LoadAmount: domain.NewMoney(10000), Time: time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)}
+	withdrawalDaily := domain.NewMoney(2000)
+	testCases := []struct {
+		name           string
+		kind           domain.TransactionType
+		amount         int64
+		limits         handler.Limits
+		postErr        error
+		evaluate       bool
+		post           bool
+		acceptExpected bool
+		reasonExpected domain.Reason
+	}{
+		{
+			name:           "funded withdrawal",
+			kind:           domain.TransactionWithdrawal,
+			amount:         10000,
+			evaluate:       true,
+			post:           true,
+			acceptExpected: true,
+		},
+		{
+			name:           "funded spend",
+			kind:           domain.TransactionSpend,
+			amount:         5000,
+			evaluate:       true,
+			post:           true,
+			acceptExpected: true,
+		},
+		{
+			name:           "insufficient funds",
+			kind:           domain.TransactionSpend,
+			amount:         10001,
+			reasonExpected: domain.ReasonInsufficientFunds,
+		},
+		{
+			name:           "withdrawal limits",
+			kind:           domain.TransactionWithdrawal,
+			amount:         3000,
+			limits:         handler.DefaultLimits().WithOverride(domain.LimitOverride{Withdrawal: &domain.LimitOverride{DailyAmount: &withdrawalDaily}}),
+			evaluate:       true,
+			reasonExpected: domain.ReasonDailyAmountExceeded,
+		},
+		{
+			name:           "funds spent concurrently",
+			kind:           domain.TransactionWithdrawal,
+			amount:         5000,
+			postErr:        domain.ErrInsufficientFunds,
+			evaluate:       true,
+			post:           true,
+			reasonExpected: domain.ReasonInsufficientFunds,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			ledger := &storage.LedgerMock{}
+			limits := tc.limits
+			if limits == (handler.Limits{}) {
+				limits = handler.DefaultLimits()
+			}
+			h := handler.New(suite.repo, limits, nil, nil, handler.WithLedger(ledger))
+			debit, fund := fakeDebit(t, tc.kind, tc.amount)
+			windows := fakeWindows(debit)
+			windows.Kind = tc.kind
+			counted := applied(domain.CustomerState{}.Apply(domain.StateDelta{
+				DailyAmount:   debit.LoadAmount,
+				DailyCount:    1,
+				WeeklyAmount:  debit.LoadAmount,
+				MonthlyAmount: debit.LoadAmount,
+				YearlyAmount:  debit.LoadAmount,
+			}))
+			suite.repo.On("AddTransaction").Return(nil).Once()
+			ledger.On("Posted", "321", "124").Return(false, nil).Once()
+			ledger.On("ListEntries", "321").Return(domain.LoadEntries(load), nil).Once()
+			suite.repo.On("UpdateCustomerState", "321", windows).Return(domain.CustomerState{}, nil).Once()
+			suite.repo.On("UpdateCustomerState", "321", windows).Return(counted, nil).Maybe()
+			suite.repo.On("CommitCustomerState", "321", mock.Anything).Maybe()
+			ledger.On("PostFundedEntries", "321", "124", domain.Entries(debit)).Return(tc.postErr).Maybe()
+			result := h.Process(fund)
+			assert.Nil(t, result.Err)
+			var response domain.TransactionResponse
+			assert.Nil(t, json.Unmarshal(result.Event, &response))
+			assert.Equal(t, tc.acceptExpected, response.Accepted)
+			assert.Equal(t, tc.reasonExpected, response.Reason)
+			saved := domain.TransactionDecision{Accepted: tc.acceptExpected, Reason: tc.reasonExpected}
+			suite.repo.AssertCalled(t, "SetDecision", "321", "124", saved)
+			if !tc.evaluate {
+				suite.repo.AssertNotCalled(t, "UpdateCustomerState", "321", mock.Anything)
+			}
+			if tc.post {
+				ledger.AssertCalled(t, "PostFundedEntries", "321", "124", domain.Entries(debit))
+			} else {
+				ledger.AssertNotCalled(t, "PostFundedEntries", mock.Anything, mock.Anything, mock.Anything)
+			}
+			if tc.postErr != nil {
+				// The limits consumed are given back.
+				suite.repo.AssertNumberOfCalls(t, "CommitCustomerState", 2)
+				reverted := suite.repo.Calls[len(suite.repo.Calls)-2].Arguments.Get(1).(domain.CustomerState)
+				assert.Equal(t, 0, reverted.Daily.TransactionCount)
+				assert.True(t, reverted.Weekly.Value.IsZero())
+			}
+		})
+	}
+}
+
+func TestTransactionShouldRequireLedgerForDebit(t *testing.T) {
+	suite := newSuite()
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+	_, fund := fakeDebit(t, domain.TransactionWithdrawal, 100)
+	result := h.Process(fund)
+	event := decodeErrorEvent(t, result.Err)
+	assert.Equal(t, domain.StageLedger, event.Stage)
+	assert.Equal(t, domain.ErrLedgerDisabled.Error(), event.Error)
+	suite.repo.AssertNotCalled(t, "AddTransaction")
+}
+
+func TestTransactionShouldRejectAmountThatWouldWrap(t *testing.T) {
+	database := memory.New()
+	h := handler.New(database, handler.DefaultLimits(), nil, nil, handler.WithLedger(database))
+	at := "2000-01-03T10:00:00Z"
+	result := h.Process([]byte(`{"id":"1","customer_id":"321","load_amount":"$10.00","time":"` + at + `"}`))
+	assert.Nil(t, result.Err)
+	result = h.Process([]byte(`{"id":"2","customer_id":"321","load_amount":"$92233720368547757.99","time":"` + at + `"}`))
+	assert.Equal(t, domain.ReasonMalformedInput, result.Reason)
+	balance, err := h.Balance("321")
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(1000), balance.Balance)
+}
+
+// failingStorage fails once each to save the decisions of the transaction
+// IDs in decisions, to update the counters for the ones in states and to
+// post the funded entries of the ones in posts.
+type failingStorage struct {
+	*memory.Database
+	decisions map[string]bool
+	states    map[string]bool
+	posts     map[string]bool
+}
+
+func (d *failingStorage) PostFundedEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	if d.posts[transactionID] {
+		d.posts[transactionID] = false
+		return errors.New("some error")
+	}
+	return d.Database.PostFundedEntries(customerID, transactionID, entries)
+}
+
+func (d *failingStorage) SetDecision(customerID, id string, decision domain.TransactionDecision) error {
+	if d.decisions[id] {
+		d.decisions[id] = false
+		return errors.New("some error")
+	}
+	return d.Database.SetDecision(customerID, id, decision)
+}
+
+func (d *failingStorage) UpdateCustomerState(customerID string, windows domain.Windows, update storage.StateUpdate) error {
+	if d.states[windows.TransactionID] {
+		d.states[windows.TransactionID] = false
+		return errors.New("some error")
+	}
+	return d.Database.UpdateCustomerState(customerID, windows, update)
+}
+
+func TestTransactionShouldCountRetryOfUndecidedTransactionOnce(t *testing.T) {
+	database := &failingStorage{Database: memory.New(), decisions: map[string]bool{"1": true, "2": true}}
+	h := handler.New(database, handler.DefaultLimits(), nil, nil, handler.WithLedger(database))
+	at := "2000-01-03T10:00:00Z"
+	load := []byte(`{"id":"1","customer_id":"321","load_amount":"$100.00","time":"` + at + `"}`)
+	withdrawal := []byte(`{"id":"2","customer_id":"321","type":"withdrawal","amount":"$100.00","time":"` + at + `"}`)
+	for _, fund := range [][]byte{load, withdrawal} {
+		result := h.Process(fund)
+		assert.Equal(t, domain.StageDecision, decodeErrorEvent(t, result.Err).Stage)
+		result = h.Process(fund)
+		assert.Nil(t, result.Err)
+		assert.False(t, result.Replayed)
+		var response domain.TransactionResponse
+		assert.Nil(t, json.Unmarshal(result.Event, &response))
+		assert.True(t, response.Accepted)
+		result = h.Process(fund)
+		assert.True(t, result.Replayed)
+	}
+	daily, err := database.GetDailyTransaction("321", "2000-01-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, daily.TransactionCount)
+	assert.Equal(t, domain.NewMoney(10000), daily.DailyTotal)
+	var withdrawals domain.CustomerState
+	windows := domain.Windows{Day: "2000-01-03", Kind: domain.TransactionWithdrawal}
+	err = database.UpdateCustomerState("321", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		withdrawals = state
+		return state, false, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, withdrawals.Daily.TransactionCount)
+	balance, err := h.Balance("321")
+	assert.Nil(t, err)
+	assert.True(t, balance.Balance.IsZero())
+}
+
+func TestCheckShouldNotPostLoad(t *testing.T) {
+	suite := newSuite()
+	ledger := &storage.LedgerMock{}
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil, handler.WithLedger(ledger))
+	transaction, fund := fakeTransaction(t, "100.00")
+	suite.repo.On("GetTransaction", "321", "123").Return(domain.Transaction{}, domain.ErrNotFound).Once()
+	suite.repo.On("UpdateCustomerState", "321", fakeWindows(transaction)).Return(domain.CustomerState{}, nil).Once()
+	result := h.Check(fund)
+	assert.Nil(t, result.Err)
+	ledger.AssertNotCalled(t, "PostEntries", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestStatement(t *testing.T) {
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	first := domain.Transaction{ID: "1", CustomerID: "321", LoadAmount: domain.NewMoney(30000), Time: at}
+	second := domain.Transaction{ID: "2", CustomerID: "321", LoadAmount: domain.NewMoney(10000), Time: at.Add(24 * time.Hour)}
+	reversal := domain.Transaction{ID: "3", CustomerID: "321", Time: at.Add(48 * time.Hour), Type: domain.TransactionReversal, Reverses: "1"}
+	var entries []domain.LedgerEntry
+	entries = append(entries, domain.LoadEntries(first)...)
+	entries = append(entries, domain.LoadEntries(second)...)
+	entries = append(entries, domain.ReversalEntries(reversal, first)...)
+	line := func(transaction domain.Transaction, side domain.EntrySide, amount, balance int64) domain.StatementLine {
+		return domain.StatementLine{TransactionID: transaction.ID, Time: transaction.Time, Side: side, Amount: domain.NewMoney(amount), Balance: domain.NewMoney(balance)}
+	}
+	testCases := []struct {
+		name              string
+		from              time.Time
+		to                time.Time
+		statementExpected domain.Statement
+	}{
+		{
+			name: "whole ledger",
+			statementExpected: domain.Statement{
+				CustomerID:     "321",
+				OpeningBalance: domain.NewMoney(0),
+				Lines: []domain.StatementLine{
+					line(first, domain.Credit, 30000, 30000),
+					line(second, domain.Credit, 10000, 40000),
+					line(reversal, domain.Debit, 30000, 10000),
+				},
+				ClosingBalance: domain.NewMoney(10000),
+			},
+		},
+		{
+			name: "between times",
+			from: second.Time,
+			to:   second.Time,
+			statementExpected: domain.Statement{
+				CustomerID:     "321",
+				From:           &second.Time,
+				To:             &second.Time,
+				OpeningBalance: domain.NewMoney(30000),
+				Lines:          []domain.StatementLine{line(second, domain.Credit, 10000, 40000)},
+				ClosingBalance: domain.NewMoney(40000),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ledger := &storage.LedgerMock{}
+			h := handler.New(newSuite().repo, handler.DefaultLimits(), nil, nil, handler.WithLedger(ledger))
+			ledger.On("ListEntries", "321").Return(entries, nil).Once()
+			statement, err := h.Statement("321", tc.from, tc.to)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.statementExpected, statement)
+		})
+	}
+}
+
+func TestStatementWithoutLedger(t *testing.T) {
+	h := handler.New(newSuite().repo, handler.DefaultLimits(), nil, nil)
+	_, err := h.Balance("321")
+	assert.Equal(t, domain.ErrLedgerDisabled, err)
+}
+
+// TestLedgerShouldMatchAcceptedResponses processes random loads, withdrawals,
+// spends, reversals and retries, and checks the ledger sums to zero and holds
+// for each customer what the accepted responses add up to.
+func TestLedgerShouldMatchAcceptedResponses(t *testing.T) {
+	database := memory.New()
+	h := handler.New(database, handler.DefaultLimits(), nil, nil, handler.WithLedger(database))
+	random := rand.New(rand.NewSource(1))
+	start := time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)
+	customers := []string{"1", "2", "3", "4"}
+	var sent []domain.Transaction
+	amounts := make(map[string]domain.Money)
+	expected := make(map[string]domain.Money)
+	kinds := []domain.TransactionType{domain.TransactionLoad, domain.TransactionLoad, domain.TransactionWithdrawal, domain.TransactionSpend}
+	accepted, reversed, debited, unfunded := 0, 0, 0, 0
+	for i := 0; i < 500; i++ {
+		var transaction domain.Transaction
+		switch {
+		case len(sent) > 0 && random.Intn(10) == 0:
+			transaction = sent[random.Intn(len(sent))]
+		case len(sent) > 0 && random.Intn(5) == 0:
+			original := sent[random.Intn(len(sent))]
+			transaction = domain.Transaction{
+				ID:         fmt.Sprint(i),
+				CustomerID: original.CustomerID,
+				Time:       start.Add(time.Duration(i) * time.Hour),
+				Type:       domain.TransactionReversal,
+				Reverses:   original.ID,
+			}
+		default:
+			transaction = domain.Transaction{
+				ID:         fmt.Sprint(i),
+				CustomerID: customers[random.Intn(len(customers))],
+				LoadAmount: domain.NewMoney(int64(random.Intn(300000) + 1)),
+				Time:       start.Add(time.Duration(i) * time.Hour),
+				Type:       kinds[random.Intn(len(kinds))],
+			}
+		}
+		fund, err := json.Marshal(transaction)
+		assert.Nil(t, err)
+		result := h.Process(fund)
+		assert.Nil(t, result.Err)
+		sent = append(sent, transaction)
+		var response domain.TransactionResponse
+		assert.Nil(t, json.Unmarshal(result.Event, &response))
+		if response.Reason == domain.ReasonInsufficientFunds && !result.Replayed {
+			unfunded++
+		}
+		if !response.Accepted || result.Replayed {
+			continue
+		}
+		accepted++
+		amount := transaction.LoadAmount
+		if transaction.IsDebit() {
+			debited++
+			amount = domain.NewMoney(0).Sub(amount)
+		}
+		if transaction.IsReversal() {
+			reversed++
+			amount = domain.NewMoney(0).Sub(amounts[transaction.CustomerID+"/"+transaction.Reverses])
+		}
+		amounts[transaction.CustomerID+"/"+transaction.ID] = amount
+		expected[transaction.CustomerID] = expected[transaction.CustomerID].Add(amount)
+	}
+	assert.True(t, accepted > 100)
+	assert.True(t, reversed > 0)
+	assert.True(t, debited > 0)
+	assert.True(t, unfunded > 0)
+
+	balances, err := database.TrialBalance()
+	assert.Nil(t, err)
+	var sum domain.Money
+	for _, balance := range balances {
+		sum = sum.Add(balance.Net())
+	}
+	assert.True(t, sum.IsZero())
+	for _, customerID := range customers {
+		balance, err := h.Balance(customerID)
+		assert.Nil(t, err)
+		assert.True(t, expected[customerID].Equal(balance.Balance), "customer %s: %s != %s", customerID, expected[customerID], balance.Balance)
+	}
+}
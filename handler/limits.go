@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+)
+
+// Limits holds the velocity limits a load is checked against, and the
+// window each of them is measured in. The monthly and yearly limits are
+// optional: nil means loads are not capped per calendar month or year.
+// Withdrawal and Spend override the limits of those transactions, which are
+// otherwise checked against the same limits as loads, on counters of their
+// own.
+type Limits struct {
+	DailyAmount   domain.Money          `json:"daily_amount"`
+	DailyCount    int                   `json:"daily_count"`
+	WeeklyAmount  domain.Money          `json:"weekly_amount"`
+	MonthlyAmount *domain.Money         `json:"monthly_amount,omitempty"`
+	YearlyAmount  *domain.Money         `json:"yearly_amount,omitempty"`
+	Modes         Modes                 `json:"modes"`
+	Withdrawal    *domain.LimitOverride `json:"withdrawal,omitempty"`
+	Spend         *domain.LimitOverride `json:"spend,omitempty"`
+}
+
+// WindowMode selects how the window of a limit is measured.
+type WindowMode string
+
+const (
+	// WindowCalendar counts the loads of the calendar day or ISO week, in
+	// the timezone of the customer. It is the default.
+	WindowCalendar WindowMode = "calendar"
+	// WindowRolling counts the loads of the last 24 hours or 7 days before
+	// the load.
+	WindowRolling WindowMode = "rolling"
+)
+
+const (
+	RollingDay  = 24 * time.Hour
+	RollingWeek = 7 * RollingDay
+)
+
+func (m WindowMode) Validate() error {
+	if m != "" && m != WindowCalendar && m != WindowRolling {
+		return fmt.Errorf("%w: unknown window mode %q", domain.ErrInvalidLimits, m)
+	}
+	return nil
+}
+
+// Modes selects the window of each limit. Empty modes are calendar.
+type Modes struct {
+	DailyAmount  WindowMode `json:"daily_amount,omitempty"`
+	DailyCount   WindowMode `json:"daily_count,omitempty"`
+	WeeklyAmount WindowMode `json:"weekly_amount,omitempty"`
+}
+
+func (m Modes) Validate() error {
+	for _, mode := range []WindowMode{m.DailyAmount, m.DailyCount, m.WeeklyAmount} {
+		if err := mode.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historySince returns where the load history needed to check a load at at
+// starts, or the zero time when every limit is calendar.
+func (m Modes) historySince(at time.Time) time.Time {
+	switch {
+	case m.WeeklyAmount == WindowRolling:
+		return at.Add(-RollingWeek)
+	case m.DailyAmount == WindowRolling || m.DailyCount == WindowRolling:
+		return at.Add(-RollingDay)
+	}
+	return time.Time{}
+}
+
+// usage returns what the customer already loaded in the window of each limit
+// for a load at at.
+func (l Limits) usage(state domain.CustomerState, at time.Time) domain.StateDelta {
+	used := domain.StateDelta{
+		DailyAmount:   state.Daily.DailyTotal,
+		DailyCount:    state.Daily.TransactionCount,
+		WeeklyAmount:  state.Weekly.Value,
+		MonthlyAmount: state.Monthly.Value,
+		YearlyAmount:  state.Yearly.Value,
+	}
+	if l.Modes.DailyAmount == WindowRolling || l.Modes.DailyCount == WindowRolling {
+		amount, count := state.RecentLoads(at.Add(-RollingDay), at)
+		if l.Modes.DailyAmount == WindowRolling {
+			used.DailyAmount = amount
+		}
+		if l.Modes.DailyCount == WindowRolling {
+			used.DailyCount = count
+		}
+	}
+	if l.Modes.WeeklyAmount == WindowRolling {
+		used.WeeklyAmount, _ = state.RecentLoads(at.Add(-RollingWeek), at)
+	}
+	return used
+}
+
+// DefaultLimits returns $5,000 and 3 loads per day and $20,000 per week.
+func DefaultLimits() Limits {
+	return Limits{
+		DailyAmount:  domain.NewMoney(5000 * 100),
+		DailyCount:   3,
+		WeeklyAmount: domain.NewMoney(20000 * 100),
+	}
+}
+
+// For returns the limits transactions of the type are checked against: the
+// limits of loads, with the fields set in the override of the type replaced.
+func (l Limits) For(kind domain.TransactionType) Limits {
+	var override *domain.LimitOverride
+	switch kind {
+	case domain.TransactionWithdrawal:
+		override = l.Withdrawal
+	case domain.TransactionSpend:
+		override = l.Spend
+	}
+	l.Withdrawal, l.Spend = nil, nil
+	if override != nil {
+		l = l.WithOverride(*override)
+	}
+	return l
+}
+
+// Validate checks the limits of loads and those resolved for withdrawals and
+// spends, whose overrides cannot nest overrides of their own.
+func (l Limits) Validate() error {
+	for _, kind := range []domain.TransactionType{domain.TransactionWithdrawal, domain.TransactionSpend} {
+		override := l.Withdrawal
+		if kind == domain.TransactionSpend {
+			override = l.Spend
+		}
+		if override != nil && (override.Withdrawal != nil || override.Spend != nil) {
+			return fmt.Errorf("%w: %s limits must not nest other limits", domain.ErrInvalidLimits, kind)
+		}
+		if err := l.For(kind).validate(); err != nil {
+			return fmt.Errorf("%s: %w", kind, err)
+		}
+	}
+	return l.validate()
+}
+
+func (l Limits) validate() error {
+	if l.DailyAmount.IsNegative() {
+		return fmt.Errorf("%w: daily amount %s must not be negative", domain.ErrInvalidLimits, l.DailyAmount)
+	}
+	if l.DailyCount < 0 {
+		return fmt.Errorf("%w: daily count %d must not be negative", domain.ErrInvalidLimits, l.DailyCount)
+	}
+	if l.WeeklyAmount.IsNegative() {
+		return fmt.Errorf("%w: weekly amount %s must not be negative", domain.ErrInvalidLimits, l.WeeklyAmount)
+	}
+	if l.DailyAmount.GreaterThan(l.WeeklyAmount) {
+		return fmt.Errorf("%w: daily amount %s exceeds weekly amount %s", domain.ErrInvalidLimits, l.DailyAmount, l.WeeklyAmount)
+	}
+	if l.MonthlyAmount != nil && l.MonthlyAmount.IsNegative() {
+		return fmt.Errorf("%w: monthly amount %s must not be negative", domain.ErrInvalidLimits, *l.MonthlyAmount)
+	}
+	if l.YearlyAmount != nil && l.YearlyAmount.IsNegative() {
+		return fmt.Errorf("%w: yearly amount %s must not be negative", domain.ErrInvalidLimits, *l.YearlyAmount)
+	}
+	if l.MonthlyAmount != nil && l.YearlyAmount != nil && l.MonthlyAmount.GreaterThan(*l.YearlyAmount) {
+		return fmt.Errorf("%w: monthly amount %s exceeds yearly amount %s", domain.ErrInvalidLimits, *l.MonthlyAmount, *l.YearlyAmount)
+	}
+	return l.Modes.Validate()
+}
+
+// WithOverride returns a copy of the limits with the fields set in override
+// replaced. The overrides of withdrawals and spends are merged the same way
+// into those of the limits.
+func (l Limits) WithOverride(override domain.LimitOverride) Limits {
+	if override.DailyAmount != nil {
+		l.DailyAmount = *override.DailyAmount
+	}
+	if override.DailyCount != nil {
+		l.DailyCount = *override.DailyCount
+	}
+	if override.WeeklyAmount != nil {
+		l.WeeklyAmount = *override.WeeklyAmount
+	}
+	if override.MonthlyAmount != nil {
+		l.MonthlyAmount = override.MonthlyAmount
+	}
+	if override.YearlyAmount != nil {
+		l.YearlyAmount = override.YearlyAmount
+	}
+	if override.Withdrawal != nil {
+		l.Withdrawal = mergeOverride(l.Withdrawal, *override.Withdrawal)
+	}
+	if override.Spend != nil {
+		l.Spend = mergeOverride(l.Spend, *override.Spend)
+	}
+	return l
+}
+
+// mergeOverride returns base with the fields set in override replaced.
+func mergeOverride(base *domain.LimitOverride, override domain.LimitOverride) *domain.LimitOverride {
+	var merged domain.LimitOverride
+	if base != nil {
+		merged = *base
+	}
+	if override.DailyAmount != nil {
+		merged.DailyAmount = override.DailyAmount
+	}
+	if override.DailyCount != nil {
+		merged.DailyCount = override.DailyCount
+	}
+	if override.WeeklyAmount != nil {
+		merged.WeeklyAmount = override.WeeklyAmount
+	}
+	if override.MonthlyAmount != nil {
+		merged.MonthlyAmount = override.MonthlyAmount
+	}
+	if override.YearlyAmount != nil {
+		merged.YearlyAmount = override.YearlyAmount
+	}
+	if override.Withdrawal != nil {
+		merged.Withdrawal = override.Withdrawal
+	}
+	if override.Spend != nil {
+		merged.Spend = override.Spend
+	}
+	return &merged
+}
+
+// ForProfile resolves the limits of a customer: the tier override is applied
+// on top of the base limits and the customer override on top of that. An
+// empty tier is read as domain.TierBasic and unknown tiers keep the base.
+func (l Limits) ForProfile(tiers map[domain.Tier]domain.LimitOverride, profile domain.CustomerProfile) Limits {
+	tier := profile.Tier
+	if tier == "" {
+		tier = domain.TierBasic
+	}
+	if override, ok := tiers[tier]; ok {
+		l = l.WithOverride(override)
+	}
+	return l.WithOverride(profile.Override)
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+)
+
+// RemainingQuery answers how much a customer can still load.
+type RemainingQuery interface {
+	Remaining(customerID string, at time.Time) (domain.RemainingLimits, error)
+}
+
+// Remaining returns what the customer can still load in the day, week, month
+// and year of at, in the timezone of the customer, or in the 24 hours and 7
+// days up to at for rolling limits, under the limits of the customer. Limits
+// already exceeded are reported as zero.
+func (hs *HandlerTransactionService) Remaining(customerID string, at time.Time) (domain.RemainingLimits, error) {
+	if customerID == "" {
+		return domain.RemainingLimits{}, domain.ErrCustomerEmptyID
+	}
+	limits, location, err := hs.customerSettings(customerID)
+	if err != nil {
+		return domain.RemainingLimits{}, err
+	}
+	var state domain.CustomerState
+	read := func(current domain.CustomerState) (domain.CustomerState, bool, error) {
+		state = current
+		return current, false, nil
+	}
+	windows := windowsAt(at, location)
+	windows.Since = limits.Modes.historySince(at)
+	if err := hs.storage.UpdateCustomerState(customerID, windows, read); err != nil {
+		return domain.RemainingLimits{}, err
+	}
+	used := limits.usage(state, at)
+	remaining := domain.RemainingLimits{
+		CustomerID:   customerID,
+		Time:         at,
+		DailyAmount:  remainingAmount(limits.DailyAmount, used.DailyAmount),
+		DailyCount:   limits.DailyCount - used.DailyCount,
+		WeeklyAmount: remainingAmount(limits.WeeklyAmount, used.WeeklyAmount),
+	}
+	if remaining.DailyCount < 0 {
+		remaining.DailyCount = 0
+	}
+	if limits.MonthlyAmount != nil {
+		amount := remainingAmount(*limits.MonthlyAmount, used.MonthlyAmount)
+		remaining.MonthlyAmount = &amount
+	}
+	if limits.YearlyAmount != nil {
+		amount := remainingAmount(*limits.YearlyAmount, used.YearlyAmount)
+		remaining.YearlyAmount = &amount
+	}
+	return remaining, nil
+}
+
+func remainingAmount(limit, used domain.Money) domain.Money {
+	remaining := limit.Sub(used)
+	if remaining.IsNegative() {
+		return domain.Money{Currency: remaining.Currency}
+	}
+	return remaining
+}
@@ -0,0 +1,147 @@
+package handler_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRemaining(t *testing.T) {
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	windows := domain.Windows{
+		Day:   "2000-01-03",
+		Week:  domain.WeeklyTransaction{Year: 2000, Week: 1},
+		Month: domain.MonthlyTransaction{Year: 2000, Month: time.January},
+		Year:  domain.YearlyTransaction{Year: 2000},
+	}
+	testCases := []struct {
+		name              string
+		state             domain.CustomerState
+		stateErr          error
+		remainingExpected domain.RemainingLimits
+		errExpected       error
+	}{
+		{
+			name: "without loads",
+			remainingExpected: domain.RemainingLimits{
+				CustomerID:   "321",
+				Time:         at,
+				DailyAmount:  domain.NewMoney(500000),
+				DailyCount:   3,
+				WeeklyAmount: domain.NewMoney(2000000),
+			},
+		},
+		{
+			name: "with loads",
+			state: domain.CustomerState{
+				Daily:  domain.DailyTransaction{DailyTotal: domain.NewMoney(100050), TransactionCount: 1},
+				Weekly: domain.WeeklyTransactionTotal{Value: domain.NewMoney(1500000)},
+			},
+			remainingExpected: domain.RemainingLimits{
+				CustomerID:   "321",
+				Time:         at,
+				DailyAmount:  domain.NewMoney(399950),
+				DailyCount:   2,
+				WeeklyAmount: domain.NewMoney(500000),
+			},
+		},
+		{
+			name: "exceeded limits are zero",
+			state: domain.CustomerState{
+				Daily:  domain.DailyTransaction{DailyTotal: domain.NewMoney(600000), TransactionCount: 4},
+				Weekly: domain.WeeklyTransactionTotal{Value: domain.NewMoney(2100000)},
+			},
+			remainingExpected: domain.RemainingLimits{
+				CustomerID:   "321",
+				Time:         at,
+				DailyAmount:  domain.NewMoney(0),
+				DailyCount:   0,
+				WeeklyAmount: domain.NewMoney(0),
+			},
+		},
+		{
+			name:        "storage error",
+			stateErr:    errors.New("some error"),
+			errExpected: errors.New("some error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+			suite.repo.On("UpdateCustomerState", "321", windows).Return(tc.state, tc.stateErr).Once()
+			remaining, err := h.Remaining("321", at)
+			assert.Equal(t, tc.errExpected, err)
+			assert.Equal(t, tc.remainingExpected, remaining)
+			suite.repo.AssertExpectations(t)
+			suite.repo.AssertNotCalled(t, "CommitCustomerState", "321", tc.state)
+		})
+	}
+}
+
+func TestRemainingShouldUseCustomerLimits(t *testing.T) {
+	suite := newSuite()
+	weekly := domain.NewMoney(6000000)
+	tiers := map[domain.Tier]domain.LimitOverride{domain.TierPremium: {WeeklyAmount: &weekly}}
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil, handler.WithCustomerLimits(suite.profiles, tiers))
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	suite.profiles.On("GetCustomerProfile", "321").Return(domain.CustomerProfile{CustomerID: "321", Tier: domain.TierPremium}, nil).Once()
+	suite.repo.On("UpdateCustomerState", "321", domain.Windows{Day: "2000-01-03", Week: domain.WeeklyTransaction{Year: 2000, Week: 1}, Month: domain.MonthlyTransaction{Year: 2000, Month: time.January}, Year: domain.YearlyTransaction{Year: 2000}}).Return(domain.CustomerState{}, nil).Once()
+	remaining, err := h.Remaining("321", at)
+	assert.Nil(t, err)
+	assert.Equal(t, weekly, remaining.WeeklyAmount)
+}
+
+func TestRemainingShouldUseRollingWindows(t *testing.T) {
+	suite := newSuite()
+	limits := handler.DefaultLimits()
+	limits.Modes = handler.Modes{DailyCount: handler.WindowRolling, WeeklyAmount: handler.WindowRolling}
+	h := handler.New(suite.repo, limits, nil, nil)
+	at := time.Date(2000, 1, 3, 0, 30, 0, 0, time.UTC)
+	windows := domain.Windows{Day: "2000-01-03", Week: domain.WeeklyTransaction{Year: 2000, Week: 1}, Month: domain.MonthlyTransaction{Year: 2000, Month: time.January}, Year: domain.YearlyTransaction{Year: 2000}, Since: at.Add(-handler.RollingWeek)}
+	state := domain.CustomerState{Recent: []domain.LoadEvent{
+		{ID: "1", Time: at.Add(-3 * 24 * time.Hour), Amount: domain.NewMoney(1000000)},
+		{ID: "2", Time: at.Add(-time.Hour), Amount: domain.NewMoney(100000)},
+	}}
+	suite.repo.On("UpdateCustomerState", "321", windows).Return(state, nil).Once()
+	remaining, err := h.Remaining("321", at)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.RemainingLimits{
+		CustomerID:   "321",
+		Time:         at,
+		DailyAmount:  domain.NewMoney(500000),
+		DailyCount:   2,
+		WeeklyAmount: domain.NewMoney(900000),
+	}, remaining)
+}
+
+func TestRemainingShouldRequireCustomerID(t *testing.T) {
+	h := handler.New(newSuite().repo, handler.DefaultLimits(), nil, nil)
+	_, err := h.Remaining("", time.Now())
+	assert.Equal(t, domain.ErrCustomerEmptyID, err)
+}
+
+func TestRemainingShouldReportMonthlyAndYearlyLimits(t *testing.T) {
+	suite := newSuite()
+	monthly := domain.NewMoney(3000000)
+	yearly := domain.NewMoney(10000000)
+	tiers := map[domain.Tier]domain.LimitOverride{domain.TierBasic: {MonthlyAmount: &monthly, YearlyAmount: &yearly}}
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil, handler.WithCustomerLimits(suite.profiles, tiers))
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	state := domain.CustomerState{
+		Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(3100000)},
+		Yearly:  domain.YearlyTransactionTotal{Value: domain.NewMoney(3100000)},
+	}
+	suite.profiles.On("GetCustomerProfile", "321").Return(domain.CustomerProfile{}, domain.ErrNotFound).Once()
+	suite.repo.On("UpdateCustomerState", "321", mock.Anything).Return(state, nil).Once()
+	remaining, err := h.Remaining("321", at)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(0), *remaining.MonthlyAmount)
+	assert.Equal(t, domain.NewMoney(6900000), *remaining.YearlyAmount)
+}
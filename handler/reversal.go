@@ -0,0 +1,134 @@
+package handler
+
+import "github.com/danielfmelo/load-funds-handler/domain"
+
+// reverse gives back the limits consumed by the transaction the reversal
+// references. The transaction is marked reversed before anything else is
+// written, so no other reversal can give the limits back too, and the
+// counters and the ledger keep whether they hold the reversal, so the same
+// reversal retried after failing part way resumes without giving them back
+// twice. Reversing a load takes its funds back, so it is rejected when the
+// customer no longer holds them. A reversal rejected or failing before its
+// entries are posted unmarks the transaction, leaving it for another
+// reversal.
+func (hs *HandlerTransactionService) reverse(fund []byte, reversal domain.Transaction, dryRun bool) Result {
+	original, reason, err := hs.reversible(reversal)
+	if err != nil {
+		return errorResult(errorEvent(fund, reversal, domain.StageReverse), "error to get reversed transaction", err)
+	}
+	// An earlier attempt of the reversal may have marked the transaction.
+	marked := reason == "" && original.ReversedBy == reversal.ID
+	if reason == "" && hs.ledger != nil && !original.IsDebit() {
+		funded, err := hs.funded(reversal, original.LoadAmount)
+		if err != nil {
+			return errorResult(errorEvent(fund, reversal, domain.StageLedger), "error to get balance", err)
+		}
+		if !funded {
+			reason = domain.ReasonInsufficientFunds
+		}
+	}
+	if reason == "" && !dryRun {
+		err := hs.storage.MarkReversed(reversal.CustomerID, original.ID, reversal.ID)
+		switch err {
+		case nil:
+			marked = true
+		case domain.ErrAlreadyReversed:
+			reason = domain.ReasonAlreadyReversed
+		default:
+			return errorResult(errorEvent(fund, reversal, domain.StageReverse), "error to mark transaction reversed", err)
+		}
+	}
+	if reason == "" && !dryRun {
+		err := hs.post(reversal, domain.ReversalEntries(reversal, original))
+		if err == domain.ErrInsufficientFunds {
+			// A debit spent the funds since they were checked.
+			reason = domain.ReasonInsufficientFunds
+		} else if err != nil {
+			if err := hs.storage.UnmarkReversed(reversal.CustomerID, original.ID, reversal.ID); err != nil {
+				return errorResult(errorEvent(fund, reversal, domain.StageReverse), "error to unmark transaction reversed", err)
+			}
+			return errorResult(errorEvent(fund, reversal, domain.StageLedger), "error to post ledger entries", err)
+		}
+	}
+	if reason != "" && marked && !dryRun {
+		if err := hs.storage.UnmarkReversed(reversal.CustomerID, original.ID, reversal.ID); err != nil {
+			return errorResult(errorEvent(fund, reversal, domain.StageReverse), "error to unmark transaction reversed", err)
+		}
+	}
+	if reason == "" {
+		limits, location, err := hs.customerSettings(reversal.CustomerID)
+		if err != nil {
+			return errorResult(errorEvent(fund, reversal, domain.StageLimits), "error to get customer limits", err)
+		}
+		limits = limits.For(original.Kind())
+		revert := func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			if state.Applied {
+				return state, false, nil
+			}
+			state = state.Revert(counterDelta(original), original.ID)
+			state.Applied = true
+			return state, !dryRun, nil
+		}
+		windows := windowsAt(original.Time, location)
+		if !limits.Modes.historySince(original.Time).IsZero() {
+			windows.Since = original.Time
+		}
+		windows.TransactionID = reversal.ID
+		windows.Kind = counterKind(original)
+		if err := hs.storage.UpdateCustomerState(reversal.CustomerID, windows, revert); err != nil {
+			return errorResult(errorEvent(fund, reversal, domain.StageEvaluate), "error to update customer state", err)
+		}
+	}
+	if !dryRun {
+		saved := domain.TransactionDecision{Accepted: reason == "", Reason: reason}
+		if err := hs.storage.SetDecision(reversal.CustomerID, reversal.ID, saved); err != nil {
+			return errorResult(errorEvent(fund, reversal, domain.StageDecision), "error to save decision", err)
+		}
+	}
+	if reason != "" {
+		return hs.invalidResult(reversal, reason)
+	}
+	return hs.validResult(reversal)
+}
+
+// reversible returns the transaction the reversal references, or the reason
+// it cannot be reversed: it is missing, is not an accepted load, withdrawal
+// or spend, or was already reversed by another reversal.
+func (hs *HandlerTransactionService) reversible(reversal domain.Transaction) (domain.Transaction, domain.Reason, error) {
+	original, err := hs.storage.GetTransaction(reversal.CustomerID, reversal.Reverses)
+	if err == domain.ErrNotFound {
+		return original, domain.ReasonOriginalNotFound, nil
+	}
+	if err != nil {
+		return original, "", err
+	}
+	if original.IsReversal() {
+		return original, domain.ReasonOriginalNotAccepted, nil
+	}
+	if original.ReversedBy != "" && original.ReversedBy != reversal.ID {
+		return original, domain.ReasonAlreadyReversed, nil
+	}
+	decision, err := hs.storage.GetDecision(reversal.CustomerID, original.ID)
+	if err == domain.ErrNotFound {
+		return original, domain.ReasonOriginalNotAccepted, nil
+	}
+	if err != nil {
+		return original, "", err
+	}
+	if !decision.Accepted {
+		return original, domain.ReasonOriginalNotAccepted, nil
+	}
+	return original, "", nil
+}
+
+// counterDelta is the change an accepted load, withdrawal or spend made to
+// its counters.
+func counterDelta(transaction domain.Transaction) domain.StateDelta {
+	return domain.StateDelta{
+		DailyAmount:   transaction.LoadAmount,
+		DailyCount:    1,
+		WeeklyAmount:  transaction.LoadAmount,
+		MonthlyAmount: transaction.LoadAmount,
+		YearlyAmount:  transaction.LoadAmount,
+	}
+}
@@ -0,0 +1,267 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/danielfmelo/load-funds-handler/storage"
+	"github.com/danielfmelo/load-funds-handler/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func fakeReversal(t *testing.T) (domain.Transaction, []byte) {
+	reversal := domain.Transaction{
+		ID:         "124",
+		CustomerID: "321",
+		Time:       time.Date(2000, 1, 4, 10, 0, 0, 0, time.UTC),
+		Type:       domain.TransactionReversal,
+		Reverses:   "123",
+	}
+	fund, err := json.Marshal(reversal)
+	assert.Nil(t, err)
+	return reversal, fund
+}
+
+// revertWindows returns the windows the reversal gives the limits of the
+// original back in.
+func revertWindows(original domain.Transaction) domain.Windows {
+	windows := fakeWindows(original)
+	windows.TransactionID = "124"
+	return windows
+}
+
+func TestTransactionShouldReverseLoad(t *testing.T) {
+	original := domain.Transaction{ID: "123", CustomerID: "321", LoadAmount: domain.NewMoney(100000), Time: time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)}
+	loaded := domain.StateDelta{
+		DailyAmount:   original.LoadAmount,
+		DailyCount:    1,
+		WeeklyAmount:  original.LoadAmount,
+		MonthlyAmount: original.LoadAmount,
+		YearlyAmount:  original.LoadAmount,
+	}
+	testCases := []struct {
+		name           string
+		original       domain.Transaction
+		originalErr    error
+		decision       domain.TransactionDecision
+		decisionErr    error
+		markErr        error
+		reasonExpected domain.Reason
+	}{
+		{
+			name:     "accepted load",
+			original: original,
+			decision: domain.TransactionDecision{Accepted: true},
+		},
+		{
+			name:           "missing load",
+			originalErr:    domain.ErrNotFound,
+			reasonExpected: domain.ReasonOriginalNotFound,
+		},
+		{
+			name:           "rejected load",
+			original:       original,
+			decision:       domain.TransactionDecision{Reason: domain.ReasonDailyCountExceeded},
+			reasonExpected: domain.ReasonOriginalNotAccepted,
+		},
+		{
+			name:           "undecided load",
+			original:       original,
+			decisionErr:    domain.ErrNotFound,
+			reasonExpected: domain.ReasonOriginalNotAccepted,
+		},
+		{
+			name:           "reversal",
+			original:       domain.Transaction{ID: "123", CustomerID: "321", Type: domain.TransactionReversal, Reverses: "122"},
+			decision:       domain.TransactionDecision{Accepted: true},
+			reasonExpected: domain.ReasonOriginalNotAccepted,
+		},
+		{
+			name:           "load already reversed",
+			original:       domain.Transaction{ID: "123", CustomerID: "321", LoadAmount: original.LoadAmount, Time: original.Time, ReversedBy: "122"},
+			reasonExpected: domain.ReasonAlreadyReversed,
+		},
+		{
+			name:           "load reversed concurrently",
+			original:       original,
+			decision:       domain.TransactionDecision{Accepted: true},
+			markErr:        domain.ErrAlreadyReversed,
+			reasonExpected: domain.ReasonAlreadyReversed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSuite()
+			h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+			reversal, fund := fakeReversal(t)
+			suite.repo.On("AddTransaction").Return(nil).Once()
+			suite.repo.On("GetTransaction", "321", "123").Return(tc.original, tc.originalErr).Once()
+			suite.repo.On("GetDecision", "321", "123").Return(tc.decision, tc.decisionErr).Maybe()
+			suite.repo.On("MarkReversed", "321", "123", "124").Return(tc.markErr).Maybe()
+			if tc.reasonExpected == "" {
+				state := domain.CustomerState{}.Apply(loaded).Apply(loaded)
+				suite.repo.On("UpdateCustomerState", "321", revertWindows(original)).Return(state, nil).Once()
+				suite.repo.On("CommitCustomerState", "321", applied(domain.CustomerState{}.Apply(loaded))).Once()
+			}
+			result := h.Process(fund)
+			assert.Nil(t, result.Err)
+			assert.Equal(t, tc.reasonExpected, result.Reason)
+			var response domain.TransactionResponse
+			assert.Nil(t, json.Unmarshal(result.Event, &response))
+			assert.Equal(t, reversal.ID, response.ID)
+			assert.Equal(t, tc.reasonExpected == "", response.Accepted)
+			suite.repo.AssertExpectations(t)
+			suite.repo.AssertCalled(t, "SetDecision", "321", "124", domain.TransactionDecision{Accepted: tc.reasonExpected == "", Reason: tc.reasonExpected})
+		})
+	}
+}
+
+func TestTransactionShouldReturnReversalStorageError(t *testing.T) {
+	suite := newSuite()
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+	_, fund := fakeReversal(t)
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("GetTransaction", "321", "123").Return(domain.Transaction{}, errors.New("some error")).Once()
+	result := h.Process(fund)
+	event := decodeErrorEvent(t, result.Err)
+	assert.Equal(t, domain.StageReverse, event.Stage)
+	assert.Equal(t, domain.ErrorKindStorage, event.Kind)
+	suite.repo.AssertNotCalled(t, "MarkReversed", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCheckShouldNotRecordReversal(t *testing.T) {
+	suite := newSuite()
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil)
+	_, fund := fakeReversal(t)
+	original := domain.Transaction{ID: "123", CustomerID: "321", LoadAmount: domain.NewMoney(100), Time: time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)}
+	suite.repo.On("GetTransaction", "321", "124").Return(domain.Transaction{}, domain.ErrNotFound).Once()
+	suite.repo.On("GetTransaction", "321", "123").Return(original, nil).Once()
+	suite.repo.On("GetDecision", "321", "123").Return(domain.TransactionDecision{Accepted: true}, nil).Once()
+	suite.repo.On("UpdateCustomerState", "321", revertWindows(original)).Return(domain.CustomerState{}, nil).Once()
+	result := h.Check(fund)
+	assert.Equal(t, "{\"id\":\"124\",\"customer_id\":\"321\",\"accepted\":true}", string(result.Event))
+	suite.repo.AssertExpectations(t)
+	suite.repo.AssertNotCalled(t, "AddTransaction")
+	suite.repo.AssertNotCalled(t, "MarkReversed", mock.Anything, mock.Anything, mock.Anything)
+	suite.repo.AssertNotCalled(t, "SetDecision", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTransactionShouldResumeInterruptedReversal(t *testing.T) {
+	database := &failingStorage{
+		Database:  memory.New(),
+		decisions: map[string]bool{"5": true},
+		states:    map[string]bool{"4": true},
+	}
+	h := handler.New(database, handler.DefaultLimits(), nil, nil, handler.WithLedger(database))
+	at := "2000-01-03T10:00:00Z"
+	for _, id := range []string{"1", "2", "3"} {
+		result := h.Process([]byte(`{"id":"` + id + `","customer_id":"321","load_amount":"$100.00","time":"` + at + `"}`))
+		assert.Nil(t, result.Err)
+	}
+	for _, tc := range []struct{ id, reverses string }{{"4", "1"}, {"5", "2"}} {
+		fund := []byte(`{"id":"` + tc.id + `","customer_id":"321","type":"reversal","reverses":"` + tc.reverses + `","time":"` + at + `"}`)
+		result := h.Process(fund)
+		assert.NotNil(t, result.Err)
+		result = h.Process(fund)
+		assert.Equal(t, `{"id":"`+tc.id+`","customer_id":"321","accepted":true}`, string(result.Event))
+	}
+	daily, err := database.GetDailyTransaction("321", "2000-01-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, daily.TransactionCount)
+	assert.Equal(t, domain.NewMoney(10000), daily.DailyTotal)
+	balance, err := h.Balance("321")
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(10000), balance.Balance)
+}
+
+func TestTransactionShouldNotReverseSpentLoad(t *testing.T) {
+	database := memory.New()
+	h := handler.New(database, handler.DefaultLimits(), nil, nil, handler.WithLedger(database))
+	at := "2000-01-03T10:00:00Z"
+	process := func(fund string) handler.Result {
+		result := h.Process([]byte(fund))
+		assert.Nil(t, result.Err)
+		return result
+	}
+	process(`{"id":"1","customer_id":"321","load_amount":"$100.00","time":"` + at + `"}`)
+	process(`{"id":"2","customer_id":"321","type":"spend","amount":"$100.00","time":"` + at + `"}`)
+	result := process(`{"id":"3","customer_id":"321","type":"reversal","reverses":"1","time":"` + at + `"}`)
+	assert.Equal(t, domain.ReasonInsufficientFunds, result.Reason)
+	balance, err := h.Balance("321")
+	assert.Nil(t, err)
+	assert.True(t, balance.Balance.IsZero())
+	daily, err := database.GetDailyTransaction("321", "2000-01-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, daily.TransactionCount)
+
+	// Once the customer holds the funds again, the load can be reversed.
+	process(`{"id":"4","customer_id":"321","load_amount":"$100.00","time":"` + at + `"}`)
+	result = process(`{"id":"5","customer_id":"321","type":"reversal","reverses":"1","time":"` + at + `"}`)
+	assert.Equal(t, `{"id":"5","customer_id":"321","accepted":true}`, string(result.Event))
+	balance, err = h.Balance("321")
+	assert.Nil(t, err)
+	assert.True(t, balance.Balance.IsZero())
+}
+
+func TestTransactionShouldUnmarkReversalThatFailedToPost(t *testing.T) {
+	database := &failingStorage{Database: memory.New(), posts: map[string]bool{"2": true}}
+	h := handler.New(database, handler.DefaultLimits(), nil, nil, handler.WithLedger(database))
+	at := "2000-01-03T10:00:00Z"
+	reversal := []byte(`{"id":"2","customer_id":"321","type":"reversal","reverses":"1","time":"` + at + `"}`)
+	process := func(fund []byte) handler.Result {
+		result := h.Process(fund)
+		assert.Nil(t, result.Err)
+		return result
+	}
+	process([]byte(`{"id":"1","customer_id":"321","load_amount":"$100.00","time":"` + at + `"}`))
+	result := h.Process(reversal)
+	assert.Equal(t, domain.StageLedger, decodeErrorEvent(t, result.Err).Stage)
+	original, err := database.GetTransaction("321", "1")
+	assert.Nil(t, err)
+	assert.Empty(t, original.ReversedBy)
+
+	process([]byte(`{"id":"3","customer_id":"321","type":"withdrawal","amount":"$100.00","time":"` + at + `"}`))
+	// As an attempt stopped right after marking the load would leave it.
+	assert.Nil(t, database.MarkReversed("321", "1", "2"))
+	result = process(reversal)
+	assert.Equal(t, domain.ReasonInsufficientFunds, result.Reason)
+	original, err = database.GetTransaction("321", "1")
+	assert.Nil(t, err)
+	assert.Empty(t, original.ReversedBy)
+
+	process([]byte(`{"id":"4","customer_id":"321","load_amount":"$500.00","time":"` + at + `"}`))
+	result = process([]byte(`{"id":"5","customer_id":"321","type":"reversal","reverses":"1","time":"` + at + `"}`))
+	assert.Equal(t, `{"id":"5","customer_id":"321","accepted":true}`, string(result.Event))
+	balance, err := h.Balance("321")
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(40000), balance.Balance)
+}
+
+func TestTransactionShouldUnmarkReversalWhenFundsWereSpent(t *testing.T) {
+	suite := newSuite()
+	ledger := &storage.LedgerMock{}
+	h := handler.New(suite.repo, handler.DefaultLimits(), nil, nil, handler.WithLedger(ledger))
+	reversal, fund := fakeReversal(t)
+	original := domain.Transaction{ID: "123", CustomerID: "321", LoadAmount: domain.NewMoney(100000), Time: time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)}
+	suite.repo.On("AddTransaction").Return(nil).Once()
+	suite.repo.On("GetTransaction", "321", "123").Return(original, nil).Once()
+	suite.repo.On("GetDecision", "321", "123").Return(domain.TransactionDecision{Accepted: true}, nil).Once()
+	ledger.On("Posted", "321", "124").Return(false, nil).Once()
+	ledger.On("ListEntries", "321").Return(domain.LoadEntries(original), nil).Once()
+	suite.repo.On("MarkReversed", "321", "123", "124").Return(nil).Once()
+	ledger.On("PostFundedEntries", "321", "124", domain.ReversalEntries(reversal, original)).Return(domain.ErrInsufficientFunds).Once()
+	suite.repo.On("UnmarkReversed", "321", "123", "124").Return(nil).Once()
+	result := h.Process(fund)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, domain.ReasonInsufficientFunds, result.Reason)
+	suite.repo.AssertExpectations(t)
+	ledger.AssertExpectations(t)
+	suite.repo.AssertNotCalled(t, "UpdateCustomerState", mock.Anything, mock.Anything)
+	suite.repo.AssertCalled(t, "SetDecision", "321", "124", domain.TransactionDecision{Reason: domain.ReasonInsufficientFunds})
+}
@@ -0,0 +1,106 @@
+package handler
+
+import "github.com/danielfmelo/load-funds-handler/domain"
+
+// Rule evaluates a transaction against the current state of the customer and
+// the limits that apply to the customer.
+//
+// An allowing decision carries the change the transaction makes to the
+// counters owned by the rule; the deltas of all rules in a chain are added
+// together, so a rule must only report the counters it is responsible for.
+type Rule interface {
+	Evaluate(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision
+}
+
+// RuleFunc adapts a function to the Rule interface.
+type RuleFunc func(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision
+
+func (f RuleFunc) Evaluate(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision {
+	return f(transaction, state, limits)
+}
+
+type Decision struct {
+	Allowed bool
+	Reason  domain.Reason
+	Delta   domain.StateDelta
+}
+
+func Allow(delta domain.StateDelta) Decision {
+	return Decision{Allowed: true, Delta: delta}
+}
+
+func Deny(reason domain.Reason) Decision {
+	return Decision{Reason: reason}
+}
+
+// DefaultRules returns the daily amount, daily count, weekly amount, monthly
+// amount and yearly amount rules, in that order.
+func DefaultRules() []Rule {
+	return []Rule{DailyAmountRule{}, DailyCountRule{}, WeeklyAmountRule{}, MonthlyAmountRule{}, YearlyAmountRule{}}
+}
+
+// EvaluateRules runs the rules in order and stops at the first denial.
+func EvaluateRules(rules []Rule, transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision {
+	var delta domain.StateDelta
+	for _, rule := range rules {
+		decision := rule.Evaluate(transaction, state, limits)
+		if !decision.Allowed {
+			return decision
+		}
+		delta = delta.Add(decision.Delta)
+	}
+	return Allow(delta)
+}
+
+type DailyAmountRule struct{}
+
+func (DailyAmountRule) Evaluate(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision {
+	total := limits.usage(state, transaction.Time).DailyAmount.Add(transaction.LoadAmount)
+	if total.GreaterThan(limits.DailyAmount) {
+		return Deny(domain.ReasonDailyAmountExceeded)
+	}
+	return Allow(domain.StateDelta{DailyAmount: transaction.LoadAmount})
+}
+
+type DailyCountRule struct{}
+
+func (DailyCountRule) Evaluate(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision {
+	if limits.usage(state, transaction.Time).DailyCount+1 > limits.DailyCount {
+		return Deny(domain.ReasonDailyCountExceeded)
+	}
+	return Allow(domain.StateDelta{DailyCount: 1})
+}
+
+type WeeklyAmountRule struct{}
+
+func (WeeklyAmountRule) Evaluate(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision {
+	total := limits.usage(state, transaction.Time).WeeklyAmount.Add(transaction.LoadAmount)
+	if total.GreaterThan(limits.WeeklyAmount) {
+		return Deny(domain.ReasonWeeklyAmountExceeded)
+	}
+	return Allow(domain.StateDelta{WeeklyAmount: transaction.LoadAmount})
+}
+
+// MonthlyAmountRule counts the loads of the calendar month, and only denies
+// them when a monthly limit is set.
+type MonthlyAmountRule struct{}
+
+func (MonthlyAmountRule) Evaluate(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision {
+	total := limits.usage(state, transaction.Time).MonthlyAmount.Add(transaction.LoadAmount)
+	if limits.MonthlyAmount != nil && total.GreaterThan(*limits.MonthlyAmount) {
+		return Deny(domain.ReasonMonthlyAmountExceeded)
+	}
+	return Allow(domain.StateDelta{MonthlyAmount: transaction.LoadAmount})
+}
+
+// YearlyAmountRule counts the loads of the calendar year, and only denies
+// them when a yearly limit is set.
+type YearlyAmountRule struct{}
+
+func (YearlyAmountRule) Evaluate(transaction domain.Transaction, state domain.CustomerState, limits Limits) Decision {
+	total := limits.usage(state, transaction.Time).YearlyAmount.Add(transaction.LoadAmount)
+	if limits.YearlyAmount != nil && total.GreaterThan(*limits.YearlyAmount) {
+		return Deny(domain.ReasonYearlyAmountExceeded)
+	}
+	return Allow(domain.StateDelta{YearlyAmount: transaction.LoadAmount})
+}
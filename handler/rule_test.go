@@ -0,0 +1,164 @@
+package handler_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateRules(t *testing.T) {
+	transaction := domain.Transaction{ID: "1", CustomerID: "2", LoadAmount: domain.NewMoney(100000)}
+	monthly := domain.NewMoney(3000000)
+	yearly := domain.NewMoney(10000000)
+	delta := domain.StateDelta{
+		DailyAmount:   domain.NewMoney(100000),
+		DailyCount:    1,
+		WeeklyAmount:  domain.NewMoney(100000),
+		MonthlyAmount: domain.NewMoney(100000),
+		YearlyAmount:  domain.NewMoney(100000),
+	}
+	testCases := []struct {
+		name             string
+		state            domain.CustomerState
+		monthly          *domain.Money
+		yearly           *domain.Money
+		decisionExpected handler.Decision
+	}{
+		{
+			name:             "should allow and add deltas",
+			state:            domain.CustomerState{},
+			decisionExpected: handler.Allow(delta),
+		},
+		{
+			name:             "should deny daily amount",
+			state:            domain.CustomerState{Daily: domain.DailyTransaction{DailyTotal: domain.NewMoney(400001)}},
+			decisionExpected: handler.Deny(domain.ReasonDailyAmountExceeded),
+		},
+		{
+			name:             "should deny daily amount that would wrap",
+			state:            domain.CustomerState{Daily: domain.DailyTransaction{DailyTotal: domain.NewMoney(math.MaxInt64 - 1)}},
+			decisionExpected: handler.Deny(domain.ReasonDailyAmountExceeded),
+		},
+		{
+			name:             "should deny daily count",
+			state:            domain.CustomerState{Daily: domain.DailyTransaction{TransactionCount: 3}},
+			decisionExpected: handler.Deny(domain.ReasonDailyCountExceeded),
+		},
+		{
+			name:             "should deny weekly amount",
+			state:            domain.CustomerState{Weekly: domain.WeeklyTransactionTotal{Value: domain.NewMoney(1900001)}},
+			decisionExpected: handler.Deny(domain.ReasonWeeklyAmountExceeded),
+		},
+		{
+			name:             "should report first denial",
+			state:            domain.CustomerState{Daily: domain.DailyTransaction{DailyTotal: domain.NewMoney(500000), TransactionCount: 3}},
+			decisionExpected: handler.Deny(domain.ReasonDailyAmountExceeded),
+		},
+		{
+			name:             "should not cap month and year without limits",
+			state:            domain.CustomerState{Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(50000000)}, Yearly: domain.YearlyTransactionTotal{Value: domain.NewMoney(50000000)}},
+			decisionExpected: handler.Allow(delta),
+		},
+		{
+			name:             "should allow up to monthly amount",
+			state:            domain.CustomerState{Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(2900000)}},
+			monthly:          &monthly,
+			decisionExpected: handler.Allow(delta),
+		},
+		{
+			name:             "should deny monthly amount",
+			state:            domain.CustomerState{Monthly: domain.MonthlyTransactionTotal{Value: domain.NewMoney(2900001)}},
+			monthly:          &monthly,
+			yearly:           &yearly,
+			decisionExpected: handler.Deny(domain.ReasonMonthlyAmountExceeded),
+		},
+		{
+			name:             "should deny yearly amount",
+			state:            domain.CustomerState{Yearly: domain.YearlyTransactionTotal{Value: domain.NewMoney(9900001)}},
+			monthly:          &monthly,
+			yearly:           &yearly,
+			decisionExpected: handler.Deny(domain.ReasonYearlyAmountExceeded),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := handler.DefaultLimits()
+			limits.MonthlyAmount = tc.monthly
+			limits.YearlyAmount = tc.yearly
+			decision := handler.EvaluateRules(handler.DefaultRules(), transaction, tc.state, limits)
+			assert.Equal(t, tc.decisionExpected, decision)
+		})
+	}
+}
+
+func TestEvaluateRulesWithRollingWindows(t *testing.T) {
+	at := time.Date(2000, 1, 4, 0, 1, 0, 0, time.UTC)
+	transaction := domain.Transaction{ID: "1", CustomerID: "2", LoadAmount: domain.NewMoney(100000), Time: at}
+	recent := func(ago time.Duration, amount int64) domain.LoadEvent {
+		return domain.LoadEvent{Time: at.Add(-ago), Amount: domain.NewMoney(amount)}
+	}
+	delta := domain.StateDelta{
+		DailyAmount:   domain.NewMoney(100000),
+		DailyCount:    1,
+		WeeklyAmount:  domain.NewMoney(100000),
+		MonthlyAmount: domain.NewMoney(100000),
+		YearlyAmount:  domain.NewMoney(100000),
+	}
+	testCases := []struct {
+		name             string
+		modes            handler.Modes
+		recent           []domain.LoadEvent
+		decisionExpected handler.Decision
+	}{
+		{
+			name:             "calendar should ignore loads of the previous day",
+			recent:           []domain.LoadEvent{recent(2*time.Minute, 500000)},
+			decisionExpected: handler.Allow(delta),
+		},
+		{
+			name:             "rolling should deny daily amount loaded before midnight",
+			modes:            handler.Modes{DailyAmount: handler.WindowRolling},
+			recent:           []domain.LoadEvent{recent(2*time.Minute, 500000)},
+			decisionExpected: handler.Deny(domain.ReasonDailyAmountExceeded),
+		},
+		{
+			name:             "rolling should ignore loads older than 24 hours",
+			modes:            handler.Modes{DailyAmount: handler.WindowRolling},
+			recent:           []domain.LoadEvent{recent(24*time.Hour, 500000)},
+			decisionExpected: handler.Allow(delta),
+		},
+		{
+			name:             "rolling should deny daily count",
+			modes:            handler.Modes{DailyCount: handler.WindowRolling},
+			recent:           []domain.LoadEvent{recent(time.Hour, 100), recent(2*time.Hour, 100), recent(23*time.Hour, 100)},
+			decisionExpected: handler.Deny(domain.ReasonDailyCountExceeded),
+		},
+		{
+			name:             "rolling should deny weekly amount loaded in the previous ISO week",
+			modes:            handler.Modes{WeeklyAmount: handler.WindowRolling},
+			recent:           []domain.LoadEvent{recent(2*24*time.Hour, 1000000), recent(6*24*time.Hour, 900001)},
+			decisionExpected: handler.Deny(domain.ReasonWeeklyAmountExceeded),
+		},
+		{
+			name:             "rolling should ignore loads older than 7 days",
+			modes:            handler.Modes{WeeklyAmount: handler.WindowRolling},
+			recent:           []domain.LoadEvent{recent(2*24*time.Hour, 1000000), recent(7*24*time.Hour, 900001)},
+			decisionExpected: handler.Allow(delta),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := handler.DefaultLimits()
+			limits.Modes = tc.modes
+			state := domain.CustomerState{Recent: tc.recent}
+			decision := handler.EvaluateRules(handler.DefaultRules(), transaction, state, limits)
+			assert.Equal(t, tc.decisionExpected, decision)
+		})
+	}
+}
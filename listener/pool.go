@@ -0,0 +1,134 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+
+	"github.com/danielfmelo/load-funds-handler/handler"
+)
+
+const queueSize = 64
+
+// Pool processes funds on a fixed number of workers. All funds of a customer
+// go to the same worker, so they are processed in the order they were
+// received, while funds of different customers are processed in parallel.
+type Pool struct {
+	handle  handler.HandlerTransaction
+	workers int
+	ordered bool
+}
+
+type job struct {
+	seq  uint64
+	fund []byte
+}
+
+type processed struct {
+	seq    uint64
+	result handler.Result
+}
+
+// NewPool returns a pool with the given number of workers. When ordered is
+// true the results are sent in the order the funds were received, otherwise
+// as soon as each one is ready.
+func NewPool(handle handler.HandlerTransaction, workers int, ordered bool) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		handle:  handle,
+		workers: workers,
+		ordered: ordered,
+	}
+}
+
+// Receiver starts processing the funds sent on chFunds and returns the channel
+// the results are sent on. Once chFunds is closed or ctx is cancelled no more
+// funds are read; the funds already received are still processed and the
+// results channel is closed after the last of their results.
+func (p *Pool) Receiver(ctx context.Context, chFunds chan []byte) <-chan handler.Result {
+	queues := make([]chan job, p.workers)
+	chProcessed := make(chan processed, p.workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan job, queueSize)
+		wg.Add(1)
+		go func(queue chan job) {
+			defer wg.Done()
+			for j := range queue {
+				chProcessed <- processed{seq: j.seq, result: p.handle.Process(j.fund)}
+			}
+		}(queues[i])
+	}
+	go func() {
+		p.dispatch(ctx, chFunds, queues)
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+		close(chProcessed)
+	}()
+
+	chResults := make(chan handler.Result)
+	go func() {
+		defer close(chResults)
+		if p.ordered {
+			reorder(chProcessed, chResults)
+			return
+		}
+		for r := range chProcessed {
+			chResults <- r.result
+		}
+	}()
+	return chResults
+}
+
+func (p *Pool) dispatch(ctx context.Context, chFunds chan []byte, queues []chan job) {
+	var seq uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case fund, ok := <-chFunds:
+			if !ok {
+				return
+			}
+			queues[p.partition(fund)] <- job{seq: seq, fund: fund}
+			seq++
+		}
+	}
+}
+
+// reorder holds back the results that finished ahead of an earlier fund.
+func reorder(chProcessed chan processed, chResults chan handler.Result) {
+	pending := make(map[uint64]handler.Result)
+	var next uint64
+	for r := range chProcessed {
+		pending[r.seq] = r.result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			chResults <- result
+			next++
+		}
+	}
+}
+
+// partition picks the worker of the customer of the fund. Funds whose
+// customer cannot be read all go to the first worker.
+func (p *Pool) partition(fund []byte) int {
+	var header struct {
+		CustomerID string `json:"customer_id"`
+	}
+	if err := json.Unmarshal(fund, &header); err != nil {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(header.CustomerID))
+	return int(h.Sum32() % uint32(p.workers))
+}
@@ -0,0 +1,132 @@
+package listener_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/handler"
+	"github.com/danielfmelo/load-funds-handler/listener"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowHandler echoes each fund after a random delay and records the order in
+// which the funds of each customer were processed.
+type slowHandler struct {
+	mu        sync.Mutex
+	processed map[string][]string
+}
+
+func (s *slowHandler) Transaction(fund []byte) {}
+
+func (s *slowHandler) Process(fund []byte) handler.Result {
+	var header struct {
+		ID         string `json:"id"`
+		CustomerID string `json:"customer_id"`
+	}
+	if err := json.Unmarshal(fund, &header); err != nil {
+		return handler.Result{Err: fund}
+	}
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	s.mu.Lock()
+	s.processed[header.CustomerID] = append(s.processed[header.CustomerID], header.ID)
+	s.mu.Unlock()
+	return handler.Result{Event: fund}
+}
+
+func (s *slowHandler) Check(fund []byte) handler.Result {
+	return s.Process(fund)
+}
+
+func fakeFunds(customers, loads int) [][]byte {
+	funds := [][]byte{}
+	for l := 0; l < loads; l++ {
+		for c := 0; c < customers; c++ {
+			fund := fmt.Sprintf(`{"id":"%d","customer_id":"%d"}`, l, c)
+			funds = append(funds, []byte(fund))
+		}
+	}
+	return funds
+}
+
+func sendFunds(ch chan []byte, funds [][]byte) {
+	go func() {
+		for _, fund := range funds {
+			ch <- fund
+		}
+		close(ch)
+	}()
+}
+
+func TestPoolShouldRestoreInputOrder(t *testing.T) {
+	h := &slowHandler{processed: map[string][]string{}}
+	funds := fakeFunds(10, 20)
+	funds = append(funds, []byte("with error"))
+	ch := make(chan []byte)
+	pool := listener.NewPool(h, 8, true)
+	results := pool.Receiver(context.Background(), ch)
+	sendFunds(ch, funds)
+	i := 0
+	for result := range results {
+		if result.Err != nil {
+			assert.Equal(t, funds[i], result.Err)
+		} else {
+			assert.Equal(t, funds[i], result.Event)
+		}
+		i++
+	}
+	assert.Equal(t, len(funds), i)
+}
+
+func TestPoolShouldKeepCustomerOrder(t *testing.T) {
+	h := &slowHandler{processed: map[string][]string{}}
+	customers, loads := 10, 20
+	ch := make(chan []byte)
+	pool := listener.NewPool(h, 4, false)
+	results := pool.Receiver(context.Background(), ch)
+	sendFunds(ch, fakeFunds(customers, loads))
+	count := 0
+	for range results {
+		count++
+	}
+	assert.Equal(t, customers*loads, count)
+	for c := 0; c < customers; c++ {
+		ids := h.processed[fmt.Sprint(c)]
+		assert.Len(t, ids, loads)
+		for l, id := range ids {
+			assert.Equal(t, fmt.Sprint(l), id)
+		}
+	}
+}
+
+func TestPoolShouldCloseResultsWithoutFunds(t *testing.T) {
+	suite := newSuite()
+	ch := make(chan []byte)
+	pool := listener.NewPool(suite.handle, 0, true)
+	results := pool.Receiver(context.Background(), ch)
+	close(ch)
+	_, ok := <-results
+	assert.False(t, ok)
+}
+
+func TestPoolShouldDrainReceivedFundsWhenContextIsCancelled(t *testing.T) {
+	h := &slowHandler{processed: map[string][]string{}}
+	funds := fakeFunds(4, 5)
+	ch := make(chan []byte, len(funds))
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := listener.NewPool(h, 2, true)
+	results := pool.Receiver(ctx, ch)
+	ch <- funds[0]
+	ch <- funds[1]
+	assert.Equal(t, funds[0], (<-results).Event)
+	cancel()
+	count := 1
+	for range results {
+		count++
+	}
+	assert.True(t, count >= 1 && count <= 2)
+}
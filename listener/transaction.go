@@ -1,6 +1,10 @@
 package listener
 
-import "github.com/danielfmelo/load-funds-handler/handler"
+import (
+	"context"
+
+	"github.com/danielfmelo/load-funds-handler/handler"
+)
 
 type Transaction struct {
 	handle handler.HandlerTransaction
@@ -12,13 +16,19 @@ func New(handle handler.HandlerTransaction) *Transaction {
 	}
 }
 
-func (t *Transaction) Receiver(chFunds chan []byte) {
-	go func() {
-		for {
-			select {
-			case record := <-chFunds:
-				t.handle.Transaction(record)
+// Receiver handles the funds sent on chFunds one at a time. It returns when
+// chFunds is closed or ctx is cancelled, after the fund being handled at that
+// moment is finished.
+func (t *Transaction) Receiver(ctx context.Context, chFunds chan []byte) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record, ok := <-chFunds:
+			if !ok {
+				return
 			}
+			t.handle.Transaction(record)
 		}
-	}()
+	}
 }
@@ -1,6 +1,7 @@
 package listener_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/danielfmelo/load-funds-handler/handler"
@@ -23,6 +24,23 @@ func TestReceiver(t *testing.T) {
 	suite.handle.On("Transaction", record).Return().Once()
 	ch := make(chan []byte)
 	lf := listener.New(suite.handle)
-	lf.Receiver(ch)
+	done := make(chan struct{})
+	go func() {
+		lf.Receiver(context.Background(), ch)
+		close(done)
+	}()
 	ch <- record
+	close(ch)
+	<-done
+	suite.handle.AssertExpectations(t)
+}
+
+func TestReceiverShouldReturnWhenContextIsCancelled(t *testing.T) {
+	suite := newSuite()
+	ch := make(chan []byte)
+	lf := listener.New(suite.handle)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lf.Receiver(ctx, ch)
+	suite.handle.AssertNotCalled(t, "Transaction")
 }
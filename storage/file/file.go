@@ -0,0 +1,791 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/storage"
+	"github.com/danielfmelo/load-funds-handler/storage/memory"
+)
+
+const (
+	logFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+
+	DefaultSnapshotEvery = 10000
+	DefaultSyncInterval  = time.Second
+)
+
+var ErrCorruptLog = errors.New("corrupt write-ahead log")
+
+// SyncPolicy selects when the log is flushed to disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes the log after every write, so a write that returned
+	// survives a power loss.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes the log periodically, so a power loss can lose the
+	// writes of the last interval. Once a flush fails, the writes after it
+	// and Close fail with its error.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNone leaves flushing to the operating system. Writes still survive
+	// the process crashing.
+	SyncNone SyncPolicy = "none"
+)
+
+func (p SyncPolicy) Validate() error {
+	if p != SyncAlways && p != SyncInterval && p != SyncNone {
+		return fmt.Errorf("unknown sync policy %q", p)
+	}
+	return nil
+}
+
+// Database keeps its state in memory and makes it durable with a
+// write-ahead log in a directory. Every write is appended to the log before
+// it is applied, and every so many writes the whole state is written to a
+// snapshot and the log is started over. Opening the directory again loads
+// the snapshot and replays the log written after it.
+//
+// Writes are serialized so the log has the same order the writes were
+// applied in; reads go straight to memory.
+type Database struct {
+	mu            sync.Mutex
+	dir           string
+	memory        *memory.Database
+	memoryOpts    []memory.Option
+	log           *os.File
+	size          int64
+	seq           uint64
+	sinceSnapshot int
+	snapshotEvery int
+	syncPolicy    SyncPolicy
+	syncInterval  time.Duration
+	done          chan struct{}
+	wg            sync.WaitGroup
+	// syncErr is the first error of a periodic sync, after which the log
+	// cannot be trusted to reach the disk.
+	syncErr   error
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type Option func(d *Database)
+
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(d *Database) {
+		d.syncPolicy = policy
+	}
+}
+
+// WithSyncInterval sets how often the log is flushed with SyncInterval.
+func WithSyncInterval(interval time.Duration) Option {
+	return func(d *Database) {
+		if interval > 0 {
+			d.syncInterval = interval
+		}
+	}
+}
+
+// WithSnapshotEvery sets after how many writes a snapshot is taken.
+func WithSnapshotEvery(writes int) Option {
+	return func(d *Database) {
+		if writes > 0 {
+			d.snapshotEvery = writes
+		}
+	}
+}
+
+// WithRetention sets for how many days windows are kept.
+func WithRetention(days int) Option {
+	return func(d *Database) {
+		d.memoryOpts = append(d.memoryOpts, memory.WithRetention(days))
+	}
+}
+
+// record is one write in the log. Seq grows by one with every write and is
+// kept across snapshots, so records already in a snapshot are skipped.
+type record struct {
+	Seq         uint64                          `json:"seq"`
+	Op          string                          `json:"op"`
+	Transaction *domain.Transaction             `json:"transaction,omitempty"`
+	CustomerID  string                          `json:"customer_id,omitempty"`
+	Day         string                          `json:"day,omitempty"`
+	Daily       *domain.DailyTransaction        `json:"daily,omitempty"`
+	Week        *domain.WeeklyTransaction       `json:"week,omitempty"`
+	Weekly      *domain.WeeklyTransactionTotal  `json:"weekly,omitempty"`
+	Month       *domain.MonthlyTransaction      `json:"month,omitempty"`
+	Monthly     *domain.MonthlyTransactionTotal `json:"monthly,omitempty"`
+	Year        *domain.YearlyTransaction       `json:"year,omitempty"`
+	Yearly      *domain.YearlyTransactionTotal  `json:"yearly,omitempty"`
+	Profile     *domain.CustomerProfile         `json:"profile,omitempty"`
+	ID          string                          `json:"id,omitempty"`
+	Decision    *domain.TransactionDecision     `json:"decision,omitempty"`
+	ReversedBy  string                          `json:"reversed_by,omitempty"`
+	Since       *time.Time                      `json:"since,omitempty"`
+	Recent      []domain.LoadEvent              `json:"recent,omitempty"`
+	Entries     []domain.LedgerEntry            `json:"entries,omitempty"`
+	Applied     *bool                           `json:"applied,omitempty"`
+	Kind        domain.TransactionType          `json:"kind,omitempty"`
+}
+
+const (
+	opTransaction = "transaction"
+	opWindows     = "windows"
+	opProfile     = "profile"
+	opDecision    = "decision"
+	opReversal    = "reversal"
+	opUnreversal  = "unreversal"
+	opLedger      = "ledger"
+)
+
+type snapshot struct {
+	Seq   uint64          `json:"seq"`
+	State memory.Snapshot `json:"state"`
+}
+
+// Open loads the database kept in dir, creating dir when missing. A record
+// cut short at the end of the log, as left by a crash in the middle of a
+// write, is dropped; any other damage fails with ErrCorruptLog.
+func Open(dir string, opts ...Option) (*Database, error) {
+	d := &Database{
+		dir:           dir,
+		snapshotEvery: DefaultSnapshotEvery,
+		syncPolicy:    SyncAlways,
+		syncInterval:  DefaultSyncInterval,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.syncPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	d.memory = memory.New(d.memoryOpts...)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error to create data dir %s: %w", dir, err)
+	}
+	if err := d.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error to open log: %w", err)
+	}
+	d.log = log
+	if err := d.replay(); err != nil {
+		log.Close()
+		return nil, err
+	}
+	if d.syncPolicy == SyncInterval {
+		d.wg.Add(1)
+		go d.syncPeriodically()
+	}
+	return d, nil
+}
+
+func (d *Database) loadSnapshot() error {
+	raw, err := ioutil.ReadFile(filepath.Join(d.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error to read snapshot: %w", err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return fmt.Errorf("error to parse snapshot: %w", err)
+	}
+	d.memory.Restore(snap.State)
+	d.seq = snap.Seq
+	return nil
+}
+
+// replay applies the records of the log written after the snapshot and
+// leaves the log positioned after the last whole record.
+func (d *Database) replay() error {
+	raw, err := ioutil.ReadAll(d.log)
+	if err != nil {
+		return fmt.Errorf("error to read log: %w", err)
+	}
+	var offset int64
+	for line := 1; len(raw) > 0; line++ {
+		end := bytes.IndexByte(raw, '\n')
+		if end < 0 {
+			break
+		}
+		rec, err := decodeRecord(raw[:end])
+		if err != nil {
+			if end+1 == len(raw) {
+				break
+			}
+			return fmt.Errorf("%w: line %d: %s", ErrCorruptLog, line, err)
+		}
+		if rec.Seq > d.seq {
+			if err := d.apply(rec); err != nil {
+				return fmt.Errorf("error to replay log line %d: %w", line, err)
+			}
+			d.seq = rec.Seq
+			d.sinceSnapshot++
+		}
+		offset += int64(end + 1)
+		raw = raw[end+1:]
+	}
+	if err := d.log.Truncate(offset); err != nil {
+		return fmt.Errorf("error to truncate log: %w", err)
+	}
+	if _, err := d.log.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error to seek log: %w", err)
+	}
+	d.size = offset
+	return nil
+}
+
+func (d *Database) apply(rec record) error {
+	switch rec.Op {
+	case opTransaction:
+		if rec.Transaction == nil {
+			return fmt.Errorf("%w: transaction record without transaction", ErrCorruptLog)
+		}
+		err := d.memory.AddTransaction(*rec.Transaction)
+		if err == domain.ErrTransactionAlreadyExist {
+			return nil
+		}
+		return err
+	case opWindows:
+		if rec.Kind != "" {
+			return d.applyCounters(rec)
+		}
+		if rec.Daily != nil {
+			d.memory.AddDailyTransaction(rec.CustomerID, rec.Day, *rec.Daily)
+		}
+		if rec.Week != nil && rec.Weekly != nil {
+			d.memory.AddWeeklyTransaction(rec.CustomerID, *rec.Week, *rec.Weekly)
+		}
+		if rec.Month != nil && rec.Monthly != nil {
+			d.memory.AddMonthlyTransaction(rec.CustomerID, *rec.Month, *rec.Monthly)
+		}
+		if rec.Year != nil && rec.Yearly != nil {
+			d.memory.AddYearlyTransaction(rec.CustomerID, *rec.Year, *rec.Yearly)
+		}
+		if rec.Since != nil {
+			d.memory.SetLoadHistory(rec.CustomerID, *rec.Since, rec.Recent)
+		}
+		if rec.Applied != nil {
+			d.memory.SetApplied(rec.CustomerID, rec.ID, *rec.Applied)
+		}
+		return nil
+	case opDecision:
+		if rec.Decision == nil {
+			return fmt.Errorf("%w: decision record without decision", ErrCorruptLog)
+		}
+		return d.memory.SetDecision(rec.CustomerID, rec.ID, *rec.Decision)
+	case opReversal:
+		return d.memory.MarkReversed(rec.CustomerID, rec.ID, rec.ReversedBy)
+	case opUnreversal:
+		return d.memory.UnmarkReversed(rec.CustomerID, rec.ID, rec.ReversedBy)
+	case opLedger:
+		return d.memory.PostEntries(rec.CustomerID, rec.ID, rec.Entries)
+	case opProfile:
+		if rec.Profile == nil {
+			return fmt.Errorf("%w: profile record without profile", ErrCorruptLog)
+		}
+		return d.memory.SetCustomerProfile(*rec.Profile)
+	}
+	return fmt.Errorf("%w: unknown operation %q", ErrCorruptLog, rec.Op)
+}
+
+// applyCounters replays the windows of a withdrawal or spend. Only
+// UpdateCustomerState writes those, always all four windows at once.
+func (d *Database) applyCounters(rec record) error {
+	if rec.Daily == nil || rec.Week == nil || rec.Weekly == nil || rec.Month == nil || rec.Monthly == nil || rec.Year == nil || rec.Yearly == nil {
+		return fmt.Errorf("%w: %s windows record without all windows", ErrCorruptLog, rec.Kind)
+	}
+	windows := domain.Windows{
+		Day:           rec.Day,
+		Week:          *rec.Week,
+		Month:         *rec.Month,
+		Year:          *rec.Year,
+		TransactionID: rec.ID,
+		Kind:          rec.Kind,
+	}
+	state := domain.CustomerState{
+		Daily:   *rec.Daily,
+		Weekly:  *rec.Weekly,
+		Monthly: *rec.Monthly,
+		Yearly:  *rec.Yearly,
+		Recent:  rec.Recent,
+	}
+	if rec.Since != nil {
+		windows.Since = *rec.Since
+	}
+	if rec.Applied != nil {
+		state.Applied = *rec.Applied
+	}
+	return d.memory.UpdateCustomerState(rec.CustomerID, windows, func(domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state, true, nil
+	})
+}
+
+// encodeRecord writes a record as its CRC-32 in hex, a space and its JSON.
+func encodeRecord(rec record) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+	return []byte(line), nil
+}
+
+func decodeRecord(line []byte) (record, error) {
+	var rec record
+	if len(line) < 10 || line[8] != ' ' {
+		return rec, errors.New("missing checksum")
+	}
+	checksum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil {
+		return rec, errors.New("invalid checksum")
+	}
+	payload := line[9:]
+	if uint32(checksum) != crc32.ChecksumIEEE(payload) {
+		return rec, errors.New("checksum mismatch")
+	}
+	err = json.Unmarshal(payload, &rec)
+	return rec, err
+}
+
+// append writes rec to the log, undoing a partial write on failure so the
+// log never has a damaged record before a whole one. It must be called with
+// mu held.
+func (d *Database) append(rec record) error {
+	if d.syncErr != nil {
+		return d.syncErr
+	}
+	rec.Seq = d.seq + 1
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return fmt.Errorf("error to encode log record: %w", err)
+	}
+	if _, err := d.log.Write(line); err != nil {
+		d.rewind()
+		return fmt.Errorf("error to write log: %w", err)
+	}
+	if d.syncPolicy == SyncAlways {
+		if err := d.log.Sync(); err != nil {
+			d.rewind()
+			return fmt.Errorf("error to sync log: %w", err)
+		}
+	}
+	d.size += int64(len(line))
+	d.seq = rec.Seq
+	d.sinceSnapshot++
+	return nil
+}
+
+func (d *Database) rewind() {
+	if err := d.log.Truncate(d.size); err == nil {
+		d.log.Seek(d.size, io.SeekStart)
+	}
+}
+
+// maybeSnapshot takes a snapshot once enough writes were logged. A failed
+// snapshot is retried after the next write while the log keeps every
+// record, so it does not fail the write that triggered it. It must be
+// called with mu held and no shard lock held.
+func (d *Database) maybeSnapshot() {
+	if d.sinceSnapshot >= d.snapshotEvery {
+		d.snapshot()
+	}
+}
+
+// Snapshot writes the whole state to the snapshot and starts the log over.
+func (d *Database) Snapshot() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshot()
+}
+
+func (d *Database) snapshot() error {
+	raw, err := json.Marshal(snapshot{Seq: d.seq, State: d.memory.Snapshot()})
+	if err != nil {
+		return fmt.Errorf("error to encode snapshot: %w", err)
+	}
+	path := filepath.Join(d.dir, snapshotFile)
+	if err := writeFileSync(path+".tmp", raw); err != nil {
+		return fmt.Errorf("error to write snapshot: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error to write snapshot: %w", err)
+	}
+	if err := syncDir(d.dir); err != nil {
+		return fmt.Errorf("error to write snapshot: %w", err)
+	}
+	// Records left in the log by a crash from here on are already in the
+	// snapshot and are skipped by their sequence.
+	if err := d.log.Truncate(0); err != nil {
+		return fmt.Errorf("error to truncate log: %w", err)
+	}
+	if _, err := d.log.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error to seek log: %w", err)
+	}
+	d.size = 0
+	d.sinceSnapshot = 0
+	return d.log.Sync()
+}
+
+func writeFileSync(path string, raw []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(raw); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func (d *Database) syncPeriodically() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			if err := d.log.Sync(); err != nil && d.syncErr == nil {
+				d.syncErr = fmt.Errorf("error to sync log: %w", err)
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// Close flushes the log to disk and closes it, failing with the error of an
+// earlier periodic sync if one failed. Calling it again returns the same
+// error.
+func (d *Database) Close() error {
+	d.closeOnce.Do(func() {
+		d.closeErr = d.close()
+	})
+	return d.closeErr
+}
+
+func (d *Database) close() error {
+	close(d.done)
+	d.wg.Wait()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.log.Sync(); err != nil {
+		d.log.Close()
+		return fmt.Errorf("error to sync log: %w", err)
+	}
+	if d.syncErr != nil {
+		d.log.Close()
+		return d.syncErr
+	}
+	return d.log.Close()
+}
+
+func (d *Database) AddTransaction(transaction domain.Transaction) error {
+	if transaction.ID == "" {
+		return domain.ErrTransactionEmptyID
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.memory.GetTransaction(transaction.CustomerID, transaction.ID); err == nil {
+		return domain.ErrTransactionAlreadyExist
+	}
+	if err := d.append(record{Op: opTransaction, Transaction: &transaction}); err != nil {
+		return err
+	}
+	if err := d.memory.AddTransaction(transaction); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) GetTransaction(customerID, id string) (domain.Transaction, error) {
+	return d.memory.GetTransaction(customerID, id)
+}
+
+func (d *Database) SetDecision(customerID, id string, decision domain.TransactionDecision) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.memory.GetTransaction(customerID, id); err != nil {
+		return err
+	}
+	if err := d.append(record{Op: opDecision, CustomerID: customerID, ID: id, Decision: &decision}); err != nil {
+		return err
+	}
+	if err := d.memory.SetDecision(customerID, id, decision); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+// UnmarkReversed only logs when the transaction is marked with reversalID.
+func (d *Database) UnmarkReversed(customerID, id, reversalID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	transaction, err := d.memory.GetTransaction(customerID, id)
+	if err != nil || transaction.ReversedBy != reversalID {
+		return err
+	}
+	if err := d.append(record{Op: opUnreversal, CustomerID: customerID, ID: id, ReversedBy: reversalID}); err != nil {
+		return err
+	}
+	if err := d.memory.UnmarkReversed(customerID, id, reversalID); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) GetDecision(customerID, id string) (domain.TransactionDecision, error) {
+	return d.memory.GetDecision(customerID, id)
+}
+
+// MarkReversed only logs the first mark of the transaction.
+func (d *Database) MarkReversed(customerID, id, reversalID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	transaction, err := d.memory.GetTransaction(customerID, id)
+	if err != nil {
+		return err
+	}
+	switch transaction.ReversedBy {
+	case reversalID:
+		return nil
+	case "":
+	default:
+		return domain.ErrAlreadyReversed
+	}
+	if err := d.append(record{Op: opReversal, CustomerID: customerID, ID: id, ReversedBy: reversalID}); err != nil {
+		return err
+	}
+	if err := d.memory.MarkReversed(customerID, id, reversalID); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) AddDailyTransaction(customerID, day string, daily domain.DailyTransaction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.append(record{Op: opWindows, CustomerID: customerID, Day: day, Daily: &daily}); err != nil {
+		return err
+	}
+	if err := d.memory.AddDailyTransaction(customerID, day, daily); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) AddWeeklyTransaction(customerID string, week domain.WeeklyTransaction, total domain.WeeklyTransactionTotal) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.append(record{Op: opWindows, CustomerID: customerID, Week: &week, Weekly: &total}); err != nil {
+		return err
+	}
+	if err := d.memory.AddWeeklyTransaction(customerID, week, total); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) AddMonthlyTransaction(customerID string, month domain.MonthlyTransaction, total domain.MonthlyTransactionTotal) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.append(record{Op: opWindows, CustomerID: customerID, Month: &month, Monthly: &total}); err != nil {
+		return err
+	}
+	if err := d.memory.AddMonthlyTransaction(customerID, month, total); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) AddYearlyTransaction(customerID string, year domain.YearlyTransaction, total domain.YearlyTransactionTotal) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.append(record{Op: opWindows, CustomerID: customerID, Year: &year, Yearly: &total}); err != nil {
+		return err
+	}
+	if err := d.memory.AddYearlyTransaction(customerID, year, total); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) GetDailyTransaction(customerID, day string) (domain.DailyTransaction, error) {
+	return d.memory.GetDailyTransaction(customerID, day)
+}
+
+func (d *Database) GetWeeklyTransaction(customerID string, week domain.WeeklyTransaction) (domain.WeeklyTransactionTotal, error) {
+	return d.memory.GetWeeklyTransaction(customerID, week)
+}
+
+func (d *Database) GetMonthlyTransaction(customerID string, month domain.MonthlyTransaction) (domain.MonthlyTransactionTotal, error) {
+	return d.memory.GetMonthlyTransaction(customerID, month)
+}
+
+func (d *Database) GetYearlyTransaction(customerID string, year domain.YearlyTransaction) (domain.YearlyTransactionTotal, error) {
+	return d.memory.GetYearlyTransaction(customerID, year)
+}
+
+func (d *Database) ListDailyTransactions(customerID string, from, to time.Time) ([]domain.DailyWindow, error) {
+	return d.memory.ListDailyTransactions(customerID, from, to)
+}
+
+func (d *Database) ListWeeklyTransactions(customerID string, from, to time.Time) ([]domain.WeeklyWindow, error) {
+	return d.memory.ListWeeklyTransactions(customerID, from, to)
+}
+
+// UpdateCustomerState logs the state returned by update before it is
+// committed; when the log cannot be written nothing is committed.
+func (d *Database) UpdateCustomerState(customerID string, windows domain.Windows, update storage.StateUpdate) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logged := func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		state, commit, err := update(state)
+		if err != nil || !commit {
+			return state, commit, err
+		}
+		rec := record{
+			Op:         opWindows,
+			CustomerID: customerID,
+			Day:        windows.Day,
+			Daily:      &state.Daily,
+			Week:       &windows.Week,
+			Weekly:     &state.Weekly,
+			Month:      &windows.Month,
+			Monthly:    &state.Monthly,
+			Year:       &windows.Year,
+			Yearly:     &state.Yearly,
+			Kind:       windows.Kind,
+		}
+		if !windows.Since.IsZero() {
+			rec.Since = &windows.Since
+			rec.Recent = state.Recent
+		}
+		if windows.TransactionID != "" {
+			rec.ID = windows.TransactionID
+			rec.Applied = &state.Applied
+		}
+		if err := d.append(rec); err != nil {
+			return state, false, err
+		}
+		return state, true, nil
+	}
+	if err := d.memory.UpdateCustomerState(customerID, windows, logged); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+// PostEntries only logs the first posting of the transaction.
+func (d *Database) PostEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	return d.post(customerID, transactionID, entries, false)
+}
+
+// PostFundedEntries checks the balance before logging, so entries refused
+// for insufficient funds never reach the log.
+func (d *Database) PostFundedEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	return d.post(customerID, transactionID, entries, true)
+}
+
+func (d *Database) post(customerID, transactionID string, entries []domain.LedgerEntry, funded bool) error {
+	if !domain.Balanced(entries) {
+		return domain.ErrUnbalancedEntries
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	posted, err := d.memory.Posted(customerID, transactionID)
+	if err != nil || posted {
+		return err
+	}
+	if funded {
+		balance, err := d.memory.CustomerBalance(customerID)
+		if err != nil {
+			return err
+		}
+		if balance.Add(domain.CustomerBalance(entries, customerID)).IsNegative() {
+			return domain.ErrInsufficientFunds
+		}
+	}
+	if err := d.append(record{Op: opLedger, CustomerID: customerID, ID: transactionID, Entries: entries}); err != nil {
+		return err
+	}
+	if err := d.memory.PostEntries(customerID, transactionID, entries); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
+
+func (d *Database) Posted(customerID, transactionID string) (bool, error) {
+	return d.memory.Posted(customerID, transactionID)
+}
+
+func (d *Database) ListEntries(customerID string) ([]domain.LedgerEntry, error) {
+	return d.memory.ListEntries(customerID)
+}
+
+func (d *Database) TrialBalance() ([]domain.AccountBalance, error) {
+	return d.memory.TrialBalance()
+}
+
+func (d *Database) GetCustomerProfile(customerID string) (domain.CustomerProfile, error) {
+	return d.memory.GetCustomerProfile(customerID)
+}
+
+func (d *Database) SetCustomerProfile(profile domain.CustomerProfile) error {
+	if profile.CustomerID == "" {
+		return domain.ErrCustomerEmptyID
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.append(record{Op: opProfile, Profile: &profile}); err != nil {
+		return err
+	}
+	if err := d.memory.SetCustomerProfile(profile); err != nil {
+		return err
+	}
+	d.maybeSnapshot()
+	return nil
+}
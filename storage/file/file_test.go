@@ -0,0 +1,414 @@
+package file_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/storage/file"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	fakeTime    = time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	fakeWindows = domain.Windows{
+		Day:   "2000-01-03",
+		Week:  domain.WeeklyTransaction{Year: 2000, Week: 1},
+		Month: domain.MonthlyTransaction{Year: 2000, Month: time.January},
+		Year:  domain.YearlyTransaction{Year: 2000},
+	}
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "load-funds-file")
+	assert.Nil(t, err)
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func open(t *testing.T, dir string, opts ...file.Option) *file.Database {
+	d, err := file.Open(dir, opts...)
+	assert.Nil(t, err)
+	return d
+}
+
+func fakeTransaction(customerID, id string) domain.Transaction {
+	return domain.Transaction{ID: id, CustomerID: customerID, LoadAmount: domain.NewMoney(100), Time: fakeTime}
+}
+
+// load adds the transaction and commits one more load of it to the state.
+func load(t *testing.T, d *file.Database, transaction domain.Transaction) {
+	assert.Nil(t, d.AddTransaction(transaction))
+	err := d.UpdateCustomerState(transaction.CustomerID, fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state.Apply(domain.StateDelta{
+			DailyAmount:   transaction.LoadAmount,
+			DailyCount:    1,
+			WeeklyAmount:  transaction.LoadAmount,
+			MonthlyAmount: transaction.LoadAmount,
+			YearlyAmount:  transaction.LoadAmount,
+		}), true, nil
+	})
+	assert.Nil(t, err)
+}
+
+func assertLoads(t *testing.T, d *file.Database, customerID string, loads int) {
+	daily, err := d.GetDailyTransaction(customerID, fakeWindows.Day)
+	assert.Nil(t, err)
+	assert.Equal(t, loads, daily.TransactionCount)
+	assert.Equal(t, domain.NewMoney(int64(100*loads)), daily.DailyTotal)
+	weekly, err := d.GetWeeklyTransaction(customerID, fakeWindows.Week)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(int64(100*loads)), weekly.Value)
+	monthly, err := d.GetMonthlyTransaction(customerID, fakeWindows.Month)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(int64(100*loads)), monthly.Value)
+	yearly, err := d.GetYearlyTransaction(customerID, fakeWindows.Year)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(int64(100*loads)), yearly.Value)
+}
+
+func logLines(t *testing.T, dir string) []string {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "wal.log"))
+	assert.Nil(t, err)
+	if len(raw) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+}
+
+func TestOpenShouldRecoverState(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	load(t, d, fakeTransaction("1", "1"))
+	load(t, d, fakeTransaction("1", "2"))
+	load(t, d, fakeTransaction("2", "1"))
+	assert.Nil(t, d.SetCustomerProfile(domain.CustomerProfile{CustomerID: "1", Tier: domain.TierPremium}))
+	decision := domain.TransactionDecision{Reason: domain.ReasonDailyCountExceeded}
+	assert.Equal(t, domain.ErrNotFound, d.SetDecision("1", "3", decision))
+	assert.Nil(t, d.SetDecision("1", "2", decision))
+	assert.Nil(t, d.Close())
+
+	d = open(t, dir)
+	defer d.Close()
+	assertLoads(t, d, "1", 2)
+	assertLoads(t, d, "2", 1)
+	transaction, err := d.GetTransaction("1", "2")
+	assert.Nil(t, err)
+	assert.True(t, fakeTime.Equal(transaction.Time))
+	assert.Equal(t, domain.ErrTransactionAlreadyExist, d.AddTransaction(fakeTransaction("1", "1")))
+	profile, err := d.GetCustomerProfile("1")
+	assert.Nil(t, err)
+	assert.Equal(t, domain.TierPremium, profile.Tier)
+	decision, err = d.GetDecision("1", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, domain.ReasonDailyCountExceeded, decision.Reason)
+	_, err = d.GetDecision("1", "1")
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestUpdateCustomerStateShouldOnlyLogCommittedStates(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	defer d.Close()
+	err := d.UpdateCustomerState("1", fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state, false, nil
+	})
+	assert.Nil(t, err)
+	fakeErr := errors.New("some error")
+	err = d.UpdateCustomerState("1", fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state, true, fakeErr
+	})
+	assert.Equal(t, fakeErr, err)
+	assert.Empty(t, logLines(t, dir))
+	_, err = d.GetDailyTransaction("1", fakeWindows.Day)
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestOpenShouldRecoverReversal(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	load(t, d, fakeTransaction("1", "1"))
+	assert.Nil(t, d.MarkReversed("1", "1", "2"))
+	assert.Nil(t, d.MarkReversed("1", "1", "2"))
+	assert.Len(t, logLines(t, dir), 3)
+	assert.Nil(t, d.Close())
+
+	d = open(t, dir)
+	defer d.Close()
+	transaction, err := d.GetTransaction("1", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", transaction.ReversedBy)
+	assert.Equal(t, domain.ErrAlreadyReversed, d.MarkReversed("1", "1", "3"))
+	assert.Equal(t, domain.ErrNotFound, d.MarkReversed("1", "4", "3"))
+}
+
+func TestOpenShouldRecoverUnmarkedReversal(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	load(t, d, fakeTransaction("1", "1"))
+	assert.Nil(t, d.MarkReversed("1", "1", "2"))
+	assert.Nil(t, d.UnmarkReversed("1", "1", "3"))
+	assert.Nil(t, d.UnmarkReversed("1", "1", "2"))
+	assert.Len(t, logLines(t, dir), 4)
+	assert.Nil(t, d.Close())
+
+	d = open(t, dir)
+	defer d.Close()
+	transaction, err := d.GetTransaction("1", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "", transaction.ReversedBy)
+	assert.Nil(t, d.MarkReversed("1", "1", "3"))
+}
+
+func TestOpenShouldRecoverLedger(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir, file.WithSnapshotEvery(3))
+	for i := 0; i < 3; i++ {
+		transaction := fakeTransaction("1", strconv.Itoa(i))
+		assert.Nil(t, d.PostEntries("1", transaction.ID, domain.LoadEntries(transaction)))
+		assert.Nil(t, d.PostEntries("1", transaction.ID, domain.LoadEntries(transaction)))
+	}
+	spend := fakeTransaction("1", "3")
+	spend.Type = domain.TransactionSpend
+	assert.Nil(t, d.PostFundedEntries("1", spend.ID, domain.Entries(spend)))
+	withdrawal := fakeTransaction("1", "4")
+	withdrawal.Type, withdrawal.LoadAmount = domain.TransactionWithdrawal, domain.NewMoney(1000)
+	assert.Equal(t, domain.ErrInsufficientFunds, d.PostFundedEntries("1", withdrawal.ID, domain.Entries(withdrawal)))
+	assert.Nil(t, d.Close())
+
+	d = open(t, dir, file.WithSnapshotEvery(3))
+	defer d.Close()
+	entries, err := d.ListEntries("1")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 8)
+	balances, err := d.TrialBalance()
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.AccountBalance{
+		{Account: domain.CustomerAccount("1"), Debit: domain.NewMoney(100), Credit: domain.NewMoney(300)},
+		{Account: domain.SettlementAccount, Debit: domain.NewMoney(300), Credit: domain.NewMoney(100)},
+	}, balances)
+}
+
+func TestOpenShouldRecoverLoadHistory(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	windows := fakeWindows
+	windows.Since = fakeTime.Add(-24 * time.Hour)
+	event := domain.LoadEvent{ID: "1", Time: fakeTime, Amount: domain.NewMoney(100)}
+	err := d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state.Record(event), true, nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Close())
+
+	d = open(t, dir)
+	defer d.Close()
+	err = d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		assert.Len(t, state.Recent, 1)
+		assert.Equal(t, "1", state.Recent[0].ID)
+		return state, false, nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestOpenShouldRecoverApplied(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	for _, id := range []string{"1", "2"} {
+		windows := fakeWindows
+		windows.TransactionID = id
+		err := d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			state.Applied = id == "1"
+			return state, true, nil
+		})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, d.Close())
+
+	d = open(t, dir)
+	defer d.Close()
+	for _, id := range []string{"1", "2"} {
+		windows := fakeWindows
+		windows.TransactionID = id
+		err := d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Equal(t, id == "1", state.Applied)
+			return state, false, nil
+		})
+		assert.Nil(t, err)
+	}
+}
+
+func TestOpenShouldRecoverCountersOfEachKind(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	count := func(d *file.Database, kind domain.TransactionType, expected int) {
+		windows := fakeWindows
+		windows.TransactionID = strconv.Itoa(expected)
+		windows.Kind = kind
+		err := d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Equal(t, expected, state.Daily.TransactionCount)
+			assert.False(t, state.Applied)
+			state.Daily.TransactionCount++
+			state.Applied = true
+			return state, true, nil
+		})
+		assert.Nil(t, err)
+	}
+	count(d, domain.TransactionWithdrawal, 0)
+	count(d, domain.TransactionWithdrawal, 1)
+	assert.Nil(t, d.Close())
+
+	d = open(t, dir)
+	defer d.Close()
+	count(d, domain.TransactionWithdrawal, 2)
+	_, err := d.GetDailyTransaction("1", fakeWindows.Day)
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestOpenShouldRecoverFromSnapshotAndLog(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir, file.WithSnapshotEvery(4))
+	for i := 0; i < 5; i++ {
+		load(t, d, fakeTransaction("1", strconv.Itoa(i)))
+	}
+	assert.Nil(t, d.Close())
+	_, err := os.Stat(filepath.Join(dir, "snapshot.json"))
+	assert.Nil(t, err)
+	assert.Len(t, logLines(t, dir), 2)
+
+	d = open(t, dir, file.WithSnapshotEvery(4))
+	defer d.Close()
+	assertLoads(t, d, "1", 5)
+	for i := 0; i < 5; i++ {
+		_, err := d.GetTransaction("1", strconv.Itoa(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestOpenShouldSkipRecordsAlreadyInSnapshot(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	load(t, d, fakeTransaction("1", "1"))
+	load(t, d, fakeTransaction("1", "2"))
+	logged, err := ioutil.ReadFile(filepath.Join(dir, "wal.log"))
+	assert.Nil(t, err)
+	assert.Nil(t, d.Snapshot())
+	assert.Nil(t, d.Close())
+	// A crash right after the snapshot was written leaves the old log behind.
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "wal.log"), logged, 0600))
+
+	d = open(t, dir)
+	defer d.Close()
+	assertLoads(t, d, "1", 2)
+}
+
+func TestOpenShouldDropTornRecord(t *testing.T) {
+	testCases := []struct {
+		name string
+		tail string
+	}{
+		{
+			name: "without newline",
+			tail: `1234abcd {"seq":3,"op":"tran`,
+		},
+		{
+			name: "with bad checksum",
+			tail: `1234abcd {"seq":3,"op":"transaction"}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			d := open(t, dir)
+			load(t, d, fakeTransaction("1", "1"))
+			assert.Nil(t, d.Close())
+			log, err := os.OpenFile(filepath.Join(dir, "wal.log"), os.O_APPEND|os.O_WRONLY, 0600)
+			assert.Nil(t, err)
+			_, err = log.WriteString(tc.tail)
+			assert.Nil(t, err)
+			assert.Nil(t, log.Close())
+
+			d = open(t, dir)
+			assertLoads(t, d, "1", 1)
+			load(t, d, fakeTransaction("1", "2"))
+			assert.Nil(t, d.Close())
+
+			d = open(t, dir)
+			defer d.Close()
+			assertLoads(t, d, "1", 2)
+		})
+	}
+}
+
+func TestOpenShouldFailOnCorruptLog(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir)
+	load(t, d, fakeTransaction("1", "1"))
+	load(t, d, fakeTransaction("1", "2"))
+	assert.Nil(t, d.Close())
+	lines := logLines(t, dir)
+	lines[1] = strings.Replace(lines[1], `"seq"`, `"sEq"`, 1)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "wal.log"), []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+	_, err := file.Open(dir)
+	assert.True(t, errors.Is(err, file.ErrCorruptLog))
+}
+
+func TestOpenWithSyncPolicy(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      file.SyncPolicy
+		errExpected bool
+	}{
+		{name: "always", policy: file.SyncAlways},
+		{name: "interval", policy: file.SyncInterval},
+		{name: "none", policy: file.SyncNone},
+		{name: "unknown", policy: "sometimes", errExpected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			d, err := file.Open(dir, file.WithSyncPolicy(tc.policy), file.WithSyncInterval(time.Millisecond))
+			if tc.errExpected {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			load(t, d, fakeTransaction("1", "1"))
+			time.Sleep(5 * time.Millisecond)
+			assert.Nil(t, d.Close())
+			d = open(t, dir)
+			defer d.Close()
+			assertLoads(t, d, "1", 1)
+		})
+	}
+}
+
+func TestCloseShouldBeSafeToCallTwice(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	d := open(t, dir, file.WithSyncPolicy(file.SyncInterval))
+	load(t, d, fakeTransaction("1", "1"))
+	assert.Nil(t, d.Close())
+	assert.Nil(t, d.Close())
+}
@@ -1,62 +1,341 @@
 package memory
 
 import (
-	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/storage"
 )
 
+const (
+	defaultShards = 64
+	// DefaultRetentionDays keeps a little more than a year of windows.
+	DefaultRetentionDays = 400
+)
+
+// Database keeps every customer in one of a fixed number of shards, each
+// guarded by its own lock, so calls for customers in different shards do not
+// contend with each other.
+//
+// All daily, weekly, monthly and yearly windows of a customer are kept until they fall out of
+// the retention period, counted back from the newest window written for that
+// customer rather than from the wall clock, so replaying old input keeps
+// working.
 type Database struct {
-	transactions map[string]map[string]domain.Transaction
-	daily        map[string]map[string]domain.DailyTransaction
-	weekly       map[string]map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal
+	shards        []*shard
+	retentionDays int
+}
+
+type shard struct {
+	mu            sync.RWMutex
+	retentionDays int
+	transactions  map[string]map[string]domain.Transaction
+	decisions     map[string]map[string]domain.TransactionDecision
+	daily         map[counter]map[string]domain.DailyTransaction
+	weekly        map[counter]map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal
+	monthly       map[counter]map[domain.MonthlyTransaction]domain.MonthlyTransactionTotal
+	yearly        map[counter]map[domain.YearlyTransaction]domain.YearlyTransactionTotal
+	history       map[counter][]domain.LoadEvent
+	ledger        map[string][]domain.LedgerEntry
+	posted        map[string]map[string]bool
+	applied       map[string]map[string]bool
+	profiles      map[string]domain.CustomerProfile
+}
+
+// counter identifies the windows and the load history of one kind of
+// transaction of a customer. The counters of loads have the empty kind.
+type counter struct {
+	customerID string
+	kind       domain.TransactionType
+}
+
+type Option func(d *Database)
+
+// WithShards sets the number of shards customers are spread over.
+func WithShards(shards int) Option {
+	return func(d *Database) {
+		if shards > 0 {
+			d.shards = make([]*shard, shards)
+		}
+	}
+}
+
+// WithRetention sets for how many days windows are kept.
+func WithRetention(days int) Option {
+	return func(d *Database) {
+		if days > 0 {
+			d.retentionDays = days
+		}
+	}
 }
 
-func New() *Database {
-	return &Database{
-		transactions: make(map[string]map[string]domain.Transaction),
-		daily:        make(map[string]map[string]domain.DailyTransaction),
-		weekly:       make(map[string]map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal),
+func New(opts ...Option) *Database {
+	d := &Database{shards: make([]*shard, defaultShards), retentionDays: DefaultRetentionDays}
+	for _, opt := range opts {
+		opt(d)
 	}
+	for i := range d.shards {
+		d.shards[i] = &shard{
+			retentionDays: d.retentionDays,
+			transactions:  make(map[string]map[string]domain.Transaction),
+			decisions:     make(map[string]map[string]domain.TransactionDecision),
+			daily:         make(map[counter]map[string]domain.DailyTransaction),
+			weekly:        make(map[counter]map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal),
+			monthly:       make(map[counter]map[domain.MonthlyTransaction]domain.MonthlyTransactionTotal),
+			yearly:        make(map[counter]map[domain.YearlyTransaction]domain.YearlyTransactionTotal),
+			history:       make(map[counter][]domain.LoadEvent),
+			ledger:        make(map[string][]domain.LedgerEntry),
+			posted:        make(map[string]map[string]bool),
+			applied:       make(map[string]map[string]bool),
+			profiles:      make(map[string]domain.CustomerProfile),
+		}
+	}
+	return d
+}
+
+func (d *Database) shard(customerID string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+	return d.shards[h.Sum32()%uint32(len(d.shards))]
 }
 
 func (d *Database) AddTransaction(transaction domain.Transaction) error {
 	if transaction.ID == "" {
 		return domain.ErrTransactionEmptyID
 	}
-	t, ok := d.transactions[transaction.ID]
+	s := d.shard(transaction.CustomerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	customer, ok := s.transactions[transaction.CustomerID]
 	if !ok {
-		d.transactions[transaction.ID] = map[string]domain.Transaction{transaction.CustomerID: transaction}
-		return nil
+		customer = make(map[string]domain.Transaction)
+		s.transactions[transaction.CustomerID] = customer
 	}
-	if _, ok := t[transaction.CustomerID]; !ok {
-		t[transaction.CustomerID] = transaction
-		if transaction.ID == "6928" {
-			fmt.Println("dentro do costumer")
-		}
-		return nil
+	if _, ok := customer[transaction.ID]; ok {
+		return domain.ErrTransactionAlreadyExist
 	}
-	return domain.ErrTransactionAlreadyExist
+	customer[transaction.ID] = transaction
+	return nil
 }
 
-func (d *Database) transactionExist(id string) bool {
-	_, ok := d.transactions[id]
-	return ok
+func (d *Database) GetTransaction(customerID, id string) (domain.Transaction, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	transaction, ok := s.transactions[customerID][id]
+	if !ok {
+		return domain.Transaction{}, domain.ErrNotFound
+	}
+	return transaction, nil
+}
+
+func (d *Database) SetDecision(customerID, id string, decision domain.TransactionDecision) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.transactions[customerID][id]; !ok {
+		return domain.ErrNotFound
+	}
+	s.setDecision(customerID, id, decision)
+	return nil
+}
+
+func (s *shard) setDecision(customerID, id string, decision domain.TransactionDecision) {
+	customer, ok := s.decisions[customerID]
+	if !ok {
+		customer = make(map[string]domain.TransactionDecision)
+		s.decisions[customerID] = customer
+	}
+	customer[id] = decision
+}
+
+func (d *Database) GetDecision(customerID, id string) (domain.TransactionDecision, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	decision, ok := s.decisions[customerID][id]
+	if !ok {
+		return domain.TransactionDecision{}, domain.ErrNotFound
+	}
+	return decision, nil
+}
+
+func (d *Database) MarkReversed(customerID, id, reversalID string) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transaction, ok := s.transactions[customerID][id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	if transaction.ReversedBy != "" && transaction.ReversedBy != reversalID {
+		return domain.ErrAlreadyReversed
+	}
+	transaction.ReversedBy = reversalID
+	s.transactions[customerID][id] = transaction
+	return nil
+}
+
+func (d *Database) UnmarkReversed(customerID, id, reversalID string) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transaction, ok := s.transactions[customerID][id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	if transaction.ReversedBy == reversalID {
+		transaction.ReversedBy = ""
+		s.transactions[customerID][id] = transaction
+	}
+	return nil
 }
 
 func (d *Database) AddDailyTransaction(customerID, day string, daily domain.DailyTransaction) error {
-	//fmt.Println(daily.DailyTotal)
-	d.daily[customerID] = map[string]domain.DailyTransaction{day: daily}
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addDailyTransaction(counter{customerID: customerID}, day, daily)
 	return nil
 }
 
+func (s *shard) addDailyTransaction(key counter, day string, daily domain.DailyTransaction) {
+	customer, ok := s.daily[key]
+	if !ok {
+		customer = make(map[string]domain.DailyTransaction)
+		s.daily[key] = customer
+	}
+	_, exist := customer[day]
+	customer[day] = daily
+	if !exist {
+		s.evictDays(customer, day)
+	}
+}
+
+// evictDays drops the days older than the retention period before newest.
+func (s *shard) evictDays(customer map[string]domain.DailyTransaction, newest string) {
+	newestDay, err := time.Parse(domain.DateLayout, newest)
+	if err != nil {
+		return
+	}
+	cutoff := newestDay.AddDate(0, 0, -s.retentionDays).Format(domain.DateLayout)
+	for day := range customer {
+		if day < cutoff {
+			delete(customer, day)
+		}
+	}
+}
+
 func (d *Database) AddWeeklyTransaction(customerID string, week domain.WeeklyTransaction, total domain.WeeklyTransactionTotal) error {
-	d.weekly[customerID] = map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal{week: total}
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addWeeklyTransaction(counter{customerID: customerID}, week, total)
+	return nil
+}
+
+func (s *shard) addWeeklyTransaction(key counter, week domain.WeeklyTransaction, total domain.WeeklyTransactionTotal) {
+	customer, ok := s.weekly[key]
+	if !ok {
+		customer = make(map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal)
+		s.weekly[key] = customer
+	}
+	_, exist := customer[week]
+	customer[week] = total
+	if !exist {
+		s.evictWeeks(customer, week)
+	}
+}
+
+// evictWeeks drops the weeks that ended before the retention period counted
+// back from the start of newest.
+func (s *shard) evictWeeks(customer map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal, newest domain.WeeklyTransaction) {
+	year, week := newest.Start().AddDate(0, 0, -s.retentionDays).ISOWeek()
+	cutoff := domain.WeeklyTransaction{Year: year, Week: week}
+	for week := range customer {
+		if week.Before(cutoff) {
+			delete(customer, week)
+		}
+	}
+}
+
+func (d *Database) AddMonthlyTransaction(customerID string, month domain.MonthlyTransaction, total domain.MonthlyTransactionTotal) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addMonthlyTransaction(counter{customerID: customerID}, month, total)
 	return nil
 }
 
+func (s *shard) addMonthlyTransaction(key counter, month domain.MonthlyTransaction, total domain.MonthlyTransactionTotal) {
+	customer, ok := s.monthly[key]
+	if !ok {
+		customer = make(map[domain.MonthlyTransaction]domain.MonthlyTransactionTotal)
+		s.monthly[key] = customer
+	}
+	_, exist := customer[month]
+	customer[month] = total
+	if !exist {
+		s.evictMonths(customer, month)
+	}
+}
+
+// evictMonths drops the months that ended before the retention period counted
+// back from the start of newest.
+func (s *shard) evictMonths(customer map[domain.MonthlyTransaction]domain.MonthlyTransactionTotal, newest domain.MonthlyTransaction) {
+	start := newest.Start().AddDate(0, 0, -s.retentionDays)
+	cutoff := domain.MonthlyTransaction{Year: start.Year(), Month: start.Month()}
+	for month := range customer {
+		if month.Before(cutoff) {
+			delete(customer, month)
+		}
+	}
+}
+
+func (d *Database) AddYearlyTransaction(customerID string, year domain.YearlyTransaction, total domain.YearlyTransactionTotal) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addYearlyTransaction(counter{customerID: customerID}, year, total)
+	return nil
+}
+
+func (s *shard) addYearlyTransaction(key counter, year domain.YearlyTransaction, total domain.YearlyTransactionTotal) {
+	customer, ok := s.yearly[key]
+	if !ok {
+		customer = make(map[domain.YearlyTransaction]domain.YearlyTransactionTotal)
+		s.yearly[key] = customer
+	}
+	_, exist := customer[year]
+	customer[year] = total
+	if !exist {
+		s.evictYears(customer, year)
+	}
+}
+
+// evictYears drops the years that ended before the retention period counted
+// back from the start of newest.
+func (s *shard) evictYears(customer map[domain.YearlyTransaction]domain.YearlyTransactionTotal, newest domain.YearlyTransaction) {
+	cutoff := time.Date(newest.Year, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -s.retentionDays).Year()
+	for year := range customer {
+		if year.Year < cutoff {
+			delete(customer, year)
+		}
+	}
+}
+
 func (d *Database) GetDailyTransaction(customerID, day string) (domain.DailyTransaction, error) {
-	customer, ok := d.daily[customerID]
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getDailyTransaction(counter{customerID: customerID}, day)
+}
+
+func (s *shard) getDailyTransaction(key counter, day string) (domain.DailyTransaction, error) {
+	customer, ok := s.daily[key]
 	if !ok {
 		return domain.DailyTransaction{}, domain.ErrNotFound
 	}
@@ -68,7 +347,14 @@ func (d *Database) GetDailyTransaction(customerID, day string) (domain.DailyTran
 }
 
 func (d *Database) GetWeeklyTransaction(customerID string, week domain.WeeklyTransaction) (domain.WeeklyTransactionTotal, error) {
-	customer, ok := d.weekly[customerID]
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getWeeklyTransaction(counter{customerID: customerID}, week)
+}
+
+func (s *shard) getWeeklyTransaction(key counter, week domain.WeeklyTransaction) (domain.WeeklyTransactionTotal, error) {
+	customer, ok := s.weekly[key]
 	if !ok {
 		return domain.WeeklyTransactionTotal{}, domain.ErrNotFound
 	}
@@ -78,3 +364,301 @@ func (d *Database) GetWeeklyTransaction(customerID string, week domain.WeeklyTra
 	}
 	return weeklyTransaction, nil
 }
+
+func (d *Database) GetMonthlyTransaction(customerID string, month domain.MonthlyTransaction) (domain.MonthlyTransactionTotal, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getMonthlyTransaction(counter{customerID: customerID}, month)
+}
+
+func (s *shard) getMonthlyTransaction(key counter, month domain.MonthlyTransaction) (domain.MonthlyTransactionTotal, error) {
+	total, ok := s.monthly[key][month]
+	if !ok {
+		return domain.MonthlyTransactionTotal{}, domain.ErrNotFound
+	}
+	return total, nil
+}
+
+func (d *Database) GetYearlyTransaction(customerID string, year domain.YearlyTransaction) (domain.YearlyTransactionTotal, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getYearlyTransaction(counter{customerID: customerID}, year)
+}
+
+func (s *shard) getYearlyTransaction(key counter, year domain.YearlyTransaction) (domain.YearlyTransactionTotal, error) {
+	total, ok := s.yearly[key][year]
+	if !ok {
+		return domain.YearlyTransactionTotal{}, domain.ErrNotFound
+	}
+	return total, nil
+}
+
+func (d *Database) ListDailyTransactions(customerID string, from, to time.Time) ([]domain.DailyWindow, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	first, last := from.Format(domain.DateLayout), to.Format(domain.DateLayout)
+	windows := []domain.DailyWindow{}
+	for day, daily := range s.daily[counter{customerID: customerID}] {
+		if day >= first && day <= last {
+			windows = append(windows, domain.DailyWindow{Day: day, Daily: daily})
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Day < windows[j].Day })
+	return windows, nil
+}
+
+func (d *Database) ListWeeklyTransactions(customerID string, from, to time.Time) ([]domain.WeeklyWindow, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	year, week := from.ISOWeek()
+	first := domain.WeeklyTransaction{Year: year, Week: week}
+	year, week = to.ISOWeek()
+	last := domain.WeeklyTransaction{Year: year, Week: week}
+	windows := []domain.WeeklyWindow{}
+	for week, total := range s.weekly[counter{customerID: customerID}] {
+		if !week.Before(first) && !last.Before(week) {
+			windows = append(windows, domain.WeeklyWindow{Week: week, Total: total})
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Week.Before(windows[j].Week) })
+	return windows, nil
+}
+
+func (d *Database) UpdateCustomerState(customerID string, windows domain.Windows, update storage.StateUpdate) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := counter{customerID: customerID, kind: windows.Kind}
+	var state domain.CustomerState
+	if daily, err := s.getDailyTransaction(key, windows.Day); err == nil {
+		state.Daily = daily
+	}
+	if weekly, err := s.getWeeklyTransaction(key, windows.Week); err == nil {
+		state.Weekly = weekly
+	}
+	if monthly, err := s.getMonthlyTransaction(key, windows.Month); err == nil {
+		state.Monthly = monthly
+	}
+	if yearly, err := s.getYearlyTransaction(key, windows.Year); err == nil {
+		state.Yearly = yearly
+	}
+	if !windows.Since.IsZero() {
+		state.Recent = s.loadHistory(key, windows.Since)
+	}
+	if windows.TransactionID != "" {
+		state.Applied = s.applied[customerID][windows.TransactionID]
+	}
+	state, commit, err := update(state)
+	if err != nil || !commit {
+		return err
+	}
+	if windows.TransactionID != "" {
+		s.setApplied(customerID, windows.TransactionID, state.Applied)
+	}
+	s.addDailyTransaction(key, windows.Day, state.Daily)
+	s.addWeeklyTransaction(key, windows.Week, state.Weekly)
+	s.addMonthlyTransaction(key, windows.Month, state.Monthly)
+	s.addYearlyTransaction(key, windows.Year, state.Yearly)
+	if !windows.Since.IsZero() {
+		s.setLoadHistory(key, windows.Since, state.Recent)
+	}
+	return nil
+}
+
+// SetApplied records whether the transaction is in the counters of the
+// customer, as UpdateCustomerState does for Windows.TransactionID.
+func (d *Database) SetApplied(customerID, id string, applied bool) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setApplied(customerID, id, applied)
+	return nil
+}
+
+func (s *shard) setApplied(customerID, id string, applied bool) {
+	if !applied {
+		delete(s.applied[customerID], id)
+		return
+	}
+	customer, ok := s.applied[customerID]
+	if !ok {
+		customer = make(map[string]bool)
+		s.applied[customerID] = customer
+	}
+	customer[id] = true
+}
+
+// LoadHistory returns the accepted loads of the customer from since on,
+// oldest first.
+func (d *Database) LoadHistory(customerID string, since time.Time) ([]domain.LoadEvent, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loadHistory(counter{customerID: customerID}, since), nil
+}
+
+// SetLoadHistory replaces the loads of the customer from since on with
+// events, which must be in time order.
+func (d *Database) SetLoadHistory(customerID string, since time.Time, events []domain.LoadEvent) error {
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLoadHistory(counter{customerID: customerID}, since, events)
+	return nil
+}
+
+// searchHistory returns the position of the first load at or after since.
+func searchHistory(history []domain.LoadEvent, since time.Time) int {
+	return sort.Search(len(history), func(i int) bool {
+		return !history[i].Time.Before(since)
+	})
+}
+
+func (s *shard) loadHistory(key counter, since time.Time) []domain.LoadEvent {
+	history := s.history[key]
+	return append([]domain.LoadEvent(nil), history[searchHistory(history, since):]...)
+}
+
+// setLoadHistory also drops the loads older than the retention period before
+// the newest one.
+func (s *shard) setLoadHistory(key counter, since time.Time, events []domain.LoadEvent) {
+	history := s.history[key]
+	i := searchHistory(history, since)
+	history = append(history[:i:i], events...)
+	if len(history) == 0 {
+		delete(s.history, key)
+		return
+	}
+	cutoff := history[len(history)-1].Time.AddDate(0, 0, -s.retentionDays)
+	s.history[key] = history[searchHistory(history, cutoff):]
+}
+
+func (d *Database) PostEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	return d.post(customerID, transactionID, entries, false)
+}
+
+func (d *Database) PostFundedEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	return d.post(customerID, transactionID, entries, true)
+}
+
+func (d *Database) post(customerID, transactionID string, entries []domain.LedgerEntry, funded bool) error {
+	if !domain.Balanced(entries) {
+		return domain.ErrUnbalancedEntries
+	}
+	s := d.shard(customerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.posted[customerID][transactionID] {
+		return nil
+	}
+	if funded && s.customerBalance(customerID).Add(domain.CustomerBalance(entries, customerID)).IsNegative() {
+		return domain.ErrInsufficientFunds
+	}
+	s.postEntries(customerID, transactionID, entries)
+	return nil
+}
+
+// CustomerBalance returns the funds the customer holds in the ledger.
+func (d *Database) CustomerBalance(customerID string) (domain.Money, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.customerBalance(customerID), nil
+}
+
+func (s *shard) customerBalance(customerID string) domain.Money {
+	return domain.CustomerBalance(s.ledger[customerID], customerID)
+}
+
+// Posted reports whether the transaction of the customer was posted.
+func (d *Database) Posted(customerID, transactionID string) (bool, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.posted[customerID][transactionID], nil
+}
+
+// postEntries keeps the entries of the customer in time order, after the
+// entries posted before at the same time.
+func (s *shard) postEntries(customerID, transactionID string, entries []domain.LedgerEntry) {
+	posted, ok := s.posted[customerID]
+	if !ok {
+		posted = make(map[string]bool)
+		s.posted[customerID] = posted
+	}
+	posted[transactionID] = true
+	for _, entry := range entries {
+		ledger := s.ledger[customerID]
+		i := sort.Search(len(ledger), func(i int) bool {
+			return ledger[i].Time.After(entry.Time)
+		})
+		ledger = append(ledger, domain.LedgerEntry{})
+		copy(ledger[i+1:], ledger[i:])
+		ledger[i] = entry
+		s.ledger[customerID] = ledger
+	}
+}
+
+func (d *Database) ListEntries(customerID string) ([]domain.LedgerEntry, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]domain.LedgerEntry{}, s.ledger[customerID]...), nil
+}
+
+// TrialBalance locks one shard at a time, so it is only consistent while no
+// entries are being posted.
+func (d *Database) TrialBalance() ([]domain.AccountBalance, error) {
+	totals := make(map[domain.Account]domain.AccountBalance)
+	for _, s := range d.shards {
+		s.mu.RLock()
+		for _, ledger := range s.ledger {
+			for _, entry := range ledger {
+				totals[entry.Account] = addEntry(totals[entry.Account], entry)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	balances := make([]domain.AccountBalance, 0, len(totals))
+	for account, balance := range totals {
+		balance.Account = account
+		balances = append(balances, balance)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Account < balances[j].Account })
+	return balances, nil
+}
+
+func addEntry(balance domain.AccountBalance, entry domain.LedgerEntry) domain.AccountBalance {
+	if entry.Side == domain.Credit {
+		balance.Credit = balance.Credit.Add(entry.Amount)
+	} else {
+		balance.Debit = balance.Debit.Add(entry.Amount)
+	}
+	return balance
+}
+
+func (d *Database) GetCustomerProfile(customerID string) (domain.CustomerProfile, error) {
+	s := d.shard(customerID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	profile, ok := s.profiles[customerID]
+	if !ok {
+		return domain.CustomerProfile{}, domain.ErrNotFound
+	}
+	return profile, nil
+}
+
+func (d *Database) SetCustomerProfile(profile domain.CustomerProfile) error {
+	if profile.CustomerID == "" {
+		return domain.ErrCustomerEmptyID
+	}
+	s := d.shard(profile.CustomerID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[profile.CustomerID] = profile
+	return nil
+}
@@ -1,6 +1,10 @@
 package memory_test
 
 import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,12 +21,12 @@ func TestAddTransaction(t *testing.T) {
 	}{
 		{
 			name:        "add should work",
-			fund:        domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: "$1", Time: time.Now()},
+			fund:        domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: time.Now()},
 			errExpected: nil,
 		},
 		{
 			name:        "should return empty ID",
-			fund:        domain.Transaction{ID: "", CustomerID: "1234", LoadAmount: "$1", Time: time.Now()},
+			fund:        domain.Transaction{ID: "", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: time.Now()},
 			errExpected: domain.ErrTransactionEmptyID,
 		},
 	}
@@ -40,7 +44,7 @@ func TestAddTransactionShouldWorkWithDifferentCustomers(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$1",
+		LoadAmount: domain.NewMoney(100),
 		Time:       time.Now(),
 	}
 	m := memory.New()
@@ -55,7 +59,7 @@ func TestAddTransactionShouldReturnAlreadyExist(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$1",
+		LoadAmount: domain.NewMoney(100),
 		Time:       time.Now(),
 	}
 	m := memory.New()
@@ -65,15 +69,123 @@ func TestAddTransactionShouldReturnAlreadyExist(t *testing.T) {
 	assert.Equal(t, domain.ErrTransactionAlreadyExist, err)
 }
 
+func TestGetTransaction(t *testing.T) {
+	m := memory.New()
+	fund := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: time.Now()}
+	_, err := m.GetTransaction(fund.CustomerID, fund.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+	assert.Nil(t, m.AddTransaction(fund))
+	transaction, err := m.GetTransaction(fund.CustomerID, fund.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, fund, transaction)
+	_, err = m.GetTransaction("4321", fund.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestDecision(t *testing.T) {
+	m := memory.New()
+	fund := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: time.Now()}
+	decision := domain.TransactionDecision{Reason: domain.ReasonDailyAmountExceeded}
+	assert.Equal(t, domain.ErrNotFound, m.SetDecision(fund.CustomerID, fund.ID, decision))
+	assert.Nil(t, m.AddTransaction(fund))
+	_, err := m.GetDecision(fund.CustomerID, fund.ID)
+	assert.Equal(t, domain.ErrNotFound, err)
+	assert.Nil(t, m.SetDecision(fund.CustomerID, fund.ID, decision))
+	stored, err := m.GetDecision(fund.CustomerID, fund.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, decision, stored)
+
+	restored := memory.New()
+	restored.Restore(m.Snapshot())
+	stored, err = restored.GetDecision(fund.CustomerID, fund.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, decision, stored)
+}
+
+func TestMarkReversed(t *testing.T) {
+	m := memory.New()
+	fund := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: time.Now()}
+	assert.Equal(t, domain.ErrNotFound, m.MarkReversed(fund.CustomerID, fund.ID, "r1"))
+	assert.Nil(t, m.AddTransaction(fund))
+	assert.Nil(t, m.MarkReversed(fund.CustomerID, fund.ID, "r1"))
+	assert.Nil(t, m.MarkReversed(fund.CustomerID, fund.ID, "r1"))
+	assert.Equal(t, domain.ErrAlreadyReversed, m.MarkReversed(fund.CustomerID, fund.ID, "r2"))
+	stored, err := m.GetTransaction(fund.CustomerID, fund.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "r1", stored.ReversedBy)
+}
+
+func TestUnmarkReversed(t *testing.T) {
+	m := memory.New()
+	fund := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: time.Now()}
+	assert.Equal(t, domain.ErrNotFound, m.UnmarkReversed(fund.CustomerID, fund.ID, "r1"))
+	assert.Nil(t, m.AddTransaction(fund))
+	assert.Nil(t, m.MarkReversed(fund.CustomerID, fund.ID, "r1"))
+	assert.Nil(t, m.UnmarkReversed(fund.CustomerID, fund.ID, "r2"))
+	assert.Equal(t, domain.ErrAlreadyReversed, m.MarkReversed(fund.CustomerID, fund.ID, "r2"))
+	assert.Nil(t, m.UnmarkReversed(fund.CustomerID, fund.ID, "r1"))
+	assert.Nil(t, m.MarkReversed(fund.CustomerID, fund.ID, "r2"))
+	stored, err := m.GetTransaction(fund.CustomerID, fund.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "r2", stored.ReversedBy)
+}
+
+func TestPostEntries(t *testing.T) {
+	m := memory.New()
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	first := domain.Transaction{ID: "1", CustomerID: "1234", LoadAmount: domain.NewMoney(300), Time: at}
+	late := domain.Transaction{ID: "2", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: at.Add(-time.Hour)}
+	reversal := domain.Transaction{ID: "3", CustomerID: "1234", Time: at.Add(time.Hour), Type: domain.TransactionReversal, Reverses: "1"}
+	other := domain.Transaction{ID: "1", CustomerID: "4321", LoadAmount: domain.NewMoney(50), Time: at}
+	assert.Nil(t, m.PostEntries("1234", "1", domain.LoadEntries(first)))
+	assert.Nil(t, m.PostEntries("1234", "1", domain.LoadEntries(first)))
+	assert.Nil(t, m.PostEntries("1234", "2", domain.LoadEntries(late)))
+	assert.Nil(t, m.PostEntries("1234", "3", domain.ReversalEntries(reversal, first)))
+	assert.Nil(t, m.PostEntries("4321", "1", domain.LoadEntries(other)))
+	unbalanced := domain.LoadEntries(other)[:1]
+	assert.Equal(t, domain.ErrUnbalancedEntries, m.PostEntries("4321", "2", unbalanced))
+
+	entries, err := m.ListEntries("1234")
+	assert.Nil(t, err)
+	expected := append(append(domain.LoadEntries(late), domain.LoadEntries(first)...), domain.ReversalEntries(reversal, first)...)
+	assert.Equal(t, expected, entries)
+
+	balances, err := m.TrialBalance()
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.AccountBalance{
+		{Account: domain.CustomerAccount("1234"), Debit: domain.NewMoney(300), Credit: domain.NewMoney(400)},
+		{Account: domain.CustomerAccount("4321"), Credit: domain.NewMoney(50)},
+		{Account: domain.SettlementAccount, Debit: domain.NewMoney(450), Credit: domain.NewMoney(300)},
+	}, balances)
+}
+
+func TestPostFundedEntries(t *testing.T) {
+	m := memory.New()
+	at := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	load := domain.Transaction{ID: "1", CustomerID: "1234", LoadAmount: domain.NewMoney(300), Time: at}
+	spend := domain.Transaction{ID: "2", CustomerID: "1234", LoadAmount: domain.NewMoney(200), Time: at, Type: domain.TransactionSpend}
+	withdrawal := domain.Transaction{ID: "3", CustomerID: "1234", LoadAmount: domain.NewMoney(200), Time: at, Type: domain.TransactionWithdrawal}
+	assert.Equal(t, domain.ErrInsufficientFunds, m.PostFundedEntries("1234", "2", domain.Entries(spend)))
+	assert.Nil(t, m.PostEntries("1234", "1", domain.Entries(load)))
+	assert.Nil(t, m.PostFundedEntries("1234", "2", domain.Entries(spend)))
+	assert.Nil(t, m.PostFundedEntries("1234", "2", domain.Entries(spend)))
+	assert.Equal(t, domain.ErrInsufficientFunds, m.PostFundedEntries("1234", "3", domain.Entries(withdrawal)))
+	assert.Equal(t, domain.ErrUnbalancedEntries, m.PostFundedEntries("1234", "3", domain.Entries(withdrawal)[:1]))
+
+	entries, err := m.ListEntries("1234")
+	assert.Nil(t, err)
+	assert.Equal(t, append(domain.Entries(load), domain.Entries(spend)...), entries)
+}
+
 func TestAddDailyTransaction(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$1",
+		LoadAmount: domain.NewMoney(100),
 		Time:       time.Now(),
 	}
 	m := memory.New()
-	dailyTransaction := domain.DailyTransaction{TransactionCount: 1, Transaction: fund, DailyTotal: 10}
+	dailyTransaction := domain.DailyTransaction{TransactionCount: 1, Transaction: fund, DailyTotal: domain.NewMoney(1000)}
 	err := m.AddDailyTransaction(fund.CustomerID, fund.Time.Format(domain.DateLayout), dailyTransaction)
 	assert.Nil(t, err)
 }
@@ -82,13 +194,13 @@ func TestAddWeeklyTransaction(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$1",
+		LoadAmount: domain.NewMoney(100),
 		Time:       time.Now(),
 	}
 	m := memory.New()
 	year, week := fund.Time.ISOWeek()
 	weeklyTransaction := domain.WeeklyTransaction{Year: year, Week: week}
-	weeklyTransactionTotal := domain.WeeklyTransactionTotal{Value: 1}
+	weeklyTransactionTotal := domain.WeeklyTransactionTotal{Value: domain.NewMoney(100)}
 	err := m.AddWeeklyTransaction(fund.CustomerID, weeklyTransaction, weeklyTransactionTotal)
 	assert.Nil(t, err)
 }
@@ -97,17 +209,17 @@ func TestGetDailyTransaction(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$10",
+		LoadAmount: domain.NewMoney(1000),
 		Time:       time.Now(),
 	}
 	m := memory.New()
-	dailyTransaction := domain.DailyTransaction{TransactionCount: 1, Transaction: fund, DailyTotal: 10}
+	dailyTransaction := domain.DailyTransaction{TransactionCount: 1, Transaction: fund, DailyTotal: domain.NewMoney(1000)}
 	day := fund.Time.Format(domain.DateLayout)
 	err := m.AddDailyTransaction(fund.CustomerID, day, dailyTransaction)
 	assert.Nil(t, err)
 	daily, err := m.GetDailyTransaction(fund.CustomerID, day)
 	assert.Nil(t, err)
-	assert.Equal(t, float64(10), daily.DailyTotal)
+	assert.Equal(t, domain.NewMoney(1000), daily.DailyTotal)
 	assert.Equal(t, 1, daily.TransactionCount)
 	assert.Equal(t, fund, daily.Transaction)
 }
@@ -116,11 +228,11 @@ func TestGetDailyTransactionShouldReturnNotFound(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$10",
+		LoadAmount: domain.NewMoney(1000),
 		Time:       time.Now(),
 	}
 	m := memory.New()
-	dailyTransaction := domain.DailyTransaction{TransactionCount: 1, Transaction: fund, DailyTotal: 10}
+	dailyTransaction := domain.DailyTransaction{TransactionCount: 1, Transaction: fund, DailyTotal: domain.NewMoney(1000)}
 	day := fund.Time.Format(domain.DateLayout)
 	err := m.AddDailyTransaction(fund.CustomerID, day, dailyTransaction)
 	assert.Nil(t, err)
@@ -132,33 +244,410 @@ func TestGetWeeklyTransaction(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$1",
+		LoadAmount: domain.NewMoney(100),
 		Time:       time.Now(),
 	}
 	m := memory.New()
 	year, week := fund.Time.ISOWeek()
 	weeklyTransaction := domain.WeeklyTransaction{Year: year, Week: week}
-	weeklyTransactionTotal := domain.WeeklyTransactionTotal{Value: 1}
+	weeklyTransactionTotal := domain.WeeklyTransactionTotal{Value: domain.NewMoney(100)}
 	err := m.AddWeeklyTransaction(fund.CustomerID, weeklyTransaction, weeklyTransactionTotal)
 	assert.Nil(t, err)
 	weekly, err := m.GetWeeklyTransaction(fund.CustomerID, weeklyTransaction)
 	assert.Nil(t, err)
-	assert.Equal(t, float64(1), weekly.Value)
+	assert.Equal(t, domain.NewMoney(100), weekly.Value)
+}
+
+func TestMonthlyAndYearlyTransaction(t *testing.T) {
+	m := memory.New()
+	month := domain.MonthlyTransaction{Year: 2000, Month: time.January}
+	year := domain.YearlyTransaction{Year: 2000}
+	_, err := m.GetMonthlyTransaction("1234", month)
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = m.GetYearlyTransaction("1234", year)
+	assert.Equal(t, domain.ErrNotFound, err)
+	assert.Nil(t, m.AddMonthlyTransaction("1234", month, domain.MonthlyTransactionTotal{Value: domain.NewMoney(100)}))
+	assert.Nil(t, m.AddYearlyTransaction("1234", year, domain.YearlyTransactionTotal{Value: domain.NewMoney(200)}))
+	monthly, err := m.GetMonthlyTransaction("1234", month)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(100), monthly.Value)
+	yearly, err := m.GetYearlyTransaction("1234", year)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(200), yearly.Value)
+	_, err = m.GetMonthlyTransaction("1234", domain.MonthlyTransaction{Year: 2000, Month: time.February})
+	assert.Equal(t, domain.ErrNotFound, err)
 }
 
 func TestGetWeeklyTransactionShouldReturnNotFound(t *testing.T) {
 	fund := domain.Transaction{
 		ID:         "123",
 		CustomerID: "1234",
-		LoadAmount: "$1",
+		LoadAmount: domain.NewMoney(100),
 		Time:       time.Now(),
 	}
 	m := memory.New()
 	year, week := fund.Time.ISOWeek()
 	weeklyTransaction := domain.WeeklyTransaction{Year: year, Week: week}
-	weeklyTransactionTotal := domain.WeeklyTransactionTotal{Value: 1}
+	weeklyTransactionTotal := domain.WeeklyTransactionTotal{Value: domain.NewMoney(100)}
 	err := m.AddWeeklyTransaction(fund.CustomerID, weeklyTransaction, weeklyTransactionTotal)
 	assert.Nil(t, err)
 	_, err = m.GetWeeklyTransaction("888", weeklyTransaction)
 	assert.Equal(t, domain.ErrNotFound, err)
 }
+
+func TestCustomerProfile(t *testing.T) {
+	weekly := domain.NewMoney(5000000)
+	profile := domain.CustomerProfile{CustomerID: "1234", Tier: domain.TierPremium, Override: domain.LimitOverride{WeeklyAmount: &weekly}}
+	m := memory.New()
+	_, err := m.GetCustomerProfile(profile.CustomerID)
+	assert.Equal(t, domain.ErrNotFound, err)
+	err = m.SetCustomerProfile(profile)
+	assert.Nil(t, err)
+	stored, err := m.GetCustomerProfile(profile.CustomerID)
+	assert.Nil(t, err)
+	assert.Equal(t, profile, stored)
+	err = m.SetCustomerProfile(domain.CustomerProfile{Tier: domain.TierBasic})
+	assert.Equal(t, domain.ErrCustomerEmptyID, err)
+}
+
+func TestUpdateCustomerState(t *testing.T) {
+	now := time.Now()
+	year, week := now.ISOWeek()
+	windows := domain.Windows{Day: now.Format(domain.DateLayout), Week: domain.WeeklyTransaction{Year: year, Week: week}}
+	delta := domain.StateDelta{DailyAmount: domain.NewMoney(100), DailyCount: 1, WeeklyAmount: domain.NewMoney(100)}
+	m := memory.New()
+	err := m.UpdateCustomerState("1234", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		assert.Equal(t, domain.CustomerState{}, state)
+		return state.Apply(delta), true, nil
+	})
+	assert.Nil(t, err)
+	daily, err := m.GetDailyTransaction("1234", windows.Day)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, daily.TransactionCount)
+	weekly, err := m.GetWeeklyTransaction("1234", windows.Week)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(100), weekly.Value)
+}
+
+func TestUpdateCustomerStateShouldKeepLoadHistory(t *testing.T) {
+	start := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	m := memory.New()
+	for i := 0; i < 3; i++ {
+		event := domain.LoadEvent{ID: strconv.Itoa(i), Time: start.Add(time.Duration(i) * time.Hour), Amount: domain.NewMoney(100)}
+		windows := domain.Windows{Day: "2000-01-03", Since: event.Time.Add(-24 * time.Hour)}
+		err := m.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Len(t, state.Recent, i)
+			return state.Record(event), true, nil
+		})
+		assert.Nil(t, err)
+	}
+	windows := domain.Windows{Day: "2000-01-03", Since: start.Add(90 * time.Minute)}
+	err := m.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		assert.Len(t, state.Recent, 1)
+		state.Recent = nil
+		return state, true, nil
+	})
+	assert.Nil(t, err)
+	history, err := m.LoadHistory("1", start)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "1", history[1].ID)
+}
+
+func TestUpdateCustomerStateShouldKeepApplied(t *testing.T) {
+	m := memory.New()
+	applied := func(id string, expected, applied bool) {
+		windows := domain.Windows{Day: "2000-01-03", TransactionID: id}
+		err := m.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Equal(t, expected, state.Applied)
+			state.Applied = applied
+			return state, true, nil
+		})
+		assert.Nil(t, err)
+	}
+	applied("1", false, true)
+	applied("1", true, true)
+	applied("2", false, false)
+	applied("1", true, false)
+	applied("1", false, false)
+}
+
+func TestUpdateCustomerStateShouldKeepKindsApart(t *testing.T) {
+	m := memory.New()
+	count := func(d *memory.Database, customerID string, kind domain.TransactionType, expected int) {
+		windows := domain.Windows{Day: "2000-01-03", Kind: kind}
+		err := d.UpdateCustomerState(customerID, windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Equal(t, expected, state.Daily.TransactionCount)
+			state.Daily.TransactionCount++
+			return state, true, nil
+		})
+		assert.Nil(t, err)
+	}
+	count(m, "1", domain.TransactionWithdrawal, 0)
+	count(m, "1", domain.TransactionWithdrawal, 1)
+	count(m, "1", "", 0)
+	count(m, "withdrawal:1", "", 0)
+	count(m, "1", domain.TransactionSpend, 0)
+	daily, err := m.GetDailyTransaction("1", "2000-01-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, daily.TransactionCount)
+
+	restored := memory.New()
+	restored.Restore(m.Snapshot())
+	count(restored, "1", domain.TransactionWithdrawal, 2)
+	count(restored, "withdrawal:1", "", 1)
+}
+
+func TestUpdateCustomerStateShouldNotCommit(t *testing.T) {
+	now := time.Now()
+	year, week := now.ISOWeek()
+	windows := domain.Windows{Day: now.Format(domain.DateLayout), Week: domain.WeeklyTransaction{Year: year, Week: week}}
+	delta := domain.StateDelta{DailyAmount: domain.NewMoney(100), DailyCount: 1, WeeklyAmount: domain.NewMoney(100)}
+	m := memory.New()
+	err := m.UpdateCustomerState("1234", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state.Apply(delta), false, nil
+	})
+	assert.Nil(t, err)
+	fakeErr := errors.New("some error")
+	err = m.UpdateCustomerState("1234", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state.Apply(delta), true, fakeErr
+	})
+	assert.Equal(t, fakeErr, err)
+	_, err = m.GetDailyTransaction("1234", windows.Day)
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = m.GetWeeklyTransaction("1234", windows.Week)
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestUpdateCustomerStateShouldBeAtomic(t *testing.T) {
+	now := time.Now()
+	year, week := now.ISOWeek()
+	windows := domain.Windows{Day: now.Format(domain.DateLayout), Week: domain.WeeklyTransaction{Year: year, Week: week}}
+	maximum := 3
+	m := memory.New()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := m.UpdateCustomerState("1234", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+				if state.Daily.TransactionCount >= maximum {
+					return state, false, nil
+				}
+				return state.Apply(domain.StateDelta{DailyCount: 1, WeeklyAmount: domain.NewMoney(100)}), true, nil
+			})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	daily, err := m.GetDailyTransaction("1234", windows.Day)
+	assert.Nil(t, err)
+	assert.Equal(t, maximum, daily.TransactionCount)
+	weekly, err := m.GetWeeklyTransaction("1234", windows.Week)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(300), weekly.Value)
+}
+
+func TestDatabaseShouldSupportConcurrentCustomers(t *testing.T) {
+	now := time.Now()
+	year, week := now.ISOWeek()
+	windows := domain.Windows{Day: now.Format(domain.DateLayout), Week: domain.WeeklyTransaction{Year: year, Week: week}}
+	customers := 20
+	loadsPerCustomer := 50
+	m := memory.New(memory.WithShards(4))
+	var wg sync.WaitGroup
+	for c := 0; c < customers; c++ {
+		customerID := strconv.Itoa(c)
+		for l := 0; l < loadsPerCustomer; l++ {
+			wg.Add(1)
+			go func(customerID, transactionID string) {
+				defer wg.Done()
+				fund := domain.Transaction{ID: transactionID, CustomerID: customerID, LoadAmount: domain.NewMoney(100), Time: now}
+				assert.Nil(t, m.AddTransaction(fund))
+				assert.Equal(t, domain.ErrTransactionAlreadyExist, m.AddTransaction(fund))
+				err := m.UpdateCustomerState(customerID, windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+					delta := domain.StateDelta{DailyAmount: fund.LoadAmount, DailyCount: 1, WeeklyAmount: fund.LoadAmount}
+					return state.Apply(delta), true, nil
+				})
+				assert.Nil(t, err)
+				_, _ = m.GetDailyTransaction(customerID, windows.Day)
+				_, _ = m.GetWeeklyTransaction(customerID, windows.Week)
+				assert.Nil(t, m.SetCustomerProfile(domain.CustomerProfile{CustomerID: customerID, Tier: domain.TierBasic}))
+				_, err = m.GetCustomerProfile(customerID)
+				assert.Nil(t, err)
+			}(customerID, strconv.Itoa(l))
+		}
+	}
+	wg.Wait()
+
+	for c := 0; c < customers; c++ {
+		customerID := strconv.Itoa(c)
+		daily, err := m.GetDailyTransaction(customerID, windows.Day)
+		assert.Nil(t, err)
+		assert.Equal(t, loadsPerCustomer, daily.TransactionCount)
+		assert.Equal(t, domain.NewMoney(int64(100*loadsPerCustomer)), daily.DailyTotal)
+		weekly, err := m.GetWeeklyTransaction(customerID, windows.Week)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.NewMoney(int64(100*loadsPerCustomer)), weekly.Value)
+	}
+}
+
+func BenchmarkUpdateCustomerState(b *testing.B) {
+	now := time.Now()
+	year, week := now.ISOWeek()
+	windows := domain.Windows{Day: now.Format(domain.DateLayout), Week: domain.WeeklyTransaction{Year: year, Week: week}}
+	delta := domain.StateDelta{DailyAmount: domain.NewMoney(100), DailyCount: 1, WeeklyAmount: domain.NewMoney(100)}
+	update := func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state.Apply(delta), true, nil
+	}
+	m := memory.New()
+	var customer uint64
+	b.RunParallel(func(pb *testing.PB) {
+		customerID := strconv.FormatUint(atomic.AddUint64(&customer, 1), 10)
+		for pb.Next() {
+			_ = m.UpdateCustomerState(customerID, windows, update)
+		}
+	})
+}
+
+func TestAddDailyTransactionShouldKeepOtherDays(t *testing.T) {
+	m := memory.New()
+	march := time.Date(2000, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 31; i++ {
+		day := march.AddDate(0, 0, i).Format(domain.DateLayout)
+		dailyTransaction := domain.DailyTransaction{TransactionCount: 1, DailyTotal: domain.NewMoney(int64(i))}
+		assert.Nil(t, m.AddDailyTransaction("528", day, dailyTransaction))
+	}
+	assert.Nil(t, m.AddDailyTransaction("528", "2000-04-01", domain.DailyTransaction{TransactionCount: 1}))
+
+	daily, err := m.GetDailyTransaction("528", "2000-03-01")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, daily.TransactionCount)
+
+	windows, err := m.ListDailyTransactions("528", march, time.Date(2000, time.March, 31, 23, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Len(t, windows, 31)
+	assert.Equal(t, "2000-03-01", windows[0].Day)
+	assert.Equal(t, "2000-03-31", windows[30].Day)
+	assert.Equal(t, domain.NewMoney(30), windows[30].Daily.DailyTotal)
+
+	windows, err = m.ListDailyTransactions("888", march, march)
+	assert.Nil(t, err)
+	assert.Empty(t, windows)
+}
+
+func TestAddWeeklyTransactionShouldKeepOtherWeeks(t *testing.T) {
+	m := memory.New()
+	weeks := []domain.WeeklyTransaction{{Year: 1999, Week: 52}, {Year: 2000, Week: 1}, {Year: 2000, Week: 2}, {Year: 2000, Week: 3}}
+	for i, week := range weeks {
+		assert.Nil(t, m.AddWeeklyTransaction("528", week, domain.WeeklyTransactionTotal{Value: domain.NewMoney(int64(i))}))
+	}
+	windows, err := m.ListWeeklyTransactions("528", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2000, time.January, 10, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.WeeklyWindow{
+		{Week: weeks[0], Total: domain.WeeklyTransactionTotal{Value: domain.NewMoney(0)}},
+		{Week: weeks[1], Total: domain.WeeklyTransactionTotal{Value: domain.NewMoney(1)}},
+		{Week: weeks[2], Total: domain.WeeklyTransactionTotal{Value: domain.NewMoney(2)}},
+	}, windows)
+}
+
+func TestRetentionShouldEvictOldWindows(t *testing.T) {
+	m := memory.New(memory.WithRetention(7))
+	start := time.Date(2000, time.January, 3, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		now := start.AddDate(0, 0, i)
+		year, week := now.ISOWeek()
+		windows := domain.Windows{
+			Day:   now.Format(domain.DateLayout),
+			Week:  domain.WeeklyTransaction{Year: year, Week: week},
+			Month: domain.MonthlyTransaction{Year: now.Year(), Month: now.Month()},
+			Year:  domain.YearlyTransaction{Year: now.Year()},
+		}
+		err := m.UpdateCustomerState("528", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			return state.Apply(domain.StateDelta{DailyCount: 1, WeeklyAmount: domain.NewMoney(100), MonthlyAmount: domain.NewMoney(100)}), true, nil
+		})
+		assert.Nil(t, err)
+	}
+	days, err := m.ListDailyTransactions("528", start, start.AddDate(0, 0, 30))
+	assert.Nil(t, err)
+	assert.Len(t, days, 8)
+	assert.Equal(t, "2000-01-25", days[0].Day)
+	weeks, err := m.ListWeeklyTransactions("528", start, start.AddDate(0, 0, 30))
+	assert.Nil(t, err)
+	assert.Len(t, weeks, 2)
+	assert.Equal(t, domain.WeeklyTransaction{Year: 2000, Week: 4}, weeks[0].Week)
+	monthly, err := m.GetMonthlyTransaction("528", domain.MonthlyTransaction{Year: 2000, Month: time.January})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(2900), monthly.Value)
+	assert.Nil(t, m.AddMonthlyTransaction("528", domain.MonthlyTransaction{Year: 2000, Month: time.March}, domain.MonthlyTransactionTotal{}))
+	_, err = m.GetMonthlyTransaction("528", domain.MonthlyTransaction{Year: 2000, Month: time.January})
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = m.GetMonthlyTransaction("528", domain.MonthlyTransaction{Year: 2000, Month: time.February})
+	assert.Nil(t, err)
+}
+
+func TestSnapshotShouldRestoreEverything(t *testing.T) {
+	m := memory.New(memory.WithShards(4))
+	now := time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	for c := 0; c < 10; c++ {
+		customerID := strconv.Itoa(c)
+		transaction := domain.Transaction{ID: "1", CustomerID: customerID, LoadAmount: domain.NewMoney(100), Time: now}
+		assert.Nil(t, m.AddTransaction(transaction))
+		assert.Nil(t, m.AddDailyTransaction(customerID, "2000-01-03", domain.DailyTransaction{Transaction: transaction, TransactionCount: 1, DailyTotal: domain.NewMoney(100)}))
+		assert.Nil(t, m.AddWeeklyTransaction(customerID, domain.WeeklyTransaction{Year: 2000, Week: 1}, domain.WeeklyTransactionTotal{Value: domain.NewMoney(100)}))
+		assert.Nil(t, m.AddMonthlyTransaction(customerID, domain.MonthlyTransaction{Year: 2000, Month: time.January}, domain.MonthlyTransactionTotal{Value: domain.NewMoney(100)}))
+		assert.Nil(t, m.AddYearlyTransaction(customerID, domain.YearlyTransaction{Year: 2000}, domain.YearlyTransactionTotal{Value: domain.NewMoney(100)}))
+		assert.Nil(t, m.SetCustomerProfile(domain.CustomerProfile{CustomerID: customerID, Tier: domain.TierVerified}))
+		assert.Nil(t, m.SetLoadHistory(customerID, now, []domain.LoadEvent{{ID: "1", Time: now, Amount: domain.NewMoney(100)}}))
+		assert.Nil(t, m.PostEntries(customerID, "1", domain.LoadEntries(transaction)))
+		assert.Nil(t, m.SetApplied(customerID, "1", true))
+	}
+	snapshot := m.Snapshot()
+	assert.Len(t, snapshot.Transactions, 10)
+	assert.Len(t, snapshot.Daily, 10)
+	assert.Len(t, snapshot.Weekly, 10)
+	assert.Len(t, snapshot.Monthly, 10)
+	assert.Len(t, snapshot.Yearly, 10)
+	assert.Len(t, snapshot.Profiles, 10)
+	assert.Len(t, snapshot.History, 10)
+	assert.Len(t, snapshot.Ledger, 20)
+	assert.Len(t, snapshot.Applied, 10)
+
+	restored := memory.New()
+	restored.Restore(snapshot)
+	for c := 0; c < 10; c++ {
+		customerID := strconv.Itoa(c)
+		_, err := restored.GetTransaction(customerID, "1")
+		assert.Nil(t, err)
+		daily, err := restored.GetDailyTransaction(customerID, "2000-01-03")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, daily.TransactionCount)
+		weekly, err := restored.GetWeeklyTransaction(customerID, domain.WeeklyTransaction{Year: 2000, Week: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.NewMoney(100), weekly.Value)
+		monthly, err := restored.GetMonthlyTransaction(customerID, domain.MonthlyTransaction{Year: 2000, Month: time.January})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.NewMoney(100), monthly.Value)
+		yearly, err := restored.GetYearlyTransaction(customerID, domain.YearlyTransaction{Year: 2000})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.NewMoney(100), yearly.Value)
+		profile, err := restored.GetCustomerProfile(customerID)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.TierVerified, profile.Tier)
+		history, err := restored.LoadHistory(customerID, now)
+		assert.Nil(t, err)
+		assert.Len(t, history, 1)
+		entries, err := restored.ListEntries(customerID)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2)
+		posted, err := restored.Posted(customerID, "1")
+		assert.Nil(t, err)
+		assert.True(t, posted)
+		windows := domain.Windows{Day: "2000-01-03", TransactionID: "1"}
+		err = restored.UpdateCustomerState(customerID, windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.True(t, state.Applied)
+			return state, false, nil
+		})
+		assert.Nil(t, err)
+	}
+}
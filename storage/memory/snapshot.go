@@ -0,0 +1,227 @@
+package memory
+
+import "github.com/danielfmelo/load-funds-handler/domain"
+
+// Snapshot is a copy of everything kept by a Database.
+type Snapshot struct {
+	Transactions []domain.Transaction     `json:"transactions"`
+	Daily        []DailyEntry             `json:"daily"`
+	Weekly       []WeeklyEntry            `json:"weekly"`
+	Monthly      []MonthlyEntry           `json:"monthly"`
+	Yearly       []YearlyEntry            `json:"yearly"`
+	Profiles     []domain.CustomerProfile `json:"profiles"`
+	Decisions    []DecisionEntry          `json:"decisions"`
+	History      []HistoryEntry           `json:"history"`
+	Ledger       []domain.LedgerEntry     `json:"ledger"`
+	Applied      []AppliedEntry           `json:"applied"`
+}
+
+// AppliedEntry is a transaction in the counters of the customer.
+type AppliedEntry struct {
+	CustomerID string `json:"customer_id"`
+	ID         string `json:"id"`
+}
+
+// HistoryEntry, DailyEntry, WeeklyEntry, MonthlyEntry and YearlyEntry hold
+// the counters of one kind of transaction of the customer, loads when Kind
+// is empty.
+type HistoryEntry struct {
+	CustomerID string                 `json:"customer_id"`
+	Kind       domain.TransactionType `json:"kind,omitempty"`
+	Events     []domain.LoadEvent     `json:"events"`
+}
+
+type DecisionEntry struct {
+	CustomerID string                     `json:"customer_id"`
+	ID         string                     `json:"id"`
+	Decision   domain.TransactionDecision `json:"decision"`
+}
+
+type DailyEntry struct {
+	CustomerID string                  `json:"customer_id"`
+	Kind       domain.TransactionType  `json:"kind,omitempty"`
+	Day        string                  `json:"day"`
+	Daily      domain.DailyTransaction `json:"daily"`
+}
+
+type WeeklyEntry struct {
+	CustomerID string                        `json:"customer_id"`
+	Kind       domain.TransactionType        `json:"kind,omitempty"`
+	Week       domain.WeeklyTransaction      `json:"week"`
+	Total      domain.WeeklyTransactionTotal `json:"total"`
+}
+
+type MonthlyEntry struct {
+	CustomerID string                         `json:"customer_id"`
+	Kind       domain.TransactionType         `json:"kind,omitempty"`
+	Month      domain.MonthlyTransaction      `json:"month"`
+	Total      domain.MonthlyTransactionTotal `json:"total"`
+}
+
+type YearlyEntry struct {
+	CustomerID string                        `json:"customer_id"`
+	Kind       domain.TransactionType        `json:"kind,omitempty"`
+	Year       domain.YearlyTransaction      `json:"year"`
+	Total      domain.YearlyTransactionTotal `json:"total"`
+}
+
+// Snapshot copies the database one shard at a time. Writes made while it
+// runs may or may not be included, so callers that need a consistent copy
+// must hold off writes themselves.
+func (d *Database) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Transactions: []domain.Transaction{},
+		Daily:        []DailyEntry{},
+		Weekly:       []WeeklyEntry{},
+		Monthly:      []MonthlyEntry{},
+		Yearly:       []YearlyEntry{},
+		Profiles:     []domain.CustomerProfile{},
+		Decisions:    []DecisionEntry{},
+		History:      []HistoryEntry{},
+		Ledger:       []domain.LedgerEntry{},
+		Applied:      []AppliedEntry{},
+	}
+	for _, s := range d.shards {
+		s.mu.RLock()
+		for _, customer := range s.transactions {
+			for _, transaction := range customer {
+				snapshot.Transactions = append(snapshot.Transactions, transaction)
+			}
+		}
+		for key, customer := range s.daily {
+			for day, daily := range customer {
+				snapshot.Daily = append(snapshot.Daily, DailyEntry{CustomerID: key.customerID, Kind: key.kind, Day: day, Daily: daily})
+			}
+		}
+		for key, customer := range s.weekly {
+			for week, total := range customer {
+				snapshot.Weekly = append(snapshot.Weekly, WeeklyEntry{CustomerID: key.customerID, Kind: key.kind, Week: week, Total: total})
+			}
+		}
+		for key, customer := range s.monthly {
+			for month, total := range customer {
+				snapshot.Monthly = append(snapshot.Monthly, MonthlyEntry{CustomerID: key.customerID, Kind: key.kind, Month: month, Total: total})
+			}
+		}
+		for key, customer := range s.yearly {
+			for year, total := range customer {
+				snapshot.Yearly = append(snapshot.Yearly, YearlyEntry{CustomerID: key.customerID, Kind: key.kind, Year: year, Total: total})
+			}
+		}
+		for customerID, customer := range s.decisions {
+			for id, decision := range customer {
+				snapshot.Decisions = append(snapshot.Decisions, DecisionEntry{CustomerID: customerID, ID: id, Decision: decision})
+			}
+		}
+		for key, events := range s.history {
+			snapshot.History = append(snapshot.History, HistoryEntry{CustomerID: key.customerID, Kind: key.kind, Events: events})
+		}
+		for _, ledger := range s.ledger {
+			snapshot.Ledger = append(snapshot.Ledger, ledger...)
+		}
+		for customerID, customer := range s.applied {
+			for id := range customer {
+				snapshot.Applied = append(snapshot.Applied, AppliedEntry{CustomerID: customerID, ID: id})
+			}
+		}
+		for _, profile := range s.profiles {
+			snapshot.Profiles = append(snapshot.Profiles, profile)
+		}
+		s.mu.RUnlock()
+	}
+	return snapshot
+}
+
+// Restore adds everything in snapshot to the database as it is, without
+// evicting windows out of retention.
+func (d *Database) Restore(snapshot Snapshot) {
+	for _, transaction := range snapshot.Transactions {
+		s := d.shard(transaction.CustomerID)
+		s.mu.Lock()
+		customer, ok := s.transactions[transaction.CustomerID]
+		if !ok {
+			customer = make(map[string]domain.Transaction)
+			s.transactions[transaction.CustomerID] = customer
+		}
+		customer[transaction.ID] = transaction
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.Daily {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		key := counter{customerID: entry.CustomerID, kind: entry.Kind}
+		customer, ok := s.daily[key]
+		if !ok {
+			customer = make(map[string]domain.DailyTransaction)
+			s.daily[key] = customer
+		}
+		customer[entry.Day] = entry.Daily
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.Weekly {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		key := counter{customerID: entry.CustomerID, kind: entry.Kind}
+		customer, ok := s.weekly[key]
+		if !ok {
+			customer = make(map[domain.WeeklyTransaction]domain.WeeklyTransactionTotal)
+			s.weekly[key] = customer
+		}
+		customer[entry.Week] = entry.Total
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.Monthly {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		key := counter{customerID: entry.CustomerID, kind: entry.Kind}
+		customer, ok := s.monthly[key]
+		if !ok {
+			customer = make(map[domain.MonthlyTransaction]domain.MonthlyTransactionTotal)
+			s.monthly[key] = customer
+		}
+		customer[entry.Month] = entry.Total
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.Yearly {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		key := counter{customerID: entry.CustomerID, kind: entry.Kind}
+		customer, ok := s.yearly[key]
+		if !ok {
+			customer = make(map[domain.YearlyTransaction]domain.YearlyTransactionTotal)
+			s.yearly[key] = customer
+		}
+		customer[entry.Year] = entry.Total
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.Decisions {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		s.setDecision(entry.CustomerID, entry.ID, entry.Decision)
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.History {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		s.history[counter{customerID: entry.CustomerID, kind: entry.Kind}] = entry.Events
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.Ledger {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		s.postEntries(entry.CustomerID, entry.TransactionID, []domain.LedgerEntry{entry})
+		s.mu.Unlock()
+	}
+	for _, entry := range snapshot.Applied {
+		s := d.shard(entry.CustomerID)
+		s.mu.Lock()
+		s.setApplied(entry.CustomerID, entry.ID, true)
+		s.mu.Unlock()
+	}
+	for _, profile := range snapshot.Profiles {
+		s := d.shard(profile.CustomerID)
+		s.mu.Lock()
+		s.profiles[profile.CustomerID] = profile
+		s.mu.Unlock()
+	}
+}
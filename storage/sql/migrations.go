@@ -0,0 +1,209 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order, each one in its own transaction, and
+// recorded in schema_migrations by their position counted from 1. Append new
+// migrations; never change the ones already released.
+var migrations = [][]string{
+	{
+		`CREATE TABLE customers (
+			customer_id TEXT PRIMARY KEY,
+			profile TEXT
+		)`,
+		`CREATE TABLE transactions (
+			customer_id TEXT NOT NULL,
+			id TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			time TEXT NOT NULL,
+			PRIMARY KEY (customer_id, id)
+		)`,
+		`CREATE TABLE daily_windows (
+			customer_id TEXT NOT NULL,
+			day TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, day)
+		)`,
+		`CREATE TABLE weekly_windows (
+			customer_id TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			week INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, year, week)
+		)`,
+	},
+	{
+		`ALTER TABLE transactions ADD COLUMN decision TEXT`,
+	},
+	{
+		`CREATE TABLE load_events (
+			customer_id TEXT NOT NULL,
+			id TEXT NOT NULL,
+			at BIGINT NOT NULL,
+			time TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, id)
+		)`,
+		`CREATE INDEX load_events_at ON load_events (customer_id, at)`,
+	},
+	{
+		`CREATE TABLE monthly_windows (
+			customer_id TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			month INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, year, month)
+		)`,
+		`CREATE TABLE yearly_windows (
+			customer_id TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, year)
+		)`,
+	},
+	{
+		`ALTER TABLE transactions ADD COLUMN type TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE transactions ADD COLUMN reverses TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE transactions ADD COLUMN reversed_by TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`CREATE TABLE ledger_entries (
+			customer_id TEXT NOT NULL,
+			transaction_id TEXT NOT NULL,
+			seq INTEGER NOT NULL,
+			account TEXT NOT NULL,
+			side TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			at BIGINT NOT NULL,
+			time TEXT NOT NULL,
+			PRIMARY KEY (customer_id, transaction_id, seq)
+		)`,
+		`CREATE INDEX ledger_entries_account ON ledger_entries (account)`,
+	},
+	{
+		`CREATE TABLE applied_transactions (
+			customer_id TEXT NOT NULL,
+			id TEXT NOT NULL,
+			PRIMARY KEY (customer_id, id)
+		)`,
+	},
+	{
+		// Each type of transaction has windows and a load history of its
+		// own; those of loads have the empty kind. SQLite cannot change a
+		// primary key, so the tables are copied.
+		`CREATE TABLE daily_windows_by_kind (
+			customer_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			day TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, kind, day)
+		)`,
+		`INSERT INTO daily_windows_by_kind (customer_id, kind, day, count, amount, currency)
+		SELECT customer_id, '', day, count, amount, currency FROM daily_windows`,
+		`DROP TABLE daily_windows`,
+		`ALTER TABLE daily_windows_by_kind RENAME TO daily_windows`,
+		`CREATE TABLE weekly_windows_by_kind (
+			customer_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			week INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, kind, year, week)
+		)`,
+		`INSERT INTO weekly_windows_by_kind (customer_id, kind, year, week, amount, currency)
+		SELECT customer_id, '', year, week, amount, currency FROM weekly_windows`,
+		`DROP TABLE weekly_windows`,
+		`ALTER TABLE weekly_windows_by_kind RENAME TO weekly_windows`,
+		`CREATE TABLE monthly_windows_by_kind (
+			customer_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			month INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, kind, year, month)
+		)`,
+		`INSERT INTO monthly_windows_by_kind (customer_id, kind, year, month, amount, currency)
+		SELECT customer_id, '', year, month, amount, currency FROM monthly_windows`,
+		`DROP TABLE monthly_windows`,
+		`ALTER TABLE monthly_windows_by_kind RENAME TO monthly_windows`,
+		`CREATE TABLE yearly_windows_by_kind (
+			customer_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, kind, year)
+		)`,
+		`INSERT INTO yearly_windows_by_kind (customer_id, kind, year, amount, currency)
+		SELECT customer_id, '', year, amount, currency FROM yearly_windows`,
+		`DROP TABLE yearly_windows`,
+		`ALTER TABLE yearly_windows_by_kind RENAME TO yearly_windows`,
+		`CREATE TABLE load_events_by_kind (
+			customer_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			id TEXT NOT NULL,
+			at BIGINT NOT NULL,
+			time TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			currency TEXT NOT NULL,
+			PRIMARY KEY (customer_id, kind, id)
+		)`,
+		`INSERT INTO load_events_by_kind (customer_id, kind, id, at, time, amount, currency)
+		SELECT customer_id, '', id, at, time, amount, currency FROM load_events`,
+		`DROP TABLE load_events`,
+		`ALTER TABLE load_events_by_kind RENAME TO load_events`,
+		`CREATE INDEX load_events_at ON load_events (customer_id, kind, at)`,
+	},
+}
+
+// Migrate applies the migrations the database does not have yet.
+func (d *Database) Migrate() error {
+	_, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("error to create schema_migrations: %w", err)
+	}
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		err := d.inTx(func(tx *sql.Tx) error {
+			for _, statement := range migrations[i] {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, i+1)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error to apply migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the number of migrations applied.
+func (d *Database) SchemaVersion() (int, error) {
+	var version int
+	err := d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error to read schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,890 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	"github.com/danielfmelo/load-funds-handler/storage"
+)
+
+const DefaultRetentionDays = 400
+
+// CheckDriver reports whether the database/sql driver name is supported.
+// Only SQLite is: it has no row locks, so writers must be serialized by
+// opening it with _txlock=immediate.
+func CheckDriver(driver string) error {
+	switch driver {
+	case "sqlite3", "sqlite":
+		return nil
+	}
+	return fmt.Errorf("unsupported sql driver %q", driver)
+}
+
+// immediateDSN returns dsn with _txlock=immediate added when it has no
+// _txlock, so every transaction takes the write lock when it begins. A dsn
+// asking for another lock mode is rejected, as concurrent updates of the
+// same customer could then both pass a limit.
+func immediateDSN(dsn string) (string, error) {
+	query := ""
+	if i := strings.IndexByte(dsn, '?'); i >= 0 {
+		query = dsn[i+1:]
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid sql dsn: %w", err)
+	}
+	switch txlock := params.Get("_txlock"); txlock {
+	case "immediate":
+		return dsn, nil
+	case "":
+	default:
+		return "", fmt.Errorf("sql dsn must use _txlock=immediate, not %q", txlock)
+	}
+	switch {
+	case !strings.Contains(dsn, "?"):
+		dsn += "?"
+	case query != "":
+		dsn += "&"
+	}
+	return dsn + "_txlock=immediate", nil
+}
+
+// Database keeps transactions, windows and profiles in a relational
+// database. The state of a customer is read and written in one transaction
+// that holds the customer locked, so concurrent loads of the same customer,
+// even from different processes, cannot both pass a limit.
+//
+// DailyTransaction.Transaction is not kept.
+type Database struct {
+	db            *sql.DB
+	retentionDays int
+}
+
+type Option func(d *Database)
+
+// WithRetention sets for how many days windows are kept.
+func WithRetention(days int) Option {
+	return func(d *Database) {
+		if days > 0 {
+			d.retentionDays = days
+		}
+	}
+}
+
+// Open opens the database of driver at dsn, with _txlock=immediate, and
+// migrates it.
+func Open(driver, dsn string, opts ...Option) (*Database, error) {
+	if err := CheckDriver(driver); err != nil {
+		return nil, err
+	}
+	dsn, err := immediateDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error to open sql database: %w", err)
+	}
+	d, err := New(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// New uses db, migrating it to the latest schema. db must have been opened
+// with _txlock=immediate, as Open does.
+func New(db *sql.DB, opts ...Option) (*Database, error) {
+	d := &Database{db: db, retentionDays: DefaultRetentionDays}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.Migrate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+// querier is what *sql.DB and *sql.Tx have in common.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// inTx runs fn in a transaction, committing it when fn returns no error.
+// With _txlock=immediate the transaction holds the write lock from its
+// start, which serializes it with every other writer.
+func (d *Database) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) AddTransaction(transaction domain.Transaction) error {
+	if transaction.ID == "" {
+		return domain.ErrTransactionEmptyID
+	}
+	result, err := d.db.Exec(
+		`INSERT INTO transactions (customer_id, id, amount, currency, time, type, reverses) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (customer_id, id) DO NOTHING`,
+		transaction.CustomerID,
+		transaction.ID,
+		transaction.LoadAmount.Amount,
+		transaction.LoadAmount.Currency,
+		transaction.Time.Format(time.RFC3339Nano),
+		string(transaction.Type),
+		transaction.Reverses,
+	)
+	if err != nil {
+		return fmt.Errorf("error to insert transaction: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error to insert transaction: %w", err)
+	}
+	if inserted == 0 {
+		return domain.ErrTransactionAlreadyExist
+	}
+	return nil
+}
+
+func (d *Database) GetTransaction(customerID, id string) (domain.Transaction, error) {
+	transaction := domain.Transaction{ID: id, CustomerID: customerID}
+	var at string
+	err := d.db.QueryRow(
+		`SELECT amount, currency, time, type, reverses, reversed_by FROM transactions WHERE customer_id = ? AND id = ?`,
+		customerID, id,
+	).Scan(
+		&transaction.LoadAmount.Amount,
+		&transaction.LoadAmount.Currency,
+		&at,
+		&transaction.Type,
+		&transaction.Reverses,
+		&transaction.ReversedBy,
+	)
+	if err == sql.ErrNoRows {
+		return domain.Transaction{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.Transaction{}, fmt.Errorf("error to select transaction: %w", err)
+	}
+	if transaction.Time, err = time.Parse(time.RFC3339Nano, at); err != nil {
+		return domain.Transaction{}, fmt.Errorf("error to parse transaction time: %w", err)
+	}
+	return transaction, nil
+}
+
+func (d *Database) SetDecision(customerID, id string, decision domain.TransactionDecision) error {
+	raw, err := json.Marshal(decision)
+	if err != nil {
+		return fmt.Errorf("error to encode decision: %w", err)
+	}
+	result, err := d.db.Exec(
+		`UPDATE transactions SET decision = ? WHERE customer_id = ? AND id = ?`,
+		string(raw), customerID, id,
+	)
+	if err != nil {
+		return fmt.Errorf("error to update decision: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error to update decision: %w", err)
+	}
+	if updated == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+// MarkReversed only sets reversed_by when it is empty or already the
+// reversal, and tells the other cases apart afterwards.
+func (d *Database) MarkReversed(customerID, id, reversalID string) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`UPDATE transactions SET reversed_by = ?
+			WHERE customer_id = ? AND id = ? AND (reversed_by = '' OR reversed_by = ?)`,
+			reversalID, customerID, id, reversalID,
+		)
+		if err != nil {
+			return fmt.Errorf("error to mark transaction reversed: %w", err)
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error to mark transaction reversed: %w", err)
+		}
+		if updated > 0 {
+			return nil
+		}
+		var exists int
+		err = tx.QueryRow(`SELECT 1 FROM transactions WHERE customer_id = ? AND id = ?`, customerID, id).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return domain.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error to select transaction: %w", err)
+		}
+		return domain.ErrAlreadyReversed
+	})
+}
+
+func (d *Database) UnmarkReversed(customerID, id, reversalID string) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRow(`SELECT 1 FROM transactions WHERE customer_id = ? AND id = ?`, customerID, id).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return domain.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error to select transaction: %w", err)
+		}
+		_, err = tx.Exec(
+			`UPDATE transactions SET reversed_by = '' WHERE customer_id = ? AND id = ? AND reversed_by = ?`,
+			customerID, id, reversalID,
+		)
+		if err != nil {
+			return fmt.Errorf("error to unmark transaction reversed: %w", err)
+		}
+		return nil
+	})
+}
+
+func (d *Database) GetDecision(customerID, id string) (domain.TransactionDecision, error) {
+	var raw sql.NullString
+	err := d.db.QueryRow(
+		`SELECT decision FROM transactions WHERE customer_id = ? AND id = ?`,
+		customerID, id,
+	).Scan(&raw)
+	if err == sql.ErrNoRows || (err == nil && !raw.Valid) {
+		return domain.TransactionDecision{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.TransactionDecision{}, fmt.Errorf("error to select decision: %w", err)
+	}
+	var decision domain.TransactionDecision
+	if err := json.Unmarshal([]byte(raw.String), &decision); err != nil {
+		return domain.TransactionDecision{}, fmt.Errorf("error to parse decision: %w", err)
+	}
+	return decision, nil
+}
+
+func (d *Database) AddDailyTransaction(customerID, day string, daily domain.DailyTransaction) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		return d.addDailyTransaction(tx, customerID, "", day, daily)
+	})
+}
+
+// addDailyTransaction writes the day and drops the days older than the
+// retention period before it.
+func (d *Database) addDailyTransaction(q querier, customerID string, kind domain.TransactionType, day string, daily domain.DailyTransaction) error {
+	_, err := q.Exec(
+		`INSERT INTO daily_windows (customer_id, kind, day, count, amount, currency) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (customer_id, kind, day) DO UPDATE
+		SET count = excluded.count, amount = excluded.amount, currency = excluded.currency`,
+		customerID, kind, day, daily.TransactionCount, daily.DailyTotal.Amount, daily.DailyTotal.Currency,
+	)
+	if err != nil {
+		return fmt.Errorf("error to upsert daily window: %w", err)
+	}
+	newest, err := time.Parse(domain.DateLayout, day)
+	if err != nil {
+		return nil
+	}
+	cutoff := newest.AddDate(0, 0, -d.retentionDays).Format(domain.DateLayout)
+	if _, err := q.Exec(`DELETE FROM daily_windows WHERE customer_id = ? AND kind = ? AND day < ?`, customerID, kind, cutoff); err != nil {
+		return fmt.Errorf("error to evict daily windows: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) AddWeeklyTransaction(customerID string, week domain.WeeklyTransaction, total domain.WeeklyTransactionTotal) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		return d.addWeeklyTransaction(tx, customerID, "", week, total)
+	})
+}
+
+// addWeeklyTransaction writes the week and drops the weeks that ended before
+// the retention period counted back from its start.
+func (d *Database) addWeeklyTransaction(q querier, customerID string, kind domain.TransactionType, week domain.WeeklyTransaction, total domain.WeeklyTransactionTotal) error {
+	_, err := q.Exec(
+		`INSERT INTO weekly_windows (customer_id, kind, year, week, amount, currency) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (customer_id, kind, year, week) DO UPDATE
+		SET amount = excluded.amount, currency = excluded.currency`,
+		customerID, kind, week.Year, week.Week, total.Value.Amount, total.Value.Currency,
+	)
+	if err != nil {
+		return fmt.Errorf("error to upsert weekly window: %w", err)
+	}
+	year, cutoff := week.Start().AddDate(0, 0, -d.retentionDays).ISOWeek()
+	_, err = q.Exec(
+		`DELETE FROM weekly_windows WHERE customer_id = ? AND kind = ? AND (year < ? OR (year = ? AND week < ?))`,
+		customerID, kind, year, year, cutoff,
+	)
+	if err != nil {
+		return fmt.Errorf("error to evict weekly windows: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) AddMonthlyTransaction(customerID string, month domain.MonthlyTransaction, total domain.MonthlyTransactionTotal) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		return d.addMonthlyTransaction(tx, customerID, "", month, total)
+	})
+}
+
+// addMonthlyTransaction writes the month and drops the months that ended
+// before the retention period counted back from its start.
+func (d *Database) addMonthlyTransaction(q querier, customerID string, kind domain.TransactionType, month domain.MonthlyTransaction, total domain.MonthlyTransactionTotal) error {
+	_, err := q.Exec(
+		`INSERT INTO monthly_windows (customer_id, kind, year, month, amount, currency) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (customer_id, kind, year, month) DO UPDATE
+		SET amount = excluded.amount, currency = excluded.currency`,
+		customerID, kind, month.Year, int(month.Month), total.Value.Amount, total.Value.Currency,
+	)
+	if err != nil {
+		return fmt.Errorf("error to upsert monthly window: %w", err)
+	}
+	cutoff := month.Start().AddDate(0, 0, -d.retentionDays)
+	_, err = q.Exec(
+		`DELETE FROM monthly_windows WHERE customer_id = ? AND kind = ? AND (year < ? OR (year = ? AND month < ?))`,
+		customerID, kind, cutoff.Year(), cutoff.Year(), int(cutoff.Month()),
+	)
+	if err != nil {
+		return fmt.Errorf("error to evict monthly windows: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) AddYearlyTransaction(customerID string, year domain.YearlyTransaction, total domain.YearlyTransactionTotal) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		return d.addYearlyTransaction(tx, customerID, "", year, total)
+	})
+}
+
+// addYearlyTransaction writes the year and drops the years that ended before
+// the retention period counted back from its start.
+func (d *Database) addYearlyTransaction(q querier, customerID string, kind domain.TransactionType, year domain.YearlyTransaction, total domain.YearlyTransactionTotal) error {
+	_, err := q.Exec(
+		`INSERT INTO yearly_windows (customer_id, kind, year, amount, currency) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (customer_id, kind, year) DO UPDATE
+		SET amount = excluded.amount, currency = excluded.currency`,
+		customerID, kind, year.Year, total.Value.Amount, total.Value.Currency,
+	)
+	if err != nil {
+		return fmt.Errorf("error to upsert yearly window: %w", err)
+	}
+	cutoff := time.Date(year.Year, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -d.retentionDays)
+	_, err = q.Exec(`DELETE FROM yearly_windows WHERE customer_id = ? AND kind = ? AND year < ?`, customerID, kind, cutoff.Year())
+	if err != nil {
+		return fmt.Errorf("error to evict yearly windows: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetDailyTransaction(customerID, day string) (domain.DailyTransaction, error) {
+	return d.getDailyTransaction(d.db, customerID, "", day)
+}
+
+func (d *Database) getDailyTransaction(q querier, customerID string, kind domain.TransactionType, day string) (domain.DailyTransaction, error) {
+	var daily domain.DailyTransaction
+	err := q.QueryRow(
+		`SELECT count, amount, currency FROM daily_windows WHERE customer_id = ? AND kind = ? AND day = ?`,
+		customerID, kind, day,
+	).Scan(&daily.TransactionCount, &daily.DailyTotal.Amount, &daily.DailyTotal.Currency)
+	if err == sql.ErrNoRows {
+		return domain.DailyTransaction{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.DailyTransaction{}, fmt.Errorf("error to select daily window: %w", err)
+	}
+	return daily, nil
+}
+
+func (d *Database) GetWeeklyTransaction(customerID string, week domain.WeeklyTransaction) (domain.WeeklyTransactionTotal, error) {
+	return d.getWeeklyTransaction(d.db, customerID, "", week)
+}
+
+func (d *Database) getWeeklyTransaction(q querier, customerID string, kind domain.TransactionType, week domain.WeeklyTransaction) (domain.WeeklyTransactionTotal, error) {
+	var total domain.WeeklyTransactionTotal
+	err := q.QueryRow(
+		`SELECT amount, currency FROM weekly_windows WHERE customer_id = ? AND kind = ? AND year = ? AND week = ?`,
+		customerID, kind, week.Year, week.Week,
+	).Scan(&total.Value.Amount, &total.Value.Currency)
+	if err == sql.ErrNoRows {
+		return domain.WeeklyTransactionTotal{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.WeeklyTransactionTotal{}, fmt.Errorf("error to select weekly window: %w", err)
+	}
+	return total, nil
+}
+
+func (d *Database) GetMonthlyTransaction(customerID string, month domain.MonthlyTransaction) (domain.MonthlyTransactionTotal, error) {
+	return d.getMonthlyTransaction(d.db, customerID, "", month)
+}
+
+func (d *Database) getMonthlyTransaction(q querier, customerID string, kind domain.TransactionType, month domain.MonthlyTransaction) (domain.MonthlyTransactionTotal, error) {
+	var total domain.MonthlyTransactionTotal
+	err := q.QueryRow(
+		`SELECT amount, currency FROM monthly_windows WHERE customer_id = ? AND kind = ? AND year = ? AND month = ?`,
+		customerID, kind, month.Year, int(month.Month),
+	).Scan(&total.Value.Amount, &total.Value.Currency)
+	if err == sql.ErrNoRows {
+		return domain.MonthlyTransactionTotal{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.MonthlyTransactionTotal{}, fmt.Errorf("error to select monthly window: %w", err)
+	}
+	return total, nil
+}
+
+func (d *Database) GetYearlyTransaction(customerID string, year domain.YearlyTransaction) (domain.YearlyTransactionTotal, error) {
+	return d.getYearlyTransaction(d.db, customerID, "", year)
+}
+
+func (d *Database) getYearlyTransaction(q querier, customerID string, kind domain.TransactionType, year domain.YearlyTransaction) (domain.YearlyTransactionTotal, error) {
+	var total domain.YearlyTransactionTotal
+	err := q.QueryRow(
+		`SELECT amount, currency FROM yearly_windows WHERE customer_id = ? AND kind = ? AND year = ?`,
+		customerID, kind, year.Year,
+	).Scan(&total.Value.Amount, &total.Value.Currency)
+	if err == sql.ErrNoRows {
+		return domain.YearlyTransactionTotal{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.YearlyTransactionTotal{}, fmt.Errorf("error to select yearly window: %w", err)
+	}
+	return total, nil
+}
+
+func (d *Database) ListDailyTransactions(customerID string, from, to time.Time) ([]domain.DailyWindow, error) {
+	rows, err := d.db.Query(
+		`SELECT day, count, amount, currency FROM daily_windows
+		WHERE customer_id = ? AND kind = '' AND day >= ? AND day <= ? ORDER BY day`,
+		customerID, from.Format(domain.DateLayout), to.Format(domain.DateLayout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error to select daily windows: %w", err)
+	}
+	defer rows.Close()
+	windows := []domain.DailyWindow{}
+	for rows.Next() {
+		var window domain.DailyWindow
+		if err := rows.Scan(&window.Day, &window.Daily.TransactionCount, &window.Daily.DailyTotal.Amount, &window.Daily.DailyTotal.Currency); err != nil {
+			return nil, fmt.Errorf("error to scan daily window: %w", err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, rows.Err()
+}
+
+func (d *Database) ListWeeklyTransactions(customerID string, from, to time.Time) ([]domain.WeeklyWindow, error) {
+	firstYear, firstWeek := from.ISOWeek()
+	lastYear, lastWeek := to.ISOWeek()
+	rows, err := d.db.Query(
+		`SELECT year, week, amount, currency FROM weekly_windows
+		WHERE customer_id = ? AND kind = ''
+		AND (year > ? OR (year = ? AND week >= ?))
+		AND (year < ? OR (year = ? AND week <= ?))
+		ORDER BY year, week`,
+		customerID, firstYear, firstYear, firstWeek, lastYear, lastYear, lastWeek,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error to select weekly windows: %w", err)
+	}
+	defer rows.Close()
+	windows := []domain.WeeklyWindow{}
+	for rows.Next() {
+		var window domain.WeeklyWindow
+		if err := rows.Scan(&window.Week.Year, &window.Week.Week, &window.Total.Value.Amount, &window.Total.Value.Currency); err != nil {
+			return nil, fmt.Errorf("error to scan weekly window: %w", err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, rows.Err()
+}
+
+// UpdateCustomerState creates the row of the customer when missing and reads
+// and writes the windows in the same immediate transaction, so the lock also
+// covers windows that do not exist yet.
+func (d *Database) UpdateCustomerState(customerID string, windows domain.Windows, update storage.StateUpdate) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		if err := d.lockCustomer(tx, customerID); err != nil {
+			return err
+		}
+		var state domain.CustomerState
+		daily, err := d.getDailyTransaction(tx, customerID, windows.Kind, windows.Day)
+		switch err {
+		case nil:
+			state.Daily = daily
+		case domain.ErrNotFound:
+		default:
+			return err
+		}
+		weekly, err := d.getWeeklyTransaction(tx, customerID, windows.Kind, windows.Week)
+		switch err {
+		case nil:
+			state.Weekly = weekly
+		case domain.ErrNotFound:
+		default:
+			return err
+		}
+		monthly, err := d.getMonthlyTransaction(tx, customerID, windows.Kind, windows.Month)
+		switch err {
+		case nil:
+			state.Monthly = monthly
+		case domain.ErrNotFound:
+		default:
+			return err
+		}
+		yearly, err := d.getYearlyTransaction(tx, customerID, windows.Kind, windows.Year)
+		switch err {
+		case nil:
+			state.Yearly = yearly
+		case domain.ErrNotFound:
+		default:
+			return err
+		}
+		if !windows.Since.IsZero() {
+			if state.Recent, err = d.loadHistory(tx, customerID, windows.Kind, windows.Since); err != nil {
+				return err
+			}
+		}
+		if windows.TransactionID != "" {
+			if state.Applied, err = d.applied(tx, customerID, windows.TransactionID); err != nil {
+				return err
+			}
+		}
+		state, commit, err := update(state)
+		if err != nil || !commit {
+			return err
+		}
+		if windows.TransactionID != "" {
+			if err := d.setApplied(tx, customerID, windows.TransactionID, state.Applied); err != nil {
+				return err
+			}
+		}
+		if err := d.addDailyTransaction(tx, customerID, windows.Kind, windows.Day, state.Daily); err != nil {
+			return err
+		}
+		if err := d.addWeeklyTransaction(tx, customerID, windows.Kind, windows.Week, state.Weekly); err != nil {
+			return err
+		}
+		if err := d.addMonthlyTransaction(tx, customerID, windows.Kind, windows.Month, state.Monthly); err != nil {
+			return err
+		}
+		if err := d.addYearlyTransaction(tx, customerID, windows.Kind, windows.Year, state.Yearly); err != nil {
+			return err
+		}
+		if windows.Since.IsZero() {
+			return nil
+		}
+		return d.setLoadHistory(tx, customerID, windows.Kind, windows.Since, state.Recent)
+	})
+}
+
+func (d *Database) applied(q querier, customerID, id string) (bool, error) {
+	var applied int
+	err := q.QueryRow(`SELECT 1 FROM applied_transactions WHERE customer_id = ? AND id = ?`, customerID, id).Scan(&applied)
+	switch err {
+	case nil:
+		return true, nil
+	case sql.ErrNoRows:
+		return false, nil
+	}
+	return false, fmt.Errorf("error to select applied transaction: %w", err)
+}
+
+func (d *Database) setApplied(q querier, customerID, id string, applied bool) error {
+	query := `DELETE FROM applied_transactions WHERE customer_id = ? AND id = ?`
+	if applied {
+		query = `INSERT INTO applied_transactions (customer_id, id) VALUES (?, ?) ON CONFLICT (customer_id, id) DO NOTHING`
+	}
+	if _, err := q.Exec(query, customerID, id); err != nil {
+		return fmt.Errorf("error to update applied transaction: %w", err)
+	}
+	return nil
+}
+
+// LoadHistory returns the accepted loads of the customer from since on,
+// oldest first.
+func (d *Database) LoadHistory(customerID string, since time.Time) ([]domain.LoadEvent, error) {
+	return d.loadHistory(d.db, customerID, "", since)
+}
+
+func (d *Database) loadHistory(q querier, customerID string, kind domain.TransactionType, since time.Time) ([]domain.LoadEvent, error) {
+	rows, err := q.Query(
+		`SELECT id, time, amount, currency FROM load_events
+		WHERE customer_id = ? AND kind = ? AND at >= ? ORDER BY at, id`,
+		customerID, kind, since.UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error to select load events: %w", err)
+	}
+	defer rows.Close()
+	var events []domain.LoadEvent
+	for rows.Next() {
+		var event domain.LoadEvent
+		var at string
+		if err := rows.Scan(&event.ID, &at, &event.Amount.Amount, &event.Amount.Currency); err != nil {
+			return nil, fmt.Errorf("error to scan load event: %w", err)
+		}
+		if event.Time, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, fmt.Errorf("error to parse load event time: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// SetLoadHistory replaces the loads of the customer from since on with
+// events, which must be in time order.
+func (d *Database) SetLoadHistory(customerID string, since time.Time, events []domain.LoadEvent) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		return d.setLoadHistory(tx, customerID, "", since, events)
+	})
+}
+
+// setLoadHistory also drops the loads older than the retention period before
+// the newest one written.
+func (d *Database) setLoadHistory(q querier, customerID string, kind domain.TransactionType, since time.Time, events []domain.LoadEvent) error {
+	_, err := q.Exec(`DELETE FROM load_events WHERE customer_id = ? AND kind = ? AND at >= ?`, customerID, kind, since.UnixNano())
+	if err != nil {
+		return fmt.Errorf("error to delete load events: %w", err)
+	}
+	for _, event := range events {
+		_, err := q.Exec(
+			`INSERT INTO load_events (customer_id, kind, id, at, time, amount, currency) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			customerID, kind, event.ID, event.Time.UnixNano(), event.Time.Format(time.RFC3339Nano), event.Amount.Amount, event.Amount.Currency,
+		)
+		if err != nil {
+			return fmt.Errorf("error to insert load event: %w", err)
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	cutoff := events[len(events)-1].Time.AddDate(0, 0, -d.retentionDays)
+	if _, err := q.Exec(`DELETE FROM load_events WHERE customer_id = ? AND kind = ? AND at < ?`, customerID, kind, cutoff.UnixNano()); err != nil {
+		return fmt.Errorf("error to evict load events: %w", err)
+	}
+	return nil
+}
+
+// PostEntries inserts the entries only when the transaction has none yet.
+func (d *Database) PostEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	return d.post(customerID, transactionID, entries, false)
+}
+
+// PostFundedEntries locks the customer before reading the balance, so
+// concurrent debits cannot both spend the same funds.
+func (d *Database) PostFundedEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	return d.post(customerID, transactionID, entries, true)
+}
+
+func (d *Database) post(customerID, transactionID string, entries []domain.LedgerEntry, funded bool) error {
+	if !domain.Balanced(entries) {
+		return domain.ErrUnbalancedEntries
+	}
+	return d.inTx(func(tx *sql.Tx) error {
+		if funded {
+			if err := d.lockCustomer(tx, customerID); err != nil {
+				return err
+			}
+		}
+		posted, err := d.posted(tx, customerID, transactionID)
+		if err != nil || posted {
+			return err
+		}
+		if funded {
+			balance, err := d.customerBalance(tx, customerID)
+			if err != nil {
+				return err
+			}
+			if balance.Add(domain.CustomerBalance(entries, customerID)).IsNegative() {
+				return domain.ErrInsufficientFunds
+			}
+		}
+		for seq, entry := range entries {
+			_, err := tx.Exec(
+				`INSERT INTO ledger_entries (customer_id, transaction_id, seq, account, side, amount, currency, at, time)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (customer_id, transaction_id, seq) DO NOTHING`,
+				customerID, transactionID, seq, string(entry.Account), string(entry.Side),
+				entry.Amount.Amount, entry.Amount.Currency, entry.Time.UnixNano(), entry.Time.Format(time.RFC3339Nano),
+			)
+			if err != nil {
+				return fmt.Errorf("error to insert ledger entry: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Posted reports whether the transaction of the customer has entries.
+func (d *Database) Posted(customerID, transactionID string) (bool, error) {
+	return d.posted(d.db, customerID, transactionID)
+}
+
+func (d *Database) posted(q querier, customerID, transactionID string) (bool, error) {
+	var posted int
+	err := q.QueryRow(
+		`SELECT 1 FROM ledger_entries WHERE customer_id = ? AND transaction_id = ? LIMIT 1`,
+		customerID, transactionID,
+	).Scan(&posted)
+	switch err {
+	case nil:
+		return true, nil
+	case sql.ErrNoRows:
+		return false, nil
+	}
+	return false, fmt.Errorf("error to select ledger entries: %w", err)
+}
+
+// customerBalance returns the credits minus the debits of the customer
+// account.
+func (d *Database) customerBalance(q querier, customerID string) (domain.Money, error) {
+	rows, err := q.Query(
+		`SELECT side, currency, SUM(amount) FROM ledger_entries
+		WHERE customer_id = ? AND account = ? GROUP BY side, currency`,
+		customerID, string(domain.CustomerAccount(customerID)),
+	)
+	if err != nil {
+		return domain.Money{}, fmt.Errorf("error to select customer balance: %w", err)
+	}
+	defer rows.Close()
+	var balance domain.Money
+	for rows.Next() {
+		var side domain.EntrySide
+		var total domain.Money
+		if err := rows.Scan(&side, &total.Currency, &total.Amount); err != nil {
+			return domain.Money{}, fmt.Errorf("error to scan customer balance: %w", err)
+		}
+		if side == domain.Credit {
+			balance = balance.Add(total)
+		} else {
+			balance = balance.Sub(total)
+		}
+	}
+	return balance, rows.Err()
+}
+
+func (d *Database) ListEntries(customerID string) ([]domain.LedgerEntry, error) {
+	rows, err := d.db.Query(
+		`SELECT transaction_id, account, side, amount, currency, time FROM ledger_entries
+		WHERE customer_id = ? ORDER BY at, transaction_id, seq`,
+		customerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error to select ledger entries: %w", err)
+	}
+	defer rows.Close()
+	entries := []domain.LedgerEntry{}
+	for rows.Next() {
+		entry := domain.LedgerEntry{CustomerID: customerID}
+		var at string
+		if err := rows.Scan(&entry.TransactionID, &entry.Account, &entry.Side, &entry.Amount.Amount, &entry.Amount.Currency, &at); err != nil {
+			return nil, fmt.Errorf("error to scan ledger entry: %w", err)
+		}
+		if entry.Time, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, fmt.Errorf("error to parse ledger entry time: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (d *Database) TrialBalance() ([]domain.AccountBalance, error) {
+	rows, err := d.db.Query(
+		`SELECT account, side, currency, SUM(amount) FROM ledger_entries
+		GROUP BY account, side, currency ORDER BY account`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error to select trial balance: %w", err)
+	}
+	defer rows.Close()
+	balances := []domain.AccountBalance{}
+	for rows.Next() {
+		var account domain.Account
+		var side domain.EntrySide
+		var total domain.Money
+		if err := rows.Scan(&account, &side, &total.Currency, &total.Amount); err != nil {
+			return nil, fmt.Errorf("error to scan trial balance: %w", err)
+		}
+		if len(balances) == 0 || balances[len(balances)-1].Account != account {
+			balances = append(balances, domain.AccountBalance{Account: account})
+		}
+		balance := &balances[len(balances)-1]
+		if side == domain.Credit {
+			balance.Credit = balance.Credit.Add(total)
+		} else {
+			balance.Debit = balance.Debit.Add(total)
+		}
+	}
+	return balances, rows.Err()
+}
+
+// lockCustomer creates the row of the customer when missing. The immediate
+// transaction it runs in already holds the write lock of the database, so
+// nothing else changes the customer until it commits.
+func (d *Database) lockCustomer(q querier, customerID string) error {
+	_, err := q.Exec(`INSERT INTO customers (customer_id) VALUES (?) ON CONFLICT (customer_id) DO NOTHING`, customerID)
+	if err != nil {
+		return fmt.Errorf("error to insert customer: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetCustomerProfile(customerID string) (domain.CustomerProfile, error) {
+	var raw sql.NullString
+	err := d.db.QueryRow(`SELECT profile FROM customers WHERE customer_id = ?`, customerID).Scan(&raw)
+	if err == sql.ErrNoRows || (err == nil && !raw.Valid) {
+		return domain.CustomerProfile{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.CustomerProfile{}, fmt.Errorf("error to select customer profile: %w", err)
+	}
+	var profile domain.CustomerProfile
+	if err := json.Unmarshal([]byte(raw.String), &profile); err != nil {
+		return domain.CustomerProfile{}, fmt.Errorf("error to parse customer profile: %w", err)
+	}
+	return profile, nil
+}
+
+func (d *Database) SetCustomerProfile(profile domain.CustomerProfile) error {
+	if profile.CustomerID == "" {
+		return domain.ErrCustomerEmptyID
+	}
+	raw, err := json.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("error to encode customer profile: %w", err)
+	}
+	_, err = d.db.Exec(
+		`INSERT INTO customers (customer_id, profile) VALUES (?, ?)
+		ON CONFLICT (customer_id) DO UPDATE SET profile = excluded.profile`,
+		profile.CustomerID, string(raw),
+	)
+	if err != nil {
+		return fmt.Errorf("error to upsert customer profile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+package sql_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+	sqlstorage "github.com/danielfmelo/load-funds-handler/storage/sql"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	fakeTime    = time.Date(2000, 1, 3, 10, 0, 0, 0, time.UTC)
+	fakeWindows = domain.Windows{
+		Day:   "2000-01-03",
+		Week:  domain.WeeklyTransaction{Year: 2000, Week: 1},
+		Month: domain.MonthlyTransaction{Year: 2000, Month: time.January},
+		Year:  domain.YearlyTransaction{Year: 2000},
+	}
+)
+
+// openSQLite opens a SQLite database in a temporary file, leaving Open to
+// add the _txlock=immediate that serializes writers.
+func openSQLite(t *testing.T, opts ...sqlstorage.Option) (*sqlstorage.Database, string, func()) {
+	dir, err := ioutil.TempDir("", "load-funds-sql")
+	assert.Nil(t, err)
+	dsn := "file:" + filepath.Join(dir, "loads.db") + "?_busy_timeout=5000"
+	d, err := sqlstorage.Open("sqlite3", dsn, opts...)
+	assert.Nil(t, err)
+	return d, dsn, func() {
+		d.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestOpenShouldMigrate(t *testing.T) {
+	d, dsn, cleanup := openSQLite(t)
+	defer cleanup()
+	version, err := d.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, 8, version)
+	assert.Nil(t, d.AddTransaction(domain.Transaction{ID: "1", CustomerID: "1", LoadAmount: domain.NewMoney(100), Time: fakeTime}))
+
+	reopened, err := sqlstorage.Open("sqlite3", dsn)
+	assert.Nil(t, err)
+	defer reopened.Close()
+	version, err = reopened.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, 8, version)
+	_, err = reopened.GetTransaction("1", "1")
+	assert.Nil(t, err)
+}
+
+func TestOpenShouldRejectUnknownDriver(t *testing.T) {
+	_, err := sqlstorage.Open("oracle", "")
+	assert.NotNil(t, err)
+}
+
+func TestOpenShouldRejectOtherTxlock(t *testing.T) {
+	_, err := sqlstorage.Open("sqlite3", "file:loads.db?_txlock=deferred")
+	assert.EqualError(t, err, `sql dsn must use _txlock=immediate, not "deferred"`)
+}
+
+func TestAddTransaction(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	transaction := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: fakeTime}
+	assert.Equal(t, domain.ErrTransactionEmptyID, d.AddTransaction(domain.Transaction{CustomerID: "1234"}))
+	assert.Nil(t, d.AddTransaction(transaction))
+	assert.Equal(t, domain.ErrTransactionAlreadyExist, d.AddTransaction(transaction))
+	other := transaction
+	other.CustomerID = "4321"
+	assert.Nil(t, d.AddTransaction(other))
+
+	stored, err := d.GetTransaction("1234", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, transaction, stored)
+	_, err = d.GetTransaction("1234", "321")
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestDecision(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	transaction := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: fakeTime}
+	decision := domain.TransactionDecision{Accepted: true}
+	assert.Equal(t, domain.ErrNotFound, d.SetDecision("1234", "123", decision))
+	assert.Nil(t, d.AddTransaction(transaction))
+	_, err := d.GetDecision("1234", "123")
+	assert.Equal(t, domain.ErrNotFound, err)
+	assert.Nil(t, d.SetDecision("1234", "123", decision))
+	stored, err := d.GetDecision("1234", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, decision, stored)
+}
+
+func TestMarkReversed(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	transaction := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: fakeTime}
+	reversal := domain.Transaction{ID: "124", CustomerID: "1234", Time: fakeTime, Type: domain.TransactionReversal, Reverses: "123"}
+	assert.Equal(t, domain.ErrNotFound, d.MarkReversed("1234", "123", "124"))
+	assert.Nil(t, d.AddTransaction(transaction))
+	assert.Nil(t, d.AddTransaction(reversal))
+	assert.Nil(t, d.MarkReversed("1234", "123", "124"))
+	assert.Nil(t, d.MarkReversed("1234", "123", "124"))
+	assert.Equal(t, domain.ErrAlreadyReversed, d.MarkReversed("1234", "123", "125"))
+
+	stored, err := d.GetTransaction("1234", "123")
+	assert.Nil(t, err)
+	transaction.ReversedBy = "124"
+	assert.Equal(t, transaction, stored)
+	stored, err = d.GetTransaction("1234", "124")
+	assert.Nil(t, err)
+	assert.Equal(t, reversal, stored)
+}
+
+func TestUnmarkReversed(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	transaction := domain.Transaction{ID: "123", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: fakeTime}
+	assert.Equal(t, domain.ErrNotFound, d.UnmarkReversed("1234", "123", "124"))
+	assert.Nil(t, d.AddTransaction(transaction))
+	assert.Nil(t, d.MarkReversed("1234", "123", "124"))
+	assert.Nil(t, d.UnmarkReversed("1234", "123", "125"))
+	assert.Equal(t, domain.ErrAlreadyReversed, d.MarkReversed("1234", "123", "125"))
+	assert.Nil(t, d.UnmarkReversed("1234", "123", "124"))
+	assert.Nil(t, d.MarkReversed("1234", "123", "125"))
+	stored, err := d.GetTransaction("1234", "123")
+	assert.Nil(t, err)
+	assert.Equal(t, "125", stored.ReversedBy)
+}
+
+func TestPostEntries(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	first := domain.Transaction{ID: "1", CustomerID: "1234", LoadAmount: domain.NewMoney(300), Time: fakeTime}
+	late := domain.Transaction{ID: "2", CustomerID: "1234", LoadAmount: domain.NewMoney(100), Time: fakeTime.Add(-time.Hour)}
+	reversal := domain.Transaction{ID: "3", CustomerID: "1234", Time: fakeTime.Add(time.Hour), Type: domain.TransactionReversal, Reverses: "1"}
+	other := domain.Transaction{ID: "1", CustomerID: "4321", LoadAmount: domain.NewMoney(50), Time: fakeTime}
+	assert.Nil(t, d.PostEntries("1234", "1", domain.LoadEntries(first)))
+	assert.Nil(t, d.PostEntries("1234", "1", domain.LoadEntries(first)))
+	assert.Nil(t, d.PostEntries("1234", "2", domain.LoadEntries(late)))
+	assert.Nil(t, d.PostEntries("1234", "3", domain.ReversalEntries(reversal, first)))
+	assert.Nil(t, d.PostEntries("4321", "1", domain.LoadEntries(other)))
+	assert.Equal(t, domain.ErrUnbalancedEntries, d.PostEntries("4321", "2", domain.LoadEntries(other)[:1]))
+
+	entries, err := d.ListEntries("1234")
+	assert.Nil(t, err)
+	expected := append(append(domain.LoadEntries(late), domain.LoadEntries(first)...), domain.ReversalEntries(reversal, first)...)
+	assert.Equal(t, expected, entries)
+
+	balances, err := d.TrialBalance()
+	assert.Nil(t, err)
+	assert.Equal(t, []domain.AccountBalance{
+		{Account: domain.CustomerAccount("1234"), Debit: domain.NewMoney(300), Credit: domain.NewMoney(400)},
+		{Account: domain.CustomerAccount("4321"), Credit: domain.NewMoney(50)},
+		{Account: domain.SettlementAccount, Debit: domain.NewMoney(450), Credit: domain.NewMoney(300)},
+	}, balances)
+}
+
+func TestPostFundedEntries(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	at := fakeTime
+	load := domain.Transaction{ID: "1", CustomerID: "1234", LoadAmount: domain.NewMoney(300), Time: at}
+	spend := domain.Transaction{ID: "2", CustomerID: "1234", LoadAmount: domain.NewMoney(200), Time: at, Type: domain.TransactionSpend}
+	withdrawal := domain.Transaction{ID: "3", CustomerID: "1234", LoadAmount: domain.NewMoney(200), Time: at, Type: domain.TransactionWithdrawal}
+	assert.Equal(t, domain.ErrInsufficientFunds, d.PostFundedEntries("1234", "2", domain.Entries(spend)))
+	assert.Nil(t, d.PostEntries("1234", "1", domain.Entries(load)))
+	assert.Nil(t, d.PostFundedEntries("1234", "2", domain.Entries(spend)))
+	assert.Nil(t, d.PostFundedEntries("1234", "2", domain.Entries(spend)))
+	assert.Equal(t, domain.ErrInsufficientFunds, d.PostFundedEntries("1234", "3", domain.Entries(withdrawal)))
+	assert.Equal(t, domain.ErrUnbalancedEntries, d.PostFundedEntries("1234", "3", domain.Entries(withdrawal)[:1]))
+
+	entries, err := d.ListEntries("1234")
+	assert.Nil(t, err)
+	assert.Equal(t, append(domain.Entries(load), domain.Entries(spend)...), entries)
+}
+
+func TestAddAndGetWindows(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	_, err := d.GetDailyTransaction("1", "2000-01-03")
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = d.GetWeeklyTransaction("1", fakeWindows.Week)
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = d.GetMonthlyTransaction("1", fakeWindows.Month)
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = d.GetYearlyTransaction("1", fakeWindows.Year)
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	daily := domain.DailyTransaction{TransactionCount: 2, DailyTotal: domain.NewMoney(300)}
+	total := domain.WeeklyTransactionTotal{Value: domain.NewMoney(500)}
+	assert.Nil(t, d.AddDailyTransaction("1", "2000-01-03", domain.DailyTransaction{TransactionCount: 1, DailyTotal: domain.NewMoney(100)}))
+	assert.Nil(t, d.AddDailyTransaction("1", "2000-01-03", daily))
+	assert.Nil(t, d.AddWeeklyTransaction("1", fakeWindows.Week, total))
+
+	storedDaily, err := d.GetDailyTransaction("1", "2000-01-03")
+	assert.Nil(t, err)
+	assert.Equal(t, daily, storedDaily)
+	storedTotal, err := d.GetWeeklyTransaction("1", fakeWindows.Week)
+	assert.Nil(t, err)
+	assert.Equal(t, total, storedTotal)
+
+	monthly := domain.MonthlyTransactionTotal{Value: domain.NewMoney(700)}
+	yearly := domain.YearlyTransactionTotal{Value: domain.NewMoney(900)}
+	assert.Nil(t, d.AddMonthlyTransaction("1", fakeWindows.Month, domain.MonthlyTransactionTotal{Value: domain.NewMoney(1)}))
+	assert.Nil(t, d.AddMonthlyTransaction("1", fakeWindows.Month, monthly))
+	assert.Nil(t, d.AddYearlyTransaction("1", fakeWindows.Year, yearly))
+	storedMonthly, err := d.GetMonthlyTransaction("1", fakeWindows.Month)
+	assert.Nil(t, err)
+	assert.Equal(t, monthly, storedMonthly)
+	storedYearly, err := d.GetYearlyTransaction("1", fakeWindows.Year)
+	assert.Nil(t, err)
+	assert.Equal(t, yearly, storedYearly)
+}
+
+func TestListWindows(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	start := time.Date(1999, 12, 20, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 28; i += 3 {
+		day := start.AddDate(0, 0, i)
+		year, week := day.ISOWeek()
+		assert.Nil(t, d.AddDailyTransaction("1", day.Format(domain.DateLayout), domain.DailyTransaction{TransactionCount: 1, DailyTotal: domain.NewMoney(int64(i))}))
+		assert.Nil(t, d.AddWeeklyTransaction("1", domain.WeeklyTransaction{Year: year, Week: week}, domain.WeeklyTransactionTotal{Value: domain.NewMoney(int64(i))}))
+	}
+
+	daily, err := d.ListDailyTransactions("1", time.Date(1999, 12, 26, 0, 0, 0, 0, time.UTC), time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	days := []string{}
+	for _, window := range daily {
+		days = append(days, window.Day)
+	}
+	assert.Equal(t, []string{"1999-12-26", "1999-12-29", "2000-01-01", "2000-01-04"}, days)
+
+	weekly, err := d.ListWeeklyTransactions("1", time.Date(1999, 12, 28, 0, 0, 0, 0, time.UTC), time.Date(2000, 1, 10, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	weeks := []domain.WeeklyTransaction{}
+	for _, window := range weekly {
+		weeks = append(weeks, window.Week)
+	}
+	assert.Equal(t, []domain.WeeklyTransaction{{Year: 1999, Week: 52}, {Year: 2000, Week: 1}, {Year: 2000, Week: 2}}, weeks)
+}
+
+func TestAddWindowsShouldEvictOutOfRetention(t *testing.T) {
+	d, _, cleanup := openSQLite(t, sqlstorage.WithRetention(7))
+	defer cleanup()
+	assert.Nil(t, d.AddDailyTransaction("1", "2000-01-03", domain.DailyTransaction{TransactionCount: 1}))
+	assert.Nil(t, d.AddDailyTransaction("2", "2000-01-03", domain.DailyTransaction{TransactionCount: 1}))
+	assert.Nil(t, d.AddWeeklyTransaction("1", domain.WeeklyTransaction{Year: 2000, Week: 1}, domain.WeeklyTransactionTotal{}))
+	assert.Nil(t, d.AddDailyTransaction("1", "2000-01-11", domain.DailyTransaction{TransactionCount: 1}))
+	assert.Nil(t, d.AddWeeklyTransaction("1", domain.WeeklyTransaction{Year: 2000, Week: 3}, domain.WeeklyTransactionTotal{}))
+
+	_, err := d.GetDailyTransaction("1", "2000-01-03")
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = d.GetDailyTransaction("2", "2000-01-03")
+	assert.Nil(t, err)
+	_, err = d.GetWeeklyTransaction("1", domain.WeeklyTransaction{Year: 2000, Week: 1})
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = d.GetWeeklyTransaction("1", domain.WeeklyTransaction{Year: 2000, Week: 3})
+	assert.Nil(t, err)
+
+	assert.Nil(t, d.AddMonthlyTransaction("1", domain.MonthlyTransaction{Year: 2000, Month: time.January}, domain.MonthlyTransactionTotal{}))
+	assert.Nil(t, d.AddMonthlyTransaction("1", domain.MonthlyTransaction{Year: 2000, Month: time.February}, domain.MonthlyTransactionTotal{}))
+	assert.Nil(t, d.AddMonthlyTransaction("1", domain.MonthlyTransaction{Year: 2000, Month: time.March}, domain.MonthlyTransactionTotal{}))
+	_, err = d.GetMonthlyTransaction("1", domain.MonthlyTransaction{Year: 2000, Month: time.January})
+	assert.Equal(t, domain.ErrNotFound, err)
+	_, err = d.GetMonthlyTransaction("1", domain.MonthlyTransaction{Year: 2000, Month: time.February})
+	assert.Nil(t, err)
+	assert.Nil(t, d.AddYearlyTransaction("1", domain.YearlyTransaction{Year: 1999}, domain.YearlyTransactionTotal{}))
+	assert.Nil(t, d.AddYearlyTransaction("1", domain.YearlyTransaction{Year: 2000}, domain.YearlyTransactionTotal{}))
+	_, err = d.GetYearlyTransaction("1", domain.YearlyTransaction{Year: 1999})
+	assert.Nil(t, err)
+	assert.Nil(t, d.AddYearlyTransaction("1", domain.YearlyTransaction{Year: 2001}, domain.YearlyTransactionTotal{}))
+	_, err = d.GetYearlyTransaction("1", domain.YearlyTransaction{Year: 1999})
+	assert.Equal(t, domain.ErrNotFound, err)
+}
+
+func TestUpdateCustomerState(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	delta := domain.StateDelta{
+		DailyAmount:   domain.NewMoney(100),
+		DailyCount:    1,
+		WeeklyAmount:  domain.NewMoney(100),
+		MonthlyAmount: domain.NewMoney(100),
+		YearlyAmount:  domain.NewMoney(100),
+	}
+	var read domain.CustomerState
+	err := d.UpdateCustomerState("1", fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		read = state
+		return state.Apply(delta), true, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.CustomerState{}, read)
+
+	err = d.UpdateCustomerState("1", fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		read = state
+		return state.Apply(delta), false, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, read.Daily.TransactionCount)
+
+	fakeErr := errors.New("some error")
+	err = d.UpdateCustomerState("1", fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state.Apply(delta), true, fakeErr
+	})
+	assert.Equal(t, fakeErr, err)
+
+	daily, err := d.GetDailyTransaction("1", fakeWindows.Day)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.DailyTransaction{TransactionCount: 1, DailyTotal: domain.NewMoney(100)}, daily)
+	weekly, err := d.GetWeeklyTransaction("1", fakeWindows.Week)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(100), weekly.Value)
+	monthly, err := d.GetMonthlyTransaction("1", fakeWindows.Month)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(100), monthly.Value)
+	yearly, err := d.GetYearlyTransaction("1", fakeWindows.Year)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(100), yearly.Value)
+}
+
+func TestUpdateCustomerStateShouldKeepLoadHistory(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	for i := 0; i < 3; i++ {
+		event := domain.LoadEvent{ID: strconv.Itoa(i), Time: fakeTime.Add(time.Duration(i) * time.Hour), Amount: domain.NewMoney(100)}
+		windows := fakeWindows
+		windows.Since = event.Time.Add(-24 * time.Hour)
+		err := d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Len(t, state.Recent, i)
+			return state.Record(event), true, nil
+		})
+		assert.Nil(t, err)
+	}
+	windows := fakeWindows
+	windows.Since = fakeTime.Add(90 * time.Minute)
+	err := d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		assert.Len(t, state.Recent, 1)
+		state.Recent = nil
+		return state, true, nil
+	})
+	assert.Nil(t, err)
+	history, err := d.LoadHistory("1", fakeTime)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "1", history[1].ID)
+	assert.True(t, fakeTime.Add(time.Hour).Equal(history[1].Time))
+	assert.Equal(t, domain.NewMoney(100), history[1].Amount)
+}
+
+func TestUpdateCustomerStateShouldKeepApplied(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	applied := func(id string, expected, applied bool) {
+		windows := fakeWindows
+		windows.TransactionID = id
+		err := d.UpdateCustomerState("1", windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Equal(t, expected, state.Applied)
+			state.Applied = applied
+			return state, true, nil
+		})
+		assert.Nil(t, err)
+	}
+	applied("1", false, true)
+	applied("1", true, true)
+	applied("2", false, false)
+	applied("1", true, false)
+	applied("1", false, false)
+}
+
+func TestUpdateCustomerStateShouldKeepKindsApart(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	count := func(customerID string, kind domain.TransactionType, expected int) {
+		windows := fakeWindows
+		windows.Since = fakeTime.Add(-24 * time.Hour)
+		windows.Kind = kind
+		err := d.UpdateCustomerState(customerID, windows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+			assert.Equal(t, expected, state.Daily.TransactionCount)
+			assert.Len(t, state.Recent, expected)
+			state.Daily.TransactionCount++
+			state.Yearly.Value = state.Yearly.Value.Add(domain.NewMoney(100))
+			state = state.Record(domain.LoadEvent{ID: strconv.Itoa(expected), Time: fakeTime, Amount: domain.NewMoney(100)})
+			return state, true, nil
+		})
+		assert.Nil(t, err)
+	}
+	count("1", domain.TransactionWithdrawal, 0)
+	count("1", domain.TransactionWithdrawal, 1)
+	count("1", "", 0)
+	count("withdrawal:1", "", 0)
+	count("1", domain.TransactionSpend, 0)
+	daily, err := d.GetDailyTransaction("1", fakeWindows.Day)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, daily.TransactionCount)
+	yearly, err := d.GetYearlyTransaction("1", fakeWindows.Year)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.NewMoney(100), yearly.Value)
+}
+
+func TestUpdateCustomerStateShouldBeAtomic(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	loads, customers := 20, 3
+	var wg sync.WaitGroup
+	for c := 0; c < customers; c++ {
+		for l := 0; l < loads; l++ {
+			wg.Add(1)
+			go func(customerID string) {
+				defer wg.Done()
+				err := d.UpdateCustomerState(customerID, fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+					return state.Apply(domain.StateDelta{DailyCount: 1, DailyAmount: domain.NewMoney(1), WeeklyAmount: domain.NewMoney(1)}), true, nil
+				})
+				assert.Nil(t, err)
+			}(strconv.Itoa(c))
+		}
+	}
+	wg.Wait()
+	for c := 0; c < customers; c++ {
+		daily, err := d.GetDailyTransaction(strconv.Itoa(c), fakeWindows.Day)
+		assert.Nil(t, err)
+		assert.Equal(t, loads, daily.TransactionCount)
+		weekly, err := d.GetWeeklyTransaction(strconv.Itoa(c), fakeWindows.Week)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.NewMoney(int64(loads)), weekly.Value)
+	}
+}
+
+func TestCustomerProfile(t *testing.T) {
+	d, _, cleanup := openSQLite(t)
+	defer cleanup()
+	_, err := d.GetCustomerProfile("1")
+	assert.Equal(t, domain.ErrNotFound, err)
+	assert.Equal(t, domain.ErrCustomerEmptyID, d.SetCustomerProfile(domain.CustomerProfile{}))
+
+	// A customer locked by a state update has no profile yet.
+	err = d.UpdateCustomerState("1", fakeWindows, func(state domain.CustomerState) (domain.CustomerState, bool, error) {
+		return state, false, nil
+	})
+	assert.Nil(t, err)
+	_, err = d.GetCustomerProfile("1")
+	assert.Equal(t, domain.ErrNotFound, err)
+
+	count := 5
+	profile := domain.CustomerProfile{CustomerID: "1", Tier: domain.TierPremium, Override: domain.LimitOverride{DailyCount: &count}}
+	assert.Nil(t, d.SetCustomerProfile(profile))
+	stored, err := d.GetCustomerProfile("1")
+	assert.Nil(t, err)
+	assert.Equal(t, profile, stored)
+}
@@ -1,11 +1,76 @@
 package storage
 
-import "github.com/danielfmelo/load-funds-handler/domain"
+import (
+	"time"
+
+	"github.com/danielfmelo/load-funds-handler/domain"
+)
 
 type Database interface {
 	AddTransaction(transaction domain.Transaction) error
+	GetTransaction(customerID, id string) (domain.Transaction, error)
+	// SetDecision keeps the response given to a transaction, so that it can
+	// be given again when the transaction is retried.
+	SetDecision(customerID, id string, decision domain.TransactionDecision) error
+	GetDecision(customerID, id string) (domain.TransactionDecision, error)
+	// MarkReversed records that the transaction was reversed by reversalID.
+	// Marking it again with the same reversal does nothing, while marking it
+	// with another one returns domain.ErrAlreadyReversed.
+	MarkReversed(customerID, id, reversalID string) error
+	// UnmarkReversed undoes the mark of reversalID, for a reversal that was
+	// rejected after marking. It does nothing when the transaction is not
+	// marked with reversalID.
+	UnmarkReversed(customerID, id, reversalID string) error
 	AddDailyTransaction(customerID, day string, daily domain.DailyTransaction) error
 	AddWeeklyTransaction(customerID string, week domain.WeeklyTransaction, total domain.WeeklyTransactionTotal) error
 	GetDailyTransaction(customerID, day string) (domain.DailyTransaction, error)
 	GetWeeklyTransaction(customerID string, week domain.WeeklyTransaction) (domain.WeeklyTransactionTotal, error)
+	AddMonthlyTransaction(customerID string, month domain.MonthlyTransaction, total domain.MonthlyTransactionTotal) error
+	GetMonthlyTransaction(customerID string, month domain.MonthlyTransaction) (domain.MonthlyTransactionTotal, error)
+	AddYearlyTransaction(customerID string, year domain.YearlyTransaction, total domain.YearlyTransactionTotal) error
+	GetYearlyTransaction(customerID string, year domain.YearlyTransaction) (domain.YearlyTransactionTotal, error)
+	// UpdateCustomerState reads the counters of the customer in the given
+	// windows, zero when missing, and passes them to update. When update
+	// returns true and no error, the returned state replaces all of those
+	// counters. Reading and writing happen atomically with respect to any
+	// other call for the same customer.
+	UpdateCustomerState(customerID string, windows domain.Windows, update StateUpdate) error
+}
+
+// StateUpdate receives the current state and returns the new state and
+// whether it should be committed.
+type StateUpdate func(state domain.CustomerState) (domain.CustomerState, bool, error)
+
+type CustomerProfiles interface {
+	GetCustomerProfile(customerID string) (domain.CustomerProfile, error)
+	SetCustomerProfile(profile domain.CustomerProfile) error
+}
+
+// Ledger keeps the entries posted by the transactions of each customer.
+type Ledger interface {
+	// PostEntries records the entries of a transaction, which must balance.
+	// Posting again for a transaction already posted does nothing, so a
+	// retried transaction is never posted twice.
+	PostEntries(customerID, transactionID string, entries []domain.LedgerEntry) error
+	// PostFundedEntries records the entries like PostEntries, but only when
+	// the balance of the customer stays positive or zero after them; it
+	// returns domain.ErrInsufficientFunds otherwise.
+	PostFundedEntries(customerID, transactionID string, entries []domain.LedgerEntry) error
+	// Posted reports whether entries were posted for the transaction.
+	Posted(customerID, transactionID string) (bool, error)
+	// ListEntries returns the entries posted by the transactions of the
+	// customer, oldest first.
+	ListEntries(customerID string) ([]domain.LedgerEntry, error)
+	// TrialBalance returns the totals of every account, ordered by account.
+	TrialBalance() ([]domain.AccountBalance, error)
+}
+
+// History lists the windows kept for a customer.
+type History interface {
+	// ListDailyTransactions returns the days between from and to, inclusive,
+	// in chronological order.
+	ListDailyTransactions(customerID string, from, to time.Time) ([]domain.DailyWindow, error)
+	// ListWeeklyTransactions returns the ISO weeks overlapping from and to,
+	// in chronological order.
+	ListWeeklyTransactions(customerID string, from, to time.Time) ([]domain.WeeklyWindow, error)
 }
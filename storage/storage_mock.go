@@ -14,6 +14,31 @@ func (sm *StorageMock) AddTransaction(transaction domain.Transaction) error {
 	return args.Error(0)
 }
 
+func (sm *StorageMock) GetTransaction(customerID, id string) (domain.Transaction, error) {
+	args := sm.Called(customerID, id)
+	return args.Get(0).(domain.Transaction), args.Error(1)
+}
+
+func (sm *StorageMock) SetDecision(customerID, id string, decision domain.TransactionDecision) error {
+	args := sm.Called(customerID, id, decision)
+	return args.Error(0)
+}
+
+func (sm *StorageMock) GetDecision(customerID, id string) (domain.TransactionDecision, error) {
+	args := sm.Called(customerID, id)
+	return args.Get(0).(domain.TransactionDecision), args.Error(1)
+}
+
+func (sm *StorageMock) MarkReversed(customerID, id, reversalID string) error {
+	args := sm.Called(customerID, id, reversalID)
+	return args.Error(0)
+}
+
+func (sm *StorageMock) UnmarkReversed(customerID, id, reversalID string) error {
+	args := sm.Called(customerID, id, reversalID)
+	return args.Error(0)
+}
+
 func (sm *StorageMock) AddDailyTransaction(customerID, day string, daily domain.DailyTransaction) error {
 	args := sm.Called(customerID, day)
 	return args.Error(0)
@@ -33,3 +58,84 @@ func (sm *StorageMock) GetWeeklyTransaction(customerID string, week domain.Weekl
 	args := sm.Called(customerID, week)
 	return args.Get(0).(domain.WeeklyTransactionTotal), args.Error(1)
 }
+
+func (sm *StorageMock) AddMonthlyTransaction(customerID string, month domain.MonthlyTransaction, total domain.MonthlyTransactionTotal) error {
+	args := sm.Called(customerID, month, total)
+	return args.Error(0)
+}
+
+func (sm *StorageMock) GetMonthlyTransaction(customerID string, month domain.MonthlyTransaction) (domain.MonthlyTransactionTotal, error) {
+	args := sm.Called(customerID, month)
+	return args.Get(0).(domain.MonthlyTransactionTotal), args.Error(1)
+}
+
+func (sm *StorageMock) AddYearlyTransaction(customerID string, year domain.YearlyTransaction, total domain.YearlyTransactionTotal) error {
+	args := sm.Called(customerID, year, total)
+	return args.Error(0)
+}
+
+func (sm *StorageMock) GetYearlyTransaction(customerID string, year domain.YearlyTransaction) (domain.YearlyTransactionTotal, error) {
+	args := sm.Called(customerID, year)
+	return args.Get(0).(domain.YearlyTransactionTotal), args.Error(1)
+}
+
+// UpdateCustomerState runs update against the state given to Return and
+// reports a committed state as a call to CommitCustomerState, so tests can
+// set expectations on it.
+func (sm *StorageMock) UpdateCustomerState(customerID string, windows domain.Windows, update StateUpdate) error {
+	args := sm.Called(customerID, windows)
+	if err := args.Error(1); err != nil {
+		return err
+	}
+	state, commit, err := update(args.Get(0).(domain.CustomerState))
+	if err != nil {
+		return err
+	}
+	if commit {
+		sm.MethodCalled("CommitCustomerState", customerID, state)
+	}
+	return nil
+}
+
+type CustomerProfilesMock struct {
+	mock.Mock
+}
+
+func (cm *CustomerProfilesMock) GetCustomerProfile(customerID string) (domain.CustomerProfile, error) {
+	args := cm.Called(customerID)
+	return args.Get(0).(domain.CustomerProfile), args.Error(1)
+}
+
+func (cm *CustomerProfilesMock) SetCustomerProfile(profile domain.CustomerProfile) error {
+	args := cm.Called(profile)
+	return args.Error(0)
+}
+
+type LedgerMock struct {
+	mock.Mock
+}
+
+func (lm *LedgerMock) PostEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	args := lm.Called(customerID, transactionID, entries)
+	return args.Error(0)
+}
+
+func (lm *LedgerMock) PostFundedEntries(customerID, transactionID string, entries []domain.LedgerEntry) error {
+	args := lm.Called(customerID, transactionID, entries)
+	return args.Error(0)
+}
+
+func (lm *LedgerMock) Posted(customerID, transactionID string) (bool, error) {
+	args := lm.Called(customerID, transactionID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (lm *LedgerMock) ListEntries(customerID string) ([]domain.LedgerEntry, error) {
+	args := lm.Called(customerID)
+	return args.Get(0).([]domain.LedgerEntry), args.Error(1)
+}
+
+func (lm *LedgerMock) TrialBalance() ([]domain.AccountBalance, error) {
+	args := lm.Called()
+	return args.Get(0).([]domain.AccountBalance), args.Error(1)
+}